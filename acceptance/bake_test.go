@@ -2,6 +2,8 @@ package acceptance_test
 
 import (
 	"archive/zip"
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -181,8 +183,8 @@ var _ = Describe("bake command", func() {
 		Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding metadata/metadata.yml to %s...", outputFile)))
 		Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding migrations/v1/201603041539_custom_buildpacks.js to %s...", outputFile)))
 		Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding migrations/v1/201603071158_auth_enterprise_sso.js to %s...", outputFile)))
-		Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/diego-release-0.1467.1-3215.4.0.tgz to %s...", outputFile)))
 		Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/cf-release-235.0.0-3215.4.0.tgz to %s...", outputFile)))
+		Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/diego-release-0.1467.1-3215.4.0.tgz to %s...", outputFile)))
 		Eventually(session.Err).ShouldNot(gbytes.Say(fmt.Sprintf("Adding releases/not-a-tarball.txt to %s...", outputFile)))
 	})
 
@@ -311,6 +313,58 @@ var _ = Describe("bake command", func() {
 		})
 	})
 
+	Context("when the output file is -", func() {
+		It("streams the tile to stdout and checksums it on the way", func() {
+			for i, arg := range commandWithArgs {
+				if arg == "--output-file" {
+					commandWithArgs[i+1] = "-"
+				}
+			}
+			commandWithArgs = append(commandWithArgs,
+				"--sha256",
+				"--stemcells-directory", singleStemcellDirectory,
+			)
+
+			session, err := gexec.Start(exec.Command(pathToMain, commandWithArgs...), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(session).Should(gexec.Exit(0))
+
+			tile := session.Out.Contents()
+			zr, err := zip.NewReader(bytes.NewReader(tile), int64(len(tile)))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(zr.File[0].Name).To(Equal("metadata/metadata.yml"))
+
+			Expect(session.Err).To(gbytes.Say(fmt.Sprintf("SHA256 checksum: %x", sha256.Sum256(tile))))
+		})
+	})
+
+	Context("when the same tile is baked twice", func() {
+		BeforeEach(func() {
+			commandWithArgs = append(commandWithArgs,
+				"--sha256",
+				"--embed", "fixtures/var-dir",
+				"--migrations-directory", "fixtures/migrations",
+				"--stemcells-directory", singleStemcellDirectory,
+			)
+		})
+
+		It("produces byte-identical tiles", func() {
+			bakeChecksum := func() string {
+				session, err := gexec.Start(exec.Command(pathToMain, commandWithArgs...), GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(session).Should(gexec.Exit(0))
+
+				checksum, err := ioutil.ReadFile(fmt.Sprintf("%s.sha256", outputFile))
+				Expect(err).NotTo(HaveOccurred())
+				return string(checksum)
+			}
+
+			firstChecksum := bakeChecksum()
+			time.Sleep(time.Second)
+			Expect(bakeChecksum()).To(Equal(firstChecksum))
+		})
+	})
+
 	Context("when the --kilnfile flag is provided", func() {
 
 		It("generates a tile with the correct metadata including the stemcell criteria from the Kilnfile.lock", func() {
@@ -423,12 +477,32 @@ var _ = Describe("bake command", func() {
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding metadata/metadata.yml to %s...", outputFile)))
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding migrations/v1/201603041539_custom_buildpacks.js to %s...", outputFile)))
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding migrations/v1/201603071158_auth_enterprise_sso.js to %s...", outputFile)))
-			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/diego-release-0.1467.1-3215.4.0.tgz to %s...", outputFile)))
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/cf-release-235.0.0-3215.4.0.tgz to %s...", outputFile)))
+			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/diego-release-0.1467.1-3215.4.0.tgz to %s...", outputFile)))
 			Eventually(session.Err).ShouldNot(gbytes.Say(fmt.Sprintf("Adding releases/not-a-tarball.txt to %s...", outputFile)))
 		})
 
 		Context("failure cases", func() {
+			It("errors out when a release tarball is not in the Kilnfile.lock", func() {
+				commandWithArgs = []string{
+					"bake",
+					"--metadata", metadata,
+					"--output-file", outputFile,
+					"--releases-directory", someReleasesDirectory,
+					"--releases-directory", otherReleasesDirectory,
+					"--releases-directory", "fixtures/releases3",
+					"--kilnfile", someKilnfilePath,
+				}
+
+				command := exec.Command(pathToMain, commandWithArgs...)
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(1))
+				Expect(session.Err).To(gbytes.Say("release tarballs do not match Kilnfile.lock"))
+				Expect(session.Err).To(gbytes.Say("release uaa in uaa-release-1.0.0.tgz is not in Kilnfile.lock"))
+			})
+
 			It("Kilnfile.lock does not exist", func() {
 				commandWithArgs = []string{
 					"bake",
@@ -504,7 +578,7 @@ var _ = Describe("bake command", func() {
 	})
 
 	Context("when the --stub-releases flag is specified", func() {
-		It("creates a tile with empty release tarballs", func() {
+		It("creates a tile with stub release tarballs", func() {
 			commandWithArgs = append(commandWithArgs,
 				"--stemcells-directory", singleStemcellDirectory,
 				"--stub-releases",
@@ -528,14 +602,53 @@ var _ = Describe("bake command", func() {
 
 			for _, f := range zr.File {
 				if f.Name == "releases/cf-release-235.0.0-3215.4.0.tgz" {
-					Expect(f.UncompressedSize64).To(Equal(uint64(0)))
+					Expect(f.UncompressedSize64).NotTo(BeZero())
 				}
 
 				if f.Name == "releases/diego-release-0.1467.1-3215.4.0.tgz" {
-					Expect(f.UncompressedSize64).To(Equal(uint64(0)))
+					Expect(f.UncompressedSize64).NotTo(BeZero())
 				}
 			}
 		})
+
+		Context("when the --kilnfile flag is also provided", func() {
+			It("takes the releases from the Kilnfile.lock", func() {
+				commandWithArgs = []string{
+					"bake",
+					"--bosh-variables-directory", someBOSHVariablesDirectory,
+					"--forms-directory", someFormsDirectory,
+					"--forms-directory", someOtherFormsDirectory,
+					"--icon", someIconPath,
+					"--instance-groups-directory", someInstanceGroupsDirectory,
+					"--instance-groups-directory", someOtherInstanceGroupsDirectory,
+					"--jobs-directory", someJobsDirectory,
+					"--jobs-directory", someOtherJobsDirectory,
+					"--metadata", metadata,
+					"--metadata-only",
+					"--properties-directory", somePropertiesDirectory,
+					"--runtime-configs-directory", someRuntimeConfigsDirectory,
+					"--variable", "some-variable=some-variable-value",
+					"--variables-file", someVarFile,
+					"--version", "1.2.3",
+					"--kilnfile", someKilnfilePath,
+					"--stub-releases",
+				}
+
+				command := exec.Command(pathToMain, commandWithArgs...)
+
+				session, err := gexec.Start(command, GinkgoWriter, GinkgoWriter)
+				Expect(err).NotTo(HaveOccurred())
+
+				Eventually(session).Should(gexec.Exit(0))
+
+				Expect(string(session.Out.Contents())).To(ContainSubstring("file: cf-235.tgz"))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("sha1: " + cfSHA1))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("file: diego-0.1467.1.tgz"))
+				Expect(string(session.Out.Contents())).To(ContainSubstring("sha1: " + diegoSHA1))
+				Expect(string(session.Out.Contents())).NotTo(ContainSubstring("UNKNOWN"))
+				Eventually(session.Err).Should(gbytes.Say("Reading releases from Kilnfile.lock"))
+			})
+		})
 	})
 
 	Context("when no migrations are provided", func() {
@@ -571,7 +684,7 @@ var _ = Describe("bake command", func() {
 			}
 
 			Expect(emptyMigrationsFolderMode.IsDir()).To(BeTrue())
-			Expect(emptyMigrationsFolderModified).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(emptyMigrationsFolderModified).To(BeTemporally("==", time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)))
 
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Creating empty migrations folder in %s...", outputFile)))
 		})
@@ -640,7 +753,7 @@ var _ = Describe("bake command", func() {
 						Expect(content).To(Equal([]byte("content-of-other-file")))
 					}
 
-					Expect(f.FileHeader.Modified).To(BeTemporally("~", time.Now(), time.Minute))
+					Expect(f.FileHeader.Modified).To(BeTemporally("==", time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)))
 				}
 
 				Expect(seenSomeFile).To(BeTrue())
@@ -748,8 +861,8 @@ var _ = Describe("bake command", func() {
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Building %s", outputFile)))
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding metadata/metadata.yml to %s...", outputFile)))
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Creating empty migrations folder in %s...", outputFile)))
-			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/diego-release-0.1467.1-3215.4.0.tgz to %s...", outputFile)))
 			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/cf-release-235.0.0-3215.4.0.tgz to %s...", outputFile)))
+			Eventually(session.Err).Should(gbytes.Say(fmt.Sprintf("Adding releases/diego-release-0.1467.1-3215.4.0.tgz to %s...", outputFile)))
 			Eventually(session.Err).ShouldNot(gbytes.Say(fmt.Sprintf("Adding releases/not-a-tarball.txt to %s...", outputFile)))
 		})
 	})
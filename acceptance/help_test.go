@@ -17,16 +17,19 @@ Usage: kiln [options] <command> [<args>]
   --version, -v  bool  prints the kiln release version (default: false)
 
 Commands:
-  bake     bakes a tile
-  fetch    fetches releases
-  help     prints this usage information
-  publish  prints this usage information
-  update   updates stemcell_criteria and releases
-  version  prints the kiln release version
+  bake               bakes a tile
+  fetch              fetches releases
+  help               prints this usage information
+  init               scaffolds a new tile
+  lock               works with Kilnfile.lock files
+  publish            prints this usage information
+  update             updates stemcell_criteria and releases
+  validate-kilnfile  validates a Kilnfile and Kilnfile.lock
+  version            prints the kiln release version
 `
 
 const BAKE_USAGE = `kiln bake
-Bakes tile metadata, stemcell, releases, and migrations into a format that can be consumed by OpsManager.
+Bakes tile metadata, stemcell, releases, and migrations into a format that can be consumed by OpsManager. Run without --metadata in a directory with a base.yml to pick up the standard tile layout created by kiln init.
 
 Usage: kiln [options] bake [<args>]
   --help, -h     bool  prints this usage information (default: false)
@@ -35,24 +38,35 @@ Usage: kiln [options] bake [<args>]
 Command Arguments:
   --bosh-variables-directory, -vd    string (variadic)  path to a directory containing BOSH variables
   --embed, -e                        string (variadic)  path to files to include in the tile /embed directory
+  --fetch                            bool               downloads the releases in Kilnfile.lock that are missing from the releases directory before baking (requires --kilnfile)
   --forms-directory, -f              string (variadic)  path to a directory containing forms
   --icon, -i                         string             path to icon file
   --instance-groups-directory, -ig   string (variadic)  path to a directory containing instance groups
   --jobs-directory, -j               string (variadic)  path to a directory containing jobs
   --kilnfile, -kf                    string             path to Kilnfile  (NOTE: mutually exclusive with --stemcell-directory)
-  --metadata, -m                     string (required)  path to the metadata file
+  --list-variables, -lv              bool               don't build a tile, print the variables declared in the variables schema
+  --metadata, -m                     string             path to the metadata file (required unless set in the Kilnfile bake section or base.yml is in the working directory)
   --metadata-only, -mo               bool               don't build a tile, output the metadata to stdout
   --migrations-directory, -md        string (variadic)  path to a directory containing migrations
-  --output-file, -o                  string             path to where the tile will be output
+  --ops-file, -of                    string (variadic)  path to a BOSH ops file to apply to the interpolated metadata
+  --output-file, -o                  string             path to where the tile will be output, or - to stream it to stdout
+  --profile, -p                      string             name of a profile under bake_profiles in the Kilnfile to apply to the bake section
   --properties-directory, -pd        string (variadic)  path to a directory containing property blueprints
   --releases-directory, -rd          string (variadic)  path to a directory containing release tarballs
   --runtime-configs-directory, -rcd  string (variadic)  path to a directory containing runtime configs
+  --secret-pattern, -spt             string (variadic)  regular expression for credentials that must not be baked into the tile, in addition to the default patterns
+  --secrets-directory, -sc           string             path to a directory with a file per secret for the secret helper
+  --secrets-helper, -sh              string             command of a credential helper for the secret helper
   --sha256                           bool               calculates a SHA256 checksum of the output file
+  --skip-default-secret-patterns     bool               don't scan the tile for common credentials such as AWS keys and private keys, only for sensitive values and --secret-pattern
+  --snippets-directory, -snd         string (variadic)  path to a directory containing snippets for the include helper
   --stemcell-tarball, -st            string             deprecated -- path to a stemcell tarball  (NOTE: mutually exclusive with --kilnfile)
   --stemcells-directory, -sd         string (variadic)  path to a directory containing stemcells  (NOTE: mutually exclusive with --kilnfile or --stemcell-tarball)
-  --stub-releases, -sr               bool               skips importing release tarballs into the tile
+  --stub-releases, -sr               bool               skips importing release tarballs into the tile, taking the releases from Kilnfile.lock when --kilnfile is given
   --variable, -vr                    string (variadic)  key value pairs of variables to interpolate
+  --variable-yaml, -vy               string (variadic)  key value pairs of variables to interpolate, with the value parsed as YAML
   --variables-file, -vf              string (variadic)  path to a file containing variables to interpolate
+  --variables-schema, -vs            string             path to a file declaring the variables (defaults to variables-schema.yml next to the metadata file)
   --version, -v                      string             version of the tile
 `
 
@@ -68,7 +82,10 @@ Command Arguments:
   --kilnfile, -kf            string             path to Kilnfile (default: Kilnfile)
   --no-confirm, -n           bool               non-interactive mode, will delete extra releases in releases dir without prompting
   --releases-directory, -rd  string             path to a directory to download releases into (default: releases)
+  --secrets-directory, -sc   string             path to a directory with a file per secret for the secret helper
+  --secrets-helper, -sh      string             command of a credential helper for the secret helper
   --variable, -vr            string (variadic)  variable in key=value format
+  --variable-yaml, -vy       string (variadic)  variable in key=value format, with the value parsed as YAML
   --variables-file, -vf      string (variadic)  path to variables file
 `
 
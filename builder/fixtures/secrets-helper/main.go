@@ -0,0 +1,53 @@
+// secrets-helper is a fake credential helper for the tests of HelperSecrets.
+// It appends the names it is asked for to the file in SECRETS_HELPER_LOG.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+var secrets = map[string]string{
+	"aws_key":     "some-aws-key",
+	"certificate": "-----BEGIN CERTIFICATE-----\nsome-certificate\n-----END CERTIFICATE-----\n",
+}
+
+func main() {
+	if len(os.Args) != 2 || os.Args[1] != "get" {
+		fmt.Fprintf(os.Stderr, "usage: %s get\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	err := json.NewDecoder(os.Stdin).Decode(&request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid request: %s\n", err)
+		os.Exit(1)
+	}
+
+	if log := os.Getenv("SECRETS_HELPER_LOG"); log != "" {
+		file, err := os.OpenFile(log, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintln(file, request.Name)
+			file.Close()
+		}
+	}
+
+	switch request.Name {
+	case "broken":
+		fmt.Fprintln(os.Stderr, "the vault is sealed")
+		os.Exit(1)
+	case "garbage":
+		fmt.Println("not json")
+		return
+	}
+
+	response := map[string]string{}
+	if value, ok := secrets[request.Name]; ok {
+		response["value"] = value
+	}
+	json.NewEncoder(os.Stdout).Encode(response)
+}
@@ -5,19 +5,26 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
 	"text/template"
 
-	yamlConverter "github.com/ghodss/yaml"
-	yaml "gopkg.in/yaml.v2"
+	"github.com/Masterminds/semver"
+	yaml "gopkg.in/yaml.v3"
 )
 
 type Interpolator struct{}
 
 type InterpolateInput struct {
+	TemplatePath       string
 	Version            string
 	BOSHVariables      map[string]interface{}
 	Variables          map[string]interface{}
+	VariableSources    map[string]string
 	ReleaseManifests   map[string]interface{}
 	StemcellManifests  map[string]interface{}
 	StemcellManifest   interface{}
@@ -27,6 +34,8 @@ type InterpolateInput struct {
 	Jobs               map[string]interface{}
 	PropertyBlueprints map[string]interface{}
 	RuntimeConfigs     map[string]interface{}
+	Snippets           map[string]interface{}
+	SecretProviders    []SecretProvider
 	StubReleases       bool
 }
 
@@ -34,22 +43,113 @@ func NewInterpolator() Interpolator {
 	return Interpolator{}
 }
 
+// Interpolate parses the template as YAML and runs the template helpers over
+// each string scalar that contains "$(", splicing the result back into the
+// document. Keys keep the order the author wrote them in and errors point at
+// the line and column of the scalar that failed.
 func (i Interpolator) Interpolate(input InterpolateInput, templateYAML []byte) ([]byte, error) {
-	interpolatedYAML, err := i.interpolate(input, templateYAML)
+	var document yaml.Node
+	err := yaml.Unmarshal(templateYAML, &document)
 	if err != nil {
+		if controlAction.Match(templateYAML) {
+			err = fmt.Errorf("%s (%s)", err, controlActionHint)
+		}
+		if input.TemplatePath != "" {
+			return nil, fmt.Errorf("%s: %s", input.TemplatePath, err)
+		}
 		return nil, err
 	}
 
-	prettyMetadata, err := i.prettyPrint(interpolatedYAML)
+	in := &interpolation{
+		interpolator: i,
+		input:        input,
+		parts:        map[string]string{},
+		inProgress:   map[string]bool{},
+	}
+	in.funcs = templateFunctions()
+	for name, helper := range in.helpers() {
+		in.funcs[name] = helper
+	}
+	err = in.interpolateNode(input.TemplatePath, input.Variables, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	if document.Kind == 0 {
+		return yaml.Marshal(nil)
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&document)
+	if err != nil {
+		return nil, err // un-tested
+	}
+	err = encoder.Close()
 	if err != nil {
 		return nil, err // un-tested
 	}
 
-	return prettyMetadata, nil
+	return buffer.Bytes(), nil
 }
 
-func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) ([]byte, error) {
-	templateHelpers := template.FuncMap{
+// interpolation holds the state of a single call to Interpolate. Parts are
+// interpolated once and their JSON is reused every time they are referenced.
+type interpolation struct {
+	interpolator Interpolator
+	input        InterpolateInput
+	funcs        template.FuncMap
+	parts        map[string]string
+	inProgress   map[string]bool
+}
+
+func (in *interpolation) helpers() template.FuncMap {
+	i, input := in.interpolator, in.input
+
+	return template.FuncMap{
+		"all_bosh_variables": func() (string, error) {
+			if input.BOSHVariables == nil {
+				return "", errors.New("--bosh-variables-directory must be specified")
+			}
+			return in.interpolateAll("bosh_variable", input.BOSHVariables)
+		},
+		"all_forms": func() (string, error) {
+			if input.FormTypes == nil {
+				return "", errors.New("--forms-directory must be specified")
+			}
+			return in.interpolateAll("form", input.FormTypes)
+		},
+		"all_instance_groups": func() (string, error) {
+			if input.InstanceGroups == nil {
+				return "", errors.New("--instance-groups-directory must be specified")
+			}
+			return in.interpolateAll("instance_group", input.InstanceGroups)
+		},
+		"all_jobs": func() (string, error) {
+			if input.Jobs == nil {
+				return "", errors.New("--jobs-directory must be specified")
+			}
+			return in.interpolateAll("job", input.Jobs)
+		},
+		"all_properties": func() (string, error) {
+			if input.PropertyBlueprints == nil {
+				return "", errors.New("--properties-directory must be specified")
+			}
+			return in.interpolateAll("property", input.PropertyBlueprints)
+		},
+		"all_releases": func() (string, error) {
+			if input.ReleaseManifests == nil {
+				return "", errors.New("missing ReleaseManifests")
+			}
+			return in.interpolateAll("release", input.ReleaseManifests)
+		},
+		"all_runtime_configs": func() (string, error) {
+			if input.RuntimeConfigs == nil {
+				return "", errors.New("--runtime-configs-directory must be specified")
+			}
+			return in.interpolateAll("runtime_config", input.RuntimeConfigs)
+		},
 		"bosh_variable": func(key string) (string, error) {
 			if input.BOSHVariables == nil {
 				return "", errors.New("--bosh-variables-directory must be specified")
@@ -58,7 +158,7 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 			if !ok {
 				return "", fmt.Errorf("could not find bosh variable with key '%s'", key)
 			}
-			return i.interpolateValueIntoYAML(input, val)
+			return in.interpolatePart("bosh_variable", key, val)
 		},
 		"form": func(key string) (string, error) {
 			if input.FormTypes == nil {
@@ -69,7 +169,7 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 				return "", fmt.Errorf("could not find form with key '%s'", key)
 			}
 
-			return i.interpolateValueIntoYAML(input, val)
+			return in.interpolatePart("form", key, val)
 		},
 		"property": func(name string) (string, error) {
 			if input.PropertyBlueprints == nil {
@@ -79,7 +179,23 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 			if !ok {
 				return "", fmt.Errorf("could not find property blueprint with name '%s'", name)
 			}
-			return i.interpolateValueIntoYAML(input, val)
+			return in.interpolatePart("property", name, val)
+		},
+		"include": func(name string, args ...interface{}) (string, error) {
+			if input.Snippets == nil {
+				return "", errors.New("--snippets-directory must be specified")
+			}
+			val, ok := input.Snippets[name]
+			if !ok {
+				return "", fmt.Errorf("could not find snippet with name '%s'", name)
+			}
+
+			data, err := snippetData(input.Variables, args)
+			if err != nil {
+				return "", err
+			}
+
+			return in.interpolateSnippet(name, val, data)
 		},
 		"regexReplaceAll": func(regex, inputString, replaceString string) (string, error) {
 			re, err := regexp.Compile(regex)
@@ -97,16 +213,12 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 
 			if !ok {
 				if input.StubReleases {
-					val = map[string]interface{}{
-						"name":    name,
-						"version": "UNKNOWN",
-					}
-				} else {
-					return "", fmt.Errorf("could not find release with name '%s'", name)
+					return "", fmt.Errorf("could not find release with name '%s' to stub, releases are stubbed from their tarballs or from Kilnfile.lock", name)
 				}
+				return "", fmt.Errorf("could not find release with name '%s'", name)
 			}
 
-			return i.interpolateValueIntoYAML(input, val)
+			return in.interpolatePart("release", name, val)
 		},
 		"stemcell": func(osname ...string) (string, error) {
 			if input.StemcellManifest == nil && len(input.StemcellManifests) == 0 {
@@ -121,33 +233,49 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 				return "", errors.New("stemcell template helper requires osname argument if multiple stemcells are specified")
 			}
 
+			if len(osname) > 2 {
+				return "", errors.New("stemcell template helper takes at most an osname and a version")
+			}
+
+			if len(osname) == 2 {
+				stemcell, err := i.findStemcell(input.StemcellManifests, osname[0], osname[1])
+				if err != nil {
+					return "", err
+				}
+				return in.interpolateValue(stemcell, input.Variables)
+			}
+
 			if len(osname) > 0 {
-				return i.interpolateValueIntoYAML(input, input.StemcellManifests[osname[0]])
+				stemcell, ok := input.StemcellManifests[osname[0]]
+				if !ok && i.hasStemcellVersions(input.StemcellManifests, osname[0]) {
+					return "", fmt.Errorf("more than one version of stemcell '%s' was found, pass a version to the stemcell template helper: $( stemcell \"%s\" \"<version>\" )", osname[0], osname[0])
+				}
+				return in.interpolateValue(stemcell, input.Variables)
 			}
 
 			if len(input.StemcellManifests) == 1 {
 				for _, stemcell := range input.StemcellManifests {
-					return i.interpolateValueIntoYAML(input, stemcell)
+					return in.interpolateValue(stemcell, input.Variables)
 				}
 			}
 
-			return i.interpolateValueIntoYAML(input, input.StemcellManifest)
+			return in.interpolateValue(input.StemcellManifest, input.Variables)
 		},
 		"version": func() (string, error) {
 			if input.Version == "" {
 				return "", errors.New("--version must be specified")
 			}
-			return i.interpolateValueIntoYAML(input, input.Version)
+			return in.interpolateValue(input.Version, input.Variables)
 		},
 		"variable": func(key string) (string, error) {
 			if input.Variables == nil {
 				return "", errors.New("--variable or --variables-file must be specified")
 			}
-			val, ok := input.Variables[key]
-			if !ok {
-				return "", fmt.Errorf("could not find variable with key '%s'", key)
+			val, err := lookupVariable(input.Variables, input.VariableSources, key)
+			if err != nil {
+				return "", err
 			}
-			return i.interpolateValueIntoYAML(input, val)
+			return in.interpolateValue(val, input.Variables)
 		},
 		"icon": func() (string, error) {
 			if input.IconImage == "" {
@@ -164,7 +292,7 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 				return "", fmt.Errorf("could not find instance_group with name '%s'", name)
 			}
 
-			return i.interpolateValueIntoYAML(input, val)
+			return in.interpolatePart("instance_group", name, val)
 		},
 		"job": func(name string) (string, error) {
 			if input.Jobs == nil {
@@ -175,7 +303,7 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 				return "", fmt.Errorf("could not find job with name '%s'", name)
 			}
 
-			return i.interpolateValueIntoYAML(input, val)
+			return in.interpolatePart("job", name, val)
 		},
 		"runtime_config": func(name string) (string, error) {
 			if input.RuntimeConfigs == nil {
@@ -186,7 +314,7 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 				return "", fmt.Errorf("could not find runtime_config with name '%s'", name)
 			}
 
-			return i.interpolateValueIntoYAML(input, val)
+			return in.interpolatePart("runtime_config", name, val)
 		},
 		"select": func(field, input string) (string, error) {
 			object := map[string]interface{}{}
@@ -196,12 +324,27 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 				return "", fmt.Errorf("could not JSON unmarshal %q: %s", input, err)
 			}
 
-			value, ok := object[field]
-			if !ok {
+			if _, ok := object[field]; !ok {
 				return "", fmt.Errorf("could not select %q, key does not exist", field)
 			}
 
-			output, err := json.Marshal(value)
+			// JSON is YAML, so the object is read again as a node to keep the
+			// key order of the selected value.
+			var document yaml.Node
+			err = yaml.Unmarshal([]byte(input), &document)
+			if err != nil {
+				return "", fmt.Errorf("could not JSON unmarshal %q: %s", input, err) // NOTE: this cannot happen because input was unmarshalled as JSON
+			}
+
+			var value *yaml.Node
+			mapping := document.Content[0]
+			for j := 0; j+1 < len(mapping.Content); j += 2 {
+				if mapping.Content[j].Value == field {
+					value = mapping.Content[j+1]
+				}
+			}
+
+			output, err := nodeToJSON(value)
 			if err != nil {
 				return "", fmt.Errorf("could not JSON marshal %q: %s", input, err) // NOTE: this cannot happen because value was unmarshalled from JSON
 			}
@@ -209,86 +352,584 @@ func (i Interpolator) interpolate(input InterpolateInput, templateYAML []byte) (
 			return string(output), nil
 		},
 	}
+}
 
-	t, err := template.New("metadata").
-		Delims("$(", ")").
-		Funcs(templateHelpers).
-		Parse(string(templateYAML))
+// interpolatePart interpolates a part the first time it is referenced and
+// returns the same JSON on every later reference.
+func (in *interpolation) interpolatePart(kind, name string, val interface{}) (string, error) {
+	key := kind + "/" + name
+	if output, ok := in.parts[key]; ok {
+		return output, nil
+	}
 
+	if in.inProgress[key] {
+		return "", fmt.Errorf("%s %q refers to itself", kind, name)
+	}
+
+	included, err := in.included(kind, name, val)
 	if err != nil {
-		return nil, fmt.Errorf("template parsing failed: %s", err)
+		return "", err
 	}
+	if !included {
+		return "", fmt.Errorf("%s %q is excluded by its when condition: %s", kind, name, val.(Source).When)
+	}
+	in.inProgress[key] = true
+	defer delete(in.inProgress, key)
 
-	var buffer bytes.Buffer
-	err = t.Execute(&buffer, input.Variables)
+	output, err := in.interpolateValue(val, in.input.Variables)
 	if err != nil {
-		return nil, fmt.Errorf("template execution failed: %s", err)
+		return "", fmt.Errorf("unable to interpolate value of %s %q: %s", kind, name, err)
 	}
 
-	return buffer.Bytes(), nil
+	in.parts[key] = output
+
+	return output, nil
 }
 
-func (i Interpolator) interpolateValueIntoYAML(input InterpolateInput, val interface{}) (string, error) {
-	initialYAML, err := yaml.Marshal(val)
+// interpolateAll interpolates every part of a kind and returns them as a JSON
+// list in the order they were read: the order of _order.yml when the
+// directory has one and alphabetical by name otherwise.
+func (in *interpolation) interpolateAll(kind string, parts map[string]interface{}) (string, error) {
+	var names []string
+	for name := range parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sort.SliceStable(names, func(i, j int) bool {
+		return partIndex(parts[names[i]]) < partIndex(parts[names[j]])
+	})
+
+	outputs := []string{}
+	for _, name := range names {
+		included, err := in.included(kind, name, parts[name])
+		if err != nil {
+			return "", err
+		}
+		if !included {
+			continue
+		}
+
+		output, err := in.interpolatePart(kind, name, parts[name])
+		if err != nil {
+			return "", err
+		}
+		outputs = append(outputs, output)
+	}
+
+	return "[" + strings.Join(outputs, ",") + "]", nil
+}
+
+// included reports whether the when condition of a part holds. Parts
+// without a condition are always included.
+func (in *interpolation) included(kind, name string, part interface{}) (bool, error) {
+	source, ok := part.(Source)
+	if !ok || source.When == "" {
+		return true, nil
+	}
+
+	evaluator := whenEvaluator{funcs: in.funcs, data: in.input.Variables}
+	included, err := evaluator.evaluate(source.When)
 	if err != nil {
-		return "", err // should never happen
+		return false, fmt.Errorf("%s: invalid when condition of %s %q: %s", source.Path, kind, name, err)
 	}
 
-	interpolatedYAML, err := i.interpolate(input, initialYAML)
+	return included, nil
+}
+
+func partIndex(part interface{}) int {
+	source, ok := part.(Source)
+	if !ok {
+		return 0
+	}
+	return source.Index
+}
+
+// interpolateValue interpolates a value with the given data and returns it
+// as JSON on a single line, so the template helpers can be piped into select
+// and the result can be spliced into the document wherever the helper was
+// called.
+func (in *interpolation) interpolateValue(val interface{}, data interface{}) (string, error) {
+	var (
+		path string
+		node *yaml.Node
+	)
+
+	switch v := val.(type) {
+	case Source:
+		path, node = v.Path, copyNode(v.Node)
+	default:
+		node = new(yaml.Node)
+		err := node.Encode(val)
+		if err != nil {
+			return "", err // should never happen
+		}
+	}
+
+	err := in.interpolateNode(path, data, node)
 	if err != nil {
-		return "", fmt.Errorf("unable to interpolate value: %s", err)
+		return "", err
 	}
 
-	inlinedYAML, err := i.yamlMarshalOneLine(interpolatedYAML)
+	output, err := nodeToJSON(node)
 	if err != nil {
 		return "", err // un-tested
 	}
 
-	return string(inlinedYAML), nil
+	return string(output), nil
 }
 
-// Workaround to avoid YAML indentation being incorrect when value is interpolated into the metadata
-func (i Interpolator) yamlMarshalOneLine(yamlContents []byte) ([]byte, error) {
-	return yamlConverter.YAMLToJSON(yamlContents)
+func (in *interpolation) interpolateNode(path string, data interface{}, node *yaml.Node) error {
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode, yaml.MappingNode:
+		for _, child := range node.Content {
+			err := in.interpolateNode(path, data, child)
+			if err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.ShortTag() == "!!str" && strings.Contains(node.Value, "$(") {
+			return in.interpolateScalar(path, data, node)
+		}
+	}
+
+	return nil
 }
 
-func (i Interpolator) prettifyRuntimeConfig(interpolatedYAML string) (string, error) {
-	var runtimeConfig map[string]interface{}
-	err := yaml.Unmarshal([]byte(interpolatedYAML), &runtimeConfig)
+var templateErrorPrefix = regexp.MustCompile(`^template: [a-z]+:[0-9]+(:[0-9]+)?: `)
+
+// controlAction matches the template actions that templates interpolated as
+// a whole document used to wrap keys and list items with. Each value is now
+// interpolated on its own, so the errors they cause get a hint.
+var controlAction = regexp.MustCompile(`\$\(-?\s*(if|range|with|else|end)\b`)
+
+const controlActionHint = "each YAML value is interpolated on its own, so $( if ), $( range ) and $( with ) must end in the value they start in and cannot wrap keys or list items"
+
+// stringStyles are the styles of scalars that were written as strings.
+const stringStyles = yaml.DoubleQuotedStyle | yaml.SingleQuotedStyle | yaml.LiteralStyle | yaml.FoldedStyle
+
+func (in *interpolation) interpolateScalar(path string, data interface{}, node *yaml.Node) error {
+	t, err := template.New("scalar").
+		Delims("$(", ")").
+		Funcs(in.funcs).
+		Funcs(in.scalarHelpers(path, node.Style&stringStyles != 0)).
+		Parse(node.Value)
 	if err != nil {
-		return "", err
+		message := templateErrorPrefix.ReplaceAllString(err.Error(), "")
+		if controlAction.MatchString(node.Value) {
+			message += " (" + controlActionHint + ")"
+		}
+		return positionError(path, node, "template parsing failed: %s", message)
 	}
 
-	if _, ok := runtimeConfig["runtime_config"]; !ok {
-		return interpolatedYAML, err
+	var buffer bytes.Buffer
+	err = t.Execute(&buffer, data)
+	if err != nil {
+		return positionError(path, node, "template execution failed: %s", templateErrorPrefix.ReplaceAllString(rootCause(err).Error(), ""))
 	}
 
-	prettyRuntimeConfig, err := i.prettyPrint([]byte(runtimeConfig["runtime_config"].(string)))
+	// Quoted and block scalars were written as strings, so whatever the
+	// helpers return stays a string.
+	if node.Style&stringStyles != 0 {
+		node.Value = buffer.String()
+		return nil
+	}
+
+	var document yaml.Node
+	err = yaml.Unmarshal(buffer.Bytes(), &document)
 	if err != nil {
-		return "", err
+		return positionError(path, node, "interpolated value %q is not valid YAML: %s", buffer.String(), err)
 	}
 
-	runtimeConfig["runtime_config"] = string(prettyRuntimeConfig)
+	replacement := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}
+	if len(document.Content) > 0 {
+		replacement = document.Content[0]
+	}
+	resetStyle(replacement)
+
+	replacement.Anchor = node.Anchor
+	replacement.Line, replacement.Column = node.Line, node.Column
+	*node = *replacement
+
+	return nil
+}
+
+// interpolateSnippet renders a snippet with the arguments of the include
+// helper as its data. Snippets take arguments, so unlike parts they are
+// interpolated every time they are included.
+func (in *interpolation) interpolateSnippet(name string, val interface{}, data interface{}) (string, error) {
+	key := "snippet/" + name
+	if in.inProgress[key] {
+		return "", fmt.Errorf("snippet %q includes itself", name)
+	}
+	in.inProgress[key] = true
+	defer delete(in.inProgress, key)
 
-	prettyInterpolatedYAML, err := yaml.Marshal(runtimeConfig)
+	output, err := in.interpolateValue(val, data)
 	if err != nil {
-		return "", err // should never happen
+		return "", fmt.Errorf("unable to interpolate snippet %q: %s", name, err)
 	}
 
-	inlinedYAML, err := i.yamlMarshalOneLine(prettyInterpolatedYAML)
+	return output, nil
+}
+
+// snippetData returns the data a snippet is rendered with: the variables
+// when include is given no arguments, a single dict argument as it is, or
+// the arguments as key value pairs.
+func snippetData(variables map[string]interface{}, args []interface{}) (interface{}, error) {
+	switch len(args) {
+	case 0:
+		return variables, nil
+	case 1:
+		if _, err := toMap(args[0]); err != nil {
+			return nil, errors.New("include takes a dict or key value pairs after the snippet name")
+		}
+		return args[0], nil
+	default:
+		data, err := dict(args...)
+		if err != nil {
+			return nil, errors.New("include takes a dict or key value pairs after the snippet name")
+		}
+		return data, nil
+	}
+}
+
+// scalarHelpers are the helpers that return strings read from outside the
+// metadata: files, environment variables and secrets. In plain scalars the
+// strings are quoted so that they stay strings once the result is read as
+// YAML, while quoted and block scalars take them as they are.
+func (in *interpolation) scalarHelpers(path string, raw bool) template.FuncMap {
+	return template.FuncMap{
+		"file": func(name string) (string, error) {
+			contents, err := readRelativeFile(path, name)
+			if err != nil {
+				return "", err
+			}
+			return stringResult(contents, raw), nil
+		},
+		"env": func(name string) (string, error) {
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %q is not set", name)
+			}
+			return stringResult(value, raw), nil
+		},
+		"secret": func(name string) (string, error) {
+			value, err := lookupSecret(in.input.SecretProviders, name)
+			if err != nil {
+				return "", err
+			}
+			return stringResult(value, raw), nil
+		},
+	}
+}
+
+// readRelativeFile reads a file relative to the file at path.
+func readRelativeFile(path, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("file %q must be a relative path", name)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(filepath.Dir(path), name))
 	if err != nil {
-		return "", err // un-tested
+		return "", fmt.Errorf("could not read file %q: %s", name, err)
+	}
+
+	return string(contents), nil
+}
+
+func stringResult(value string, raw bool) string {
+	if raw {
+		return value
+	}
+
+	quoted, err := json.Marshal(value)
+	if err != nil {
+		return value // should never happen
+	}
+
+	return string(quoted)
+}
+
+// positionError prefixes the message with the file, line and column of the
+// node. Nodes built from values rather than read from a file have no
+// position, so only the message is returned for them.
+func positionError(path string, node *yaml.Node, format string, v ...interface{}) error {
+	message := fmt.Sprintf(format, v...)
+	if node.Line == 0 {
+		return errors.New(message)
+	}
+
+	if path == "" {
+		return fmt.Errorf("%d:%d: %s", node.Line, node.Column, message)
+	}
+
+	return fmt.Errorf("%s:%d:%d: %s", path, node.Line, node.Column, message)
+}
+
+// rootCause returns the error a template helper returned rather than the
+// text/template error wrapping it.
+func rootCause(err error) error {
+	for {
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok || wrapper.Unwrap() == nil {
+			return err
+		}
+		err = wrapper.Unwrap()
+	}
+}
+
+// lookupVariable finds a variable by its key or, when there is no variable
+// with that exact key, by a dotted path such as iaas.aws.region into nested
+// maps. Errors name the file or flag that set the value the path stopped at.
+func lookupVariable(variables map[string]interface{}, sources map[string]string, key string) (interface{}, error) {
+	if val, ok := variables[key]; ok {
+		return val, nil
+	}
+
+	keys := strings.Split(key, ".")
+	val, ok := variables[keys[0]]
+	if !ok {
+		return nil, fmt.Errorf("could not find variable with key '%s'", key)
 	}
 
-	return string(inlinedYAML), nil
+	for i, childKey := range keys[1:] {
+		path := strings.Join(keys[:i+1], ".")
+		from := ""
+		if source, ok := sources[path]; ok {
+			from = " from " + source
+		}
+
+		m, err := toMap(val)
+		if err != nil {
+			return nil, fmt.Errorf("could not find variable with key '%s': '%s'%s is not a map", key, path, from)
+		}
+
+		val, ok = m[childKey]
+		if !ok {
+			return nil, fmt.Errorf("could not find variable with key '%s': '%s'%s has no key '%s'", key, path, from, childKey)
+		}
+	}
+
+	return val, nil
+}
+
+// copyNode returns a deep copy of the node, resolving aliases, so that a part
+// can be interpolated without changing the node it was read into.
+func copyNode(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		copied := copyNode(node.Alias)
+		copied.Anchor = ""
+		return copied
+	}
+
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = copyNode(child)
+	}
+
+	return &copied
+}
+
+// resetStyle drops the flow and quoting style of interpolated values, which
+// come back from the template helpers as JSON, so they are written out as
+// block YAML like the rest of the document.
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
 }
 
-func (i Interpolator) prettyPrint(inputYAML []byte) ([]byte, error) {
-	var data interface{}
-	err := yaml.Unmarshal(inputYAML, &data)
+// nodeToJSON writes the node as JSON on a single line, keeping the order of
+// the keys in mappings.
+func nodeToJSON(node *yaml.Node) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := writeNodeJSON(&buffer, node)
 	if err != nil {
-		return []byte{}, err // should never happen
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func writeNodeJSON(buffer *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			buffer.WriteString("null")
+			return nil
+		}
+		return writeNodeJSON(buffer, node.Content[0])
+	case yaml.AliasNode:
+		return writeNodeJSON(buffer, node.Alias)
+	case yaml.SequenceNode:
+		buffer.WriteString("[")
+		for i, item := range node.Content {
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			err := writeNodeJSON(buffer, item)
+			if err != nil {
+				return err
+			}
+		}
+		buffer.WriteString("]")
+	case yaml.MappingNode:
+		pairs, err := mappingPairs(node)
+		if err != nil {
+			return err
+		}
+
+		buffer.WriteString("{")
+		for i := 0; i < len(pairs); i += 2 {
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			key, _ := json.Marshal(pairs[i].Value)
+			buffer.Write(key)
+			buffer.WriteString(":")
+			err := writeNodeJSON(buffer, pairs[i+1])
+			if err != nil {
+				return err
+			}
+		}
+		buffer.WriteString("}")
+	case yaml.ScalarNode:
+		output, err := scalarToJSON(node)
+		if err != nil {
+			return err
+		}
+		buffer.Write(output)
+	}
+
+	return nil
+}
+
+// mappingPairs returns the keys and values of a mapping with any merge keys
+// ("<<") replaced by the pairs they merge in, unless the mapping sets the same
+// key itself.
+func mappingPairs(node *yaml.Node) ([]*yaml.Node, error) {
+	explicit := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		explicit[resolveAlias(node.Content[i]).Value] = true
 	}
 
-	return yaml.Marshal(data)
+	var pairs []*yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := resolveAlias(node.Content[i]), resolveAlias(node.Content[i+1])
+		if key.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("cannot convert non-scalar key on line %d to JSON", key.Line)
+		}
+
+		if key.ShortTag() != "!!merge" {
+			pairs = append(pairs, key, value)
+			continue
+		}
+
+		merged := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			merged = value.Content
+		}
+		for _, m := range merged {
+			mergedPairs, err := mappingPairs(resolveAlias(m))
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < len(mergedPairs); j += 2 {
+				if explicit[mergedPairs[j].Value] {
+					continue
+				}
+				explicit[mergedPairs[j].Value] = true
+				pairs = append(pairs, mergedPairs[j], mergedPairs[j+1])
+			}
+		}
+	}
+
+	return pairs, nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+func scalarToJSON(node *yaml.Node) ([]byte, error) {
+	switch node.ShortTag() {
+	case "!!null":
+		return []byte("null"), nil
+	case "!!bool", "!!int", "!!float":
+		var value interface{}
+		err := node.Decode(&value)
+		if err != nil {
+			return nil, err
+		}
+
+		output, err := json.Marshal(value)
+		if err != nil {
+			// .inf and .nan have no JSON representation
+			return json.Marshal(node.Value)
+		}
+		return output, nil
+	default:
+		return json.Marshal(node.Value)
+	}
+}
+
+// findStemcell returns the stemcell of the OS with the given version or, when
+// no stemcell has exactly that version, the highest version that satisfies it
+// as a constraint, for example "~621" or "621.*".
+func (i Interpolator) findStemcell(stemcells map[string]interface{}, os, version string) (interface{}, error) {
+	var candidates []StemcellManifest
+	for _, stemcell := range stemcells {
+		manifest, ok := stemcell.(StemcellManifest)
+		if !ok || manifest.OperatingSystem != os {
+			continue
+		}
+
+		if manifest.Version == version {
+			return manifest, nil
+		}
+		candidates = append(candidates, manifest)
+	}
+
+	constraint, err := semver.NewConstraint(version)
+	if err != nil {
+		return nil, fmt.Errorf("could not find stemcell '%s' with version '%s'", os, version)
+	}
+
+	var (
+		found        interface{}
+		foundVersion *semver.Version
+	)
+	for _, manifest := range candidates {
+		v, err := semver.NewVersion(manifest.Version)
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+
+		if foundVersion == nil || v.GreaterThan(foundVersion) {
+			found, foundVersion = manifest, v
+		}
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("could not find stemcell '%s' matching version '%s'", os, version)
+	}
+
+	return found, nil
+}
+
+func (i Interpolator) hasStemcellVersions(stemcells map[string]interface{}, os string) bool {
+	for key := range stemcells {
+		if strings.HasPrefix(key, os+"/") {
+			return true
+		}
+	}
+	return false
 }
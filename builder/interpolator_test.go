@@ -1,8 +1,13 @@
 package builder_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/pivotal-cf/kiln/builder"
 	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -204,6 +209,413 @@ some_form_types:
 `))
 	})
 
+	It("keeps the order of the keys in the template and in parts read from files", func() {
+		input = builder.InterpolateInput{
+			FormTypes: map[string]interface{}{
+				"some-form": source("forms/some-form.yml", `
+name: some-form
+properties:
+- type: string
+  name: some-property
+label: some-form-label
+`),
+			},
+		}
+
+		interpolatedYAML, err := interpolator.Interpolate(input, []byte(`---
+zebra: 1
+form_types:
+- $( form "some-form" )
+apple: 2
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(interpolatedYAML)).To(Equal(`zebra: 1
+form_types:
+  - name: some-form
+    properties:
+      - type: string
+        name: some-property
+    label: some-form-label
+apple: 2
+`))
+	})
+
+	It("interpolates a part once however many times it is referenced", func() {
+		input = builder.InterpolateInput{
+			Jobs: map[string]interface{}{
+				"some-job": source("jobs/some-job.yml", `name: some-job`),
+			},
+			InstanceGroups: map[string]interface{}{
+				"some-instance-group": source("instance-groups/some-instance-group.yml", `
+name: some-instance-group
+templates:
+- $( job "some-job" )
+- $( job "some-job" )
+`),
+			},
+		}
+
+		interpolatedYAML, err := interpolator.Interpolate(input, []byte(`
+job_types:
+- $( instance_group "some-instance-group" )
+- $( instance_group "some-instance-group" | select "templates" )
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+job_types:
+- name: some-instance-group
+  templates:
+  - name: some-job
+  - name: some-job
+- - name: some-job
+  - name: some-job
+`))
+	})
+
+	Context("when all parts of a kind are expanded", func() {
+		BeforeEach(func() {
+			input = builder.InterpolateInput{
+				ReleaseManifests: map[string]interface{}{
+					"some-release":  builder.ReleaseManifest{Name: "some-release", Version: "1.2.3"},
+					"other-release": builder.ReleaseManifest{Name: "other-release", Version: "4.5.6"},
+				},
+				FormTypes: map[string]interface{}{
+					"some-form":  source("forms/some-form.yml", `name: some-form`),
+					"other-form": source("forms/other-form.yml", `name: other-form`),
+				},
+				PropertyBlueprints: map[string]interface{}{
+					"some-property": builder.Metadata{"name": "some-property"},
+				},
+				InstanceGroups: map[string]interface{}{},
+			}
+		})
+
+		It("expands the parts in the order they were read, otherwise by name", func() {
+			someForm := input.FormTypes["some-form"].(builder.Source)
+			someForm.Index = 0
+			otherForm := input.FormTypes["other-form"].(builder.Source)
+			otherForm.Index = 1
+			input.FormTypes = map[string]interface{}{"some-form": someForm, "other-form": otherForm}
+
+			interpolatedYAML, err := interpolator.Interpolate(input, []byte(`
+releases: $( all_releases )
+form_types: $( all_forms )
+property_blueprints: $( all_properties )
+job_types: $( all_instance_groups )
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+releases:
+- name: other-release
+  version: 4.5.6
+  file: ""
+  sha1: ""
+- name: some-release
+  version: 1.2.3
+  file: ""
+  sha1: ""
+form_types:
+- name: some-form
+- name: other-form
+property_blueprints:
+- name: some-property
+job_types: []
+`))
+		})
+
+		It("returns an error when the directory was not given", func() {
+			_, err := interpolator.Interpolate(input, []byte(`runtime_configs: $( all_runtime_configs )`))
+			Expect(err).To(MatchError(ContainSubstring("--runtime-configs-directory must be specified")))
+		})
+	})
+
+	Context("when variables are nested", func() {
+		BeforeEach(func() {
+			input = builder.InterpolateInput{
+				Variables: map[string]interface{}{
+					"iaas": map[interface{}]interface{}{
+						"name": "aws",
+						"aws": map[interface{}]interface{}{
+							"region": "us-east-1",
+						},
+					},
+					"some.dotted.key": "some-value",
+				},
+				VariableSources: map[string]string{
+					"iaas":            "base.yml",
+					"iaas.name":       "--variable iaas.name",
+					"iaas.aws":        "base.yml",
+					"iaas.aws.region": "aws.yml",
+				},
+			}
+		})
+
+		It("looks them up by dotted key", func() {
+			interpolatedYAML, err := interpolator.Interpolate(input, []byte(`
+region: $( variable "iaas.aws.region" )
+aws: $( variable "iaas.aws" )
+dotted: $( variable "some.dotted.key" )
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+region: us-east-1
+aws:
+  region: us-east-1
+dotted: some-value
+`))
+		})
+
+		It("names where the value the key stopped at came from", func() {
+			_, err := interpolator.Interpolate(input, []byte(`zone: $( variable "iaas.aws.zone" )`))
+			Expect(err).To(MatchError(ContainSubstring(`could not find variable with key 'iaas.aws.zone': 'iaas.aws' from base.yml has no key 'zone'`)))
+
+			_, err = interpolator.Interpolate(input, []byte(`zone: $( variable "iaas.name.zone" )`))
+			Expect(err).To(MatchError(ContainSubstring(`could not find variable with key 'iaas.name.zone': 'iaas.name' from --variable iaas.name is not a map`)))
+		})
+	})
+
+	Context("when parts have when conditions", func() {
+		BeforeEach(func() {
+			input = builder.InterpolateInput{
+				Variables: map[string]interface{}{
+					"edition":   "enterprise",
+					"instances": 3,
+					"metrics":   false,
+					"zones":     []interface{}{"z1", "z2"},
+				},
+				FormTypes: map[string]interface{}{
+					"some-form":       whenSource("forms/some-form.yml", `name: some-form`, ""),
+					"enterprise-form": whenSource("forms/enterprise-form.yml", `name: enterprise-form`, `(variable "edition") == "enterprise"`),
+					"community-form":  whenSource("forms/community-form.yml", `name: community-form`, `(variable "edition") == "community"`),
+					"metrics-form":    whenSource("forms/metrics-form.yml", `name: metrics-form`, `.metrics || .instances != 3`),
+					"scaled-form":     whenSource("forms/scaled-form.yml", `name: scaled-form`, `!.metrics && (.instances == 3 || .edition == "community")`),
+					"zones-form":      whenSource("forms/zones-form.yml", `name: zones-form`, `len (index . "zones") == 2 && (index (index . "zones") 0) == "z1"`),
+					"one-zone-form":   whenSource("forms/one-zone-form.yml", `name: one-zone-form`, `(len (index . "zones")) == 1`),
+				},
+			}
+		})
+
+		It("leaves out the parts whose condition does not hold", func() {
+			interpolatedYAML, err := interpolator.Interpolate(input, []byte(`
+form_types: $( all_forms )
+enterprise_form: $( form "enterprise-form" )
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+form_types:
+- name: enterprise-form
+- name: scaled-form
+- name: some-form
+- name: zones-form
+enterprise_form:
+  name: enterprise-form
+`))
+		})
+
+		It("returns an error when an excluded part is referenced", func() {
+			_, err := interpolator.Interpolate(input, []byte(`form: $( form "community-form" )`))
+			Expect(err).To(MatchError(ContainSubstring(`form "community-form" is excluded by its when condition: (variable "edition") == "community"`)))
+		})
+
+		It("returns an error when a condition is not valid", func() {
+			input.FormTypes["some-form"] = whenSource("forms/some-form.yml", `name: some-form`, `(variable "edition" == "enterprise"`)
+			_, err := interpolator.Interpolate(input, []byte(`form_types: $( all_forms )`))
+			Expect(err).To(MatchError(ContainSubstring(`forms/some-form.yml: invalid when condition of form "some-form": missing )`)))
+
+			input.FormTypes["some-form"] = whenSource("forms/some-form.yml", `name: some-form`, `(variable "missing") == "enterprise"`)
+			_, err = interpolator.Interpolate(input, []byte(`form_types: $( all_forms )`))
+			Expect(err).To(MatchError(ContainSubstring(`could not evaluate "variable \"missing\"": could not find variable with key 'missing'`)))
+		})
+	})
+
+	Context("when snippets are included", func() {
+		BeforeEach(func() {
+			input = builder.InterpolateInput{
+				Variables: map[string]interface{}{
+					"syslog-address": "syslog.example.com",
+				},
+				Snippets: map[string]interface{}{
+					"tls": source("snippets/tls.yml", `
+enabled: true
+ca: $( .ca )
+port: $( .port | default 443 )
+`),
+					"syslog": source("snippets/syslog.yml", `
+address: $( variable "syslog-address" )
+tls: $( include "tls" "ca" "syslog-ca" )
+`),
+				},
+				Jobs: map[string]interface{}{
+					"some-job": source("jobs/some-job.yml", `
+name: some-job
+properties:
+  tls: $( include "tls" "ca" "job-ca" "port" 8443 )
+  syslog: $( include "syslog" )
+`),
+				},
+			}
+		})
+
+		It("renders the snippet with the arguments", func() {
+			interpolatedYAML, err := interpolator.Interpolate(input, []byte(`
+jobs:
+- $( job "some-job" )
+tls: $( dict "ca" "metadata-ca" | include "tls" )
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+jobs:
+- name: some-job
+  properties:
+    tls:
+      enabled: true
+      ca: job-ca
+      port: 8443
+    syslog:
+      address: syslog.example.com
+      tls:
+        enabled: true
+        ca: syslog-ca
+        port: 443
+tls:
+  enabled: true
+  ca: metadata-ca
+  port: 443
+`))
+		})
+
+		It("returns an error when the snippet does not exist", func() {
+			_, err := interpolator.Interpolate(input, []byte(`tls: $( include "missing" )`))
+			Expect(err).To(MatchError(ContainSubstring("could not find snippet with name 'missing'")))
+		})
+
+		It("returns an error when the arguments are not key value pairs", func() {
+			_, err := interpolator.Interpolate(input, []byte(`tls: $( include "tls" "ca" )`))
+			Expect(err).To(MatchError(ContainSubstring("include takes a dict or key value pairs after the snippet name")))
+		})
+
+		It("returns an error when a snippet includes itself", func() {
+			input.Snippets["tls"] = source("snippets/tls.yml", `tls: $( include "tls" )`)
+			_, err := interpolator.Interpolate(input, []byte(`tls: $( include "tls" )`))
+			Expect(err).To(MatchError(ContainSubstring(`snippet "tls" includes itself`)))
+		})
+
+		It("returns an error when no snippets were given", func() {
+			input.Snippets = nil
+			_, err := interpolator.Interpolate(input, []byte(`tls: $( include "tls" )`))
+			Expect(err).To(MatchError(ContainSubstring("--snippets-directory must be specified")))
+		})
+	})
+
+	Context("when files are inlined", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.MkdirAll(filepath.Join(tempDir, "jobs", "scripts"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "jobs", "scripts", "pre-start.sh"), []byte("#!/bin/bash\necho \"key: value\"\n"), 0644)).To(Succeed())
+
+			input = builder.InterpolateInput{
+				Jobs: map[string]interface{}{
+					"some-job": source(filepath.Join(tempDir, "jobs", "some-job.yml"), `
+name: some-job
+pre_start: $( file "scripts/pre-start.sh" )
+script: |
+  $( file "scripts/pre-start.sh" )
+`),
+				},
+			}
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("inlines the file relative to the part as a string", func() {
+			interpolatedYAML, err := interpolator.Interpolate(input, []byte(`job: $( job "some-job" )`))
+			Expect(err).NotTo(HaveOccurred())
+
+			var output struct {
+				Job struct {
+					PreStart string `yaml:"pre_start"`
+					Script   string `yaml:"script"`
+				} `yaml:"job"`
+			}
+			Expect(yaml.Unmarshal(interpolatedYAML, &output)).To(Succeed())
+			Expect(output.Job.PreStart).To(Equal("#!/bin/bash\necho \"key: value\"\n"))
+			Expect(output.Job.Script).To(Equal("#!/bin/bash\necho \"key: value\"\n\n"))
+		})
+
+		It("returns an error when the file does not exist", func() {
+			input.Jobs["some-job"] = source(filepath.Join(tempDir, "jobs", "some-job.yml"), `script: $( file "missing.sh" )`)
+			_, err := interpolator.Interpolate(input, []byte(`job: $( job "some-job" )`))
+			Expect(err).To(MatchError(ContainSubstring(`could not read file "missing.sh"`)))
+		})
+
+		It("returns an error when the path is absolute", func() {
+			_, err := interpolator.Interpolate(input, []byte(`script: $( file "/etc/passwd" )`))
+			Expect(err).To(MatchError(ContainSubstring(`file "/etc/passwd" must be a relative path`)))
+		})
+	})
+
+	Context("when environment variables and secrets are used", func() {
+		var tempDir string
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "password"), []byte("1234: not a map\n"), 0600)).To(Succeed())
+			os.Setenv("KILN_TEST_AWS_KEY", "true")
+
+			input = builder.InterpolateInput{
+				SecretProviders: builder.NewSecretProviders(tempDir, ""),
+			}
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("KILN_TEST_AWS_KEY")
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("interpolates them as strings", func() {
+			interpolatedYAML, err := interpolator.Interpolate(input, []byte(`
+aws_key: $( env "KILN_TEST_AWS_KEY" )
+password: $( secret "password" )
+quoted: 'prefix-$( secret "password" )'
+`))
+			Expect(err).NotTo(HaveOccurred())
+
+			var output map[string]interface{}
+			Expect(yaml.Unmarshal(interpolatedYAML, &output)).To(Succeed())
+			Expect(output).To(Equal(map[string]interface{}{
+				"aws_key":  "true",
+				"password": "1234: not a map",
+				"quoted":   "prefix-1234: not a map",
+			}))
+		})
+
+		It("returns an error when the environment variable is not set", func() {
+			_, err := interpolator.Interpolate(input, []byte(`aws_key: $( env "KILN_TEST_MISSING" )`))
+			Expect(err).To(MatchError(ContainSubstring(`environment variable "KILN_TEST_MISSING" is not set`)))
+		})
+
+		It("returns an error when no provider has the secret", func() {
+			_, err := interpolator.Interpolate(input, []byte(`password: $( secret "missing" )`))
+			Expect(err).To(MatchError(ContainSubstring(`secret "missing" was not found in secrets directory ` + tempDir)))
+		})
+
+		It("returns an error when no secret providers were given", func() {
+			input.SecretProviders = nil
+			_, err := interpolator.Interpolate(input, []byte(`password: $( secret "password" )`))
+			Expect(err).To(MatchError(ContainSubstring(`secret "password" requires --secrets-directory or --secrets-helper`)))
+		})
+	})
+
 	Context("when multiple stemcells are specified", func() {
 		var templateYAML string
 
@@ -265,6 +677,66 @@ additional_stemcells_criteria:
 		})
 	})
 
+	Context("when several versions of a stemcell are specified", func() {
+		BeforeEach(func() {
+			input = builder.InterpolateInput{
+				StemcellManifests: map[string]interface{}{
+					"ubuntu-xenial/621.0": builder.StemcellManifest{
+						OperatingSystem: "ubuntu-xenial",
+						Version:         "621.0",
+					},
+					"ubuntu-xenial/621.12": builder.StemcellManifest{
+						OperatingSystem: "ubuntu-xenial",
+						Version:         "621.12",
+					},
+					"ubuntu-xenial/456.30": builder.StemcellManifest{
+						OperatingSystem: "ubuntu-xenial",
+						Version:         "456.30",
+					},
+					"windows": builder.StemcellManifest{
+						OperatingSystem: "windows",
+						Version:         "2019.4",
+					},
+				},
+			}
+		})
+
+		It("interpolates the stemcell with the given version or the highest version matching a constraint", func() {
+			interpolatedYAML, err := interpolator.Interpolate(input, []byte(`
+---
+stemcell_criteria: $( stemcell "ubuntu-xenial" "~621" )
+additional_stemcells_criteria:
+- $( stemcell "ubuntu-xenial" "456.30" )
+- $( stemcell "windows" )
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+---
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.12"
+additional_stemcells_criteria:
+- os: ubuntu-xenial
+  version: "456.30"
+- os: windows
+  version: "2019.4"
+`))
+		})
+
+		It("returns an error when no version is given", func() {
+			_, err := interpolator.Interpolate(input, []byte(`stemcell_criteria: $( stemcell "ubuntu-xenial" )`))
+			Expect(err).To(MatchError(ContainSubstring(`more than one version of stemcell 'ubuntu-xenial' was found, pass a version to the stemcell template helper: $( stemcell "ubuntu-xenial" "<version>" )`)))
+		})
+
+		It("returns an error when no stemcell matches the version", func() {
+			_, err := interpolator.Interpolate(input, []byte(`stemcell_criteria: $( stemcell "ubuntu-xenial" "~700" )`))
+			Expect(err).To(MatchError(ContainSubstring("could not find stemcell 'ubuntu-xenial' matching version '~700'")))
+
+			_, err = interpolator.Interpolate(input, []byte(`stemcell_criteria: $( stemcell "ubuntu-xenial" "not a version" )`))
+			Expect(err).To(MatchError(ContainSubstring("could not find stemcell 'ubuntu-xenial' with version 'not a version'")))
+		})
+	})
+
 	Context("when only one stemcell is specified", func() {
 		var templateYAML string
 
@@ -376,16 +848,13 @@ some_runtime_configs:
 	})
 
 	Context("when release tgz file does not exist and stub releases is true", func() {
-		It("sets version to unknown", func() {
+		It("returns an error", func() {
 
 			interpolator := builder.NewInterpolator()
 			input.StubReleases = true
-			interpolatedYAML, err := interpolator.Interpolate(input, []byte(`releases: [$(release "stub-release")]`))
+			_, err := interpolator.Interpolate(input, []byte(`releases: [$(release "stub-release")]`))
 
-			Expect(err).NotTo(HaveOccurred())
-			Expect(interpolatedYAML).To(HelpfullyMatchYAML(`releases:
-- name: stub-release
-  version: UNKNOWN`))
+			Expect(err).To(MatchError(ContainSubstring("could not find release with name 'stub-release' to stub, releases are stubbed from their tarballs or from Kilnfile.lock")))
 		})
 	})
 
@@ -438,6 +907,37 @@ some_runtime_configs:
 			})
 		})
 
+		Context("when a part read from a file fails to interpolate", func() {
+			It("returns an error with the file, line and column", func() {
+				input.FormTypes = map[string]interface{}{
+					"some-form": source("forms/some-form.yml", `
+name: some-form
+label: $( variable "some-missing-variable" )
+`),
+				}
+				input.TemplatePath = "metadata.yml"
+				_, err := interpolator.Interpolate(input, []byte(`---
+some_form_types:
+- $( form "some-form" )
+`))
+				Expect(err).To(MatchError(`metadata.yml:3:3: template execution failed: unable to interpolate value of form "some-form": forms/some-form.yml:3:8: template execution failed: could not find variable with key 'some-missing-variable'`))
+			})
+		})
+
+		Context("when a part refers to itself", func() {
+			It("returns an error", func() {
+				input.Jobs = map[string]interface{}{
+					"some-job": source("jobs/some-job.yml", `
+name: some-job
+templates:
+- $( job "some-job" )
+`),
+				}
+				_, err := interpolator.Interpolate(input, []byte(`job: $( job "some-job" )`))
+				Expect(err).To(MatchError(ContainSubstring(`job "some-job" refers to itself`)))
+			})
+		})
+
 		Context("when template parsing fails", func() {
 			It("returns an error", func() {
 
@@ -448,6 +948,32 @@ some_runtime_configs:
 			})
 		})
 
+		Context("when an if wraps keys", func() {
+			It("explains that each value is interpolated on its own", func() {
+				input.TemplatePath = "metadata.yml"
+				_, err := interpolator.Interpolate(input, []byte(`---
+name: some-product
+$( if .enterprise )
+sso: true
+$( end )
+`))
+				Expect(err).To(MatchError(`metadata.yml: yaml: line 3: could not find expected ':' (each YAML value is interpolated on its own, so $( if ), $( range ) and $( with ) must end in the value they start in and cannot wrap keys or list items)`))
+			})
+		})
+
+		Context("when a range wraps list items", func() {
+			It("explains that each value is interpolated on its own", func() {
+				input.TemplatePath = "metadata.yml"
+				_, err := interpolator.Interpolate(input, []byte(`---
+azs:
+- $( range .azs )
+- $( . )
+- $( end )
+`))
+				Expect(err).To(MatchError(`metadata.yml:3:3: template parsing failed: unexpected EOF (each YAML value is interpolated on its own, so $( if ), $( range ) and $( with ) must end in the value they start in and cannot wrap keys or list items)`))
+			})
+		})
+
 		Context("when template execution fails", func() {
 			It("returns an error", func() {
 
@@ -658,3 +1184,15 @@ stemcell_criteria: $( stemcell "windows" )
 		})
 	})
 })
+
+func source(path, contents string) builder.Source {
+	var document yamlv3.Node
+	Expect(yamlv3.Unmarshal([]byte(contents), &document)).To(Succeed())
+	return builder.Source{Path: path, Node: document.Content[0]}
+}
+
+func whenSource(path, contents, when string) builder.Source {
+	s := source(path, contents)
+	s.When = when
+	return s
+}
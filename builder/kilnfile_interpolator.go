@@ -0,0 +1,22 @@
+package builder
+
+// KilnfileInterpolator interpolates Kilnfiles and Kilnfile.lock files, which
+// can use variables and read credentials with the secret and env helpers.
+type KilnfileInterpolator struct {
+	interpolator    Interpolator
+	secretProviders []SecretProvider
+}
+
+func NewKilnfileInterpolator(secretProviders []SecretProvider) KilnfileInterpolator {
+	return KilnfileInterpolator{
+		interpolator:    NewInterpolator(),
+		secretProviders: secretProviders,
+	}
+}
+
+func (k KilnfileInterpolator) InterpolateKilnfile(variables map[string]interface{}, kilnfileYAML []byte) ([]byte, error) {
+	return k.interpolator.Interpolate(InterpolateInput{
+		Variables:       variables,
+		SecretProviders: k.secretProviders,
+	}, kilnfileYAML)
+}
@@ -0,0 +1,46 @@
+package builder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/kiln/builder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KilnfileInterpolator", func() {
+	var tempDir string
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "kilnfile-interpolator")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(ioutil.WriteFile(filepath.Join(tempDir, "aws_secret"), []byte("some-aws-secret\n"), 0600)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	It("interpolates the variables and secrets in a Kilnfile", func() {
+		interpolator := builder.NewKilnfileInterpolator(builder.NewSecretProviders(tempDir, ""))
+
+		kilnfileYAML, err := interpolator.InterpolateKilnfile(map[string]interface{}{"bucket": "some-bucket"}, []byte(`---
+release_sources:
+- type: s3
+  bucket: $( variable "bucket" )
+  secret_access_key: $( secret "aws_secret" )
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kilnfileYAML).To(MatchYAML(`
+release_sources:
+- type: s3
+  bucket: some-bucket
+  secret_access_key: some-aws-secret
+`))
+	})
+})
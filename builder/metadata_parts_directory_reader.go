@@ -10,17 +10,42 @@ import (
 	pathpkg "path"
 
 	yaml "gopkg.in/yaml.v2"
+	yamlv3 "gopkg.in/yaml.v3"
 )
 
 type MetadataPartsDirectoryReader struct {
-	topLevelKey string
-	orderKey    string
+	topLevelKey   string
+	orderKey      string
+	optionalOrder bool
 }
 
 type Part struct {
 	File     string
 	Name     string
 	Metadata interface{}
+	Source   *Source
+}
+
+// Source is the YAML a part was read from and the path of its file. The
+// interpolator uses it to keep the key order of the part and to report
+// errors with the line and column they occurred on. Index is the position of
+// the part among the parts read from the same directories, which the all_*
+// template helpers expand in. When is the condition of the part's when key,
+// which leaves the part out of the metadata unless it holds.
+type Source struct {
+	Path  string
+	Node  *yamlv3.Node
+	Index int
+	When  string
+}
+
+// Value returns the Source of the part when it was read from a file and its
+// Metadata otherwise.
+func (p Part) Value() interface{} {
+	if p.Source != nil {
+		return *p.Source
+	}
+	return p.Metadata
 }
 
 func NewMetadataPartsDirectoryReader() MetadataPartsDirectoryReader {
@@ -35,12 +60,24 @@ func NewMetadataPartsDirectoryReaderWithOrder(topLevelKey, orderKey string) Meta
 	return MetadataPartsDirectoryReader{topLevelKey: topLevelKey, orderKey: orderKey}
 }
 
+// NewMetadataPartsDirectoryReaderWithOptionalOrder returns a reader that
+// orders parts by the orderKey of _order.yml when the directory has one.
+// Parts it does not list follow in alphabetical order, as do all parts of a
+// directory without _order.yml.
+func NewMetadataPartsDirectoryReaderWithOptionalOrder(topLevelKey, orderKey string) MetadataPartsDirectoryReader {
+	return MetadataPartsDirectoryReader{topLevelKey: topLevelKey, orderKey: orderKey, optionalOrder: true}
+}
+
 func (r MetadataPartsDirectoryReader) Read(path string) ([]Part, error) {
 	parts, err := r.readMetadataRecursivelyFromDir(path)
 	if err != nil {
 		return []Part{}, err
 	}
 
+	if r.optionalOrder {
+		return r.orderWithOptionalOrderFromFile(path, parts)
+	}
+
 	if r.orderKey != "" {
 		return r.orderWithOrderFromFile(path, parts)
 	}
@@ -65,6 +102,17 @@ func (r MetadataPartsDirectoryReader) readMetadataRecursivelyFromDir(path string
 			return err
 		}
 
+		var document yamlv3.Node
+		err = yamlv3.Unmarshal(data, &document)
+		if err != nil {
+			return fmt.Errorf("cannot unmarshal '%s': %s", filePath, err)
+		}
+
+		var node *yamlv3.Node
+		if len(document.Content) > 0 {
+			node = document.Content[0]
+		}
+
 		var vars interface{}
 		if r.topLevelKey != "" {
 			var fileVars map[string]interface{}
@@ -78,6 +126,7 @@ func (r MetadataPartsDirectoryReader) readMetadataRecursivelyFromDir(path string
 			if !ok {
 				return fmt.Errorf("not a %s file: %q", r.topLevelKey, filePath)
 			}
+			node = mappingValue(node, r.topLevelKey)
 		} else {
 			err = yaml.Unmarshal([]byte(data), &vars)
 			if err != nil {
@@ -85,7 +134,7 @@ func (r MetadataPartsDirectoryReader) readMetadataRecursivelyFromDir(path string
 			}
 		}
 
-		parts, err = r.readMetadataIntoParts(pathpkg.Base(filePath), vars, parts)
+		parts, err = r.readMetadataIntoParts(filePath, vars, node, parts)
 		if err != nil {
 			return fmt.Errorf("file '%s' with top-level key '%s' has an invalid format: %s", filePath, r.topLevelKey, err)
 		}
@@ -96,16 +145,16 @@ func (r MetadataPartsDirectoryReader) readMetadataRecursivelyFromDir(path string
 	return parts, err
 }
 
-func (r MetadataPartsDirectoryReader) readMetadataIntoParts(fileName string, vars interface{}, parts []Part) ([]Part, error) {
+func (r MetadataPartsDirectoryReader) readMetadataIntoParts(filePath string, vars interface{}, node *yamlv3.Node, parts []Part) ([]Part, error) {
 	switch v := vars.(type) {
 	case []interface{}:
-		for _, item := range v {
+		for index, item := range v {
 			i, ok := item.(map[interface{}]interface{})
 			if !ok {
 				return []Part{}, fmt.Errorf("metadata item '%v' must be a map", item)
 			}
 
-			part, err := r.buildPartFromMetadata(i, fileName)
+			part, err := r.buildPartFromMetadata(i, filePath, sequenceItem(node, index))
 			if err != nil {
 				return []Part{}, err
 			}
@@ -113,7 +162,7 @@ func (r MetadataPartsDirectoryReader) readMetadataIntoParts(fileName string, var
 			parts = append(parts, part)
 		}
 	case map[interface{}]interface{}:
-		part, err := r.buildPartFromMetadata(v, fileName)
+		part, err := r.buildPartFromMetadata(v, filePath, node)
 		if err != nil {
 			return []Part{}, err
 		}
@@ -125,7 +174,7 @@ func (r MetadataPartsDirectoryReader) readMetadataIntoParts(fileName string, var
 	return parts, nil
 }
 
-func (r MetadataPartsDirectoryReader) buildPartFromMetadata(metadata map[interface{}]interface{}, legacyFilename string) (Part, error) {
+func (r MetadataPartsDirectoryReader) buildPartFromMetadata(metadata map[interface{}]interface{}, filePath string, node *yamlv3.Node) (Part, error) {
 	name, ok := metadata["alias"].(string)
 	if !ok {
 		name, ok = metadata["name"].(string)
@@ -135,29 +184,70 @@ func (r MetadataPartsDirectoryReader) buildPartFromMetadata(metadata map[interfa
 	}
 	delete(metadata, "alias")
 
-	return Part{File: legacyFilename, Name: name, Metadata: metadata}, nil
+	when, ok := metadata["when"].(string)
+	if _, exists := metadata["when"]; exists && !ok {
+		return Part{}, fmt.Errorf("metadata item '%s' has a `when` field that is not a string", name)
+	}
+	delete(metadata, "when")
+
+	part := Part{File: pathpkg.Base(filePath), Name: name, Metadata: metadata}
+	if node != nil && node.Kind == yamlv3.MappingNode {
+		part.Source = &Source{Path: filePath, Node: withoutKeys(node, "alias", "when"), When: when}
+	}
+
+	return part, nil
 }
 
-func (r MetadataPartsDirectoryReader) orderWithOrderFromFile(path string, parts []Part) ([]Part, error) {
-	orderPath := filepath.Join(path, "_order.yml")
-	f, err := os.Open(orderPath)
-	if err != nil {
-		return []Part{}, err
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node == nil || node.Kind != yamlv3.MappingNode {
+		return nil
 	}
-	defer f.Close()
 
-	data, err := ioutil.ReadAll(f)
-	if err != nil {
-		return []Part{}, err
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolveAlias(node.Content[i+1])
+		}
 	}
 
-	var files map[string][]interface{}
-	err = yaml.Unmarshal([]byte(data), &files)
-	if err != nil {
-		return []Part{}, fmt.Errorf("Invalid format for '%s': %s", orderPath, err)
+	return nil
+}
+
+func sequenceItem(node *yamlv3.Node, index int) *yamlv3.Node {
+	if node == nil || node.Kind != yamlv3.SequenceNode || index >= len(node.Content) {
+		return nil
 	}
 
-	orderedNames, ok := files[r.orderKey]
+	return resolveAlias(node.Content[index])
+}
+
+func withoutKeys(node *yamlv3.Node, keys ...string) *yamlv3.Node {
+	without := *node
+	without.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if contains(keys, node.Content[i].Value) {
+			continue
+		}
+		without.Content = append(without.Content, node.Content[i], node.Content[i+1])
+	}
+
+	return &without
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r MetadataPartsDirectoryReader) orderWithOrderFromFile(path string, parts []Part) ([]Part, error) {
+	orderPath := filepath.Join(path, "_order.yml")
+	orderedNames, ok, err := r.readOrderFromFile(orderPath)
+	if err != nil {
+		return []Part{}, err
+	}
 	if !ok {
 		return []Part{}, fmt.Errorf("Could not find top-level order key '%s' in '%s'", r.orderKey, orderPath)
 	}
@@ -176,7 +266,73 @@ func (r MetadataPartsDirectoryReader) orderWithOrderFromFile(path string, parts
 		}
 	}
 
-	return outputs, err
+	return outputs, nil
+}
+
+// orderWithOptionalOrderFromFile orders the parts _order.yml lists under the
+// orderKey first. An _order.yml without the key does not order the parts,
+// and names without a part are skipped, since _order.yml files used to be
+// ignored by bake.
+func (r MetadataPartsDirectoryReader) orderWithOptionalOrderFromFile(path string, parts []Part) ([]Part, error) {
+	ordered, err := r.orderAlphabeticallyByName(path, parts)
+	if err != nil {
+		return []Part{}, err // un-tested
+	}
+
+	orderPath := filepath.Join(path, "_order.yml")
+	if !fileExists(orderPath) {
+		return ordered, nil
+	}
+
+	orderedNames, ok, err := r.readOrderFromFile(orderPath)
+	if err != nil {
+		return []Part{}, err
+	}
+	if !ok {
+		return ordered, nil
+	}
+
+	var listed []Part
+	isListed := map[string]bool{}
+	for _, name := range orderedNames {
+		for _, part := range parts {
+			if part.Name == name {
+				listed = append(listed, part)
+				isListed[part.Name] = true
+			}
+		}
+	}
+
+	for _, part := range ordered {
+		if !isListed[part.Name] {
+			listed = append(listed, part)
+		}
+	}
+
+	return listed, nil
+}
+
+// readOrderFromFile returns the names listed under the orderKey of an
+// _order.yml file and whether the file has the key.
+func (r MetadataPartsDirectoryReader) readOrderFromFile(orderPath string) ([]interface{}, bool, error) {
+	data, err := ioutil.ReadFile(orderPath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var files map[string][]interface{}
+	err = yaml.Unmarshal(data, &files)
+	if err != nil {
+		return nil, false, fmt.Errorf("Invalid format for '%s': %s", orderPath, err)
+	}
+
+	orderedNames, ok := files[r.orderKey]
+	return orderedNames, ok, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 func (r MetadataPartsDirectoryReader) orderAlphabeticallyByName(path string, parts []Part) ([]Part, error) {
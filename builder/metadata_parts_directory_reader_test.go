@@ -52,7 +52,7 @@ type: password
 		It("reads the contents of each yml file in the directory", func() {
 			vars, err := reader.Read(tempDir)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(vars).To(Equal([]builder.Part{
+			Expect(withoutSources(vars)).To(Equal([]builder.Part{
 				{
 					File: "vars-file-1.yml",
 					Name: "variable-1",
@@ -80,6 +80,41 @@ type: password
 			}))
 		})
 
+		It("keeps the YAML each part was read from", func() {
+			vars, err := reader.Read(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(vars[1].Source).NotTo(BeNil())
+			Expect(vars[1].Source.Path).To(Equal(filepath.Join(tempDir, "vars-file-1.yml")))
+			Expect(vars[1].Source.Node.Line).To(Equal(4))
+
+			var keys []string
+			for i := 0; i < len(vars[1].Source.Node.Content); i += 2 {
+				keys = append(keys, vars[1].Source.Node.Content[i].Value)
+			}
+			Expect(keys).To(Equal([]string{"name", "type"}))
+			Expect(vars[1].Value()).To(Equal(*vars[1].Source))
+		})
+
+		It("takes the when condition out of the part", func() {
+			err := ioutil.WriteFile(filepath.Join(tempDir, "vars-file-2.yml"), []byte(`---
+name: variable-3
+when: (variable "edition") == "enterprise"
+type: password
+`), 0755)
+			Expect(err).ToNot(HaveOccurred())
+
+			vars, err := reader.Read(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(vars[2].Metadata).To(Equal(map[interface{}]interface{}{
+				"name": "variable-3",
+				"type": "password",
+			}))
+			Expect(vars[2].Source.When).To(Equal(`(variable "edition") == "enterprise"`))
+			Expect(vars[2].Source.Node.Content).To(HaveLen(4))
+		})
+
 		Context("when the directory does not exist", func() {
 			It("returns an error", func() {
 				_, err := reader.Read("/dir/that/does/not/exist")
@@ -133,6 +168,18 @@ type: password
 				Expect(err.Error()).To(ContainSubstring("name"))
 			})
 		})
+
+		Context("when a part has a when condition that is not a string", func() {
+			BeforeEach(func() {
+				err := ioutil.WriteFile(filepath.Join(tempDir, "vars-file-1.yml"), []byte(`{name: variable-1, when: [true]}`), 0755)
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			It("returns an error", func() {
+				_, err := reader.Read(tempDir)
+				Expect(err).To(MatchError(ContainSubstring("metadata item 'variable-1' has a `when` field that is not a string")))
+			})
+		})
 	})
 
 	Context("when a top-level key is specified", func() {
@@ -168,7 +215,7 @@ variables:
 			It("reads the contents of each yml file in the directory", func() {
 				vars, err := reader.Read(tempDir)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(vars).To(Equal([]builder.Part{
+				Expect(withoutSources(vars)).To(Equal([]builder.Part{
 					{
 						File: "vars-file-1.yml",
 						Name: "variable-1",
@@ -262,6 +309,95 @@ variables:
 			})
 		})
 
+		Context("when specifying an optional Order key", func() {
+			BeforeEach(func() {
+				err := ioutil.WriteFile(filepath.Join(tempDir, "_order.yml"), []byte(`---
+variable_order:
+- variable-2
+`), 0755)
+				Expect(err).ToNot(HaveOccurred())
+
+				reader = builder.NewMetadataPartsDirectoryReaderWithOptionalOrder("variables", "variable_order")
+			})
+
+			It("returns the parts listed in _order.yml first and the rest sorted by name", func() {
+				vars, err := reader.Read(tempDir)
+				Expect(err).NotTo(HaveOccurred())
+
+				var names []string
+				for _, part := range vars {
+					names = append(names, part.Name)
+				}
+				Expect(names).To(Equal([]string{"variable-2", "variable-1", "variable-3"}))
+			})
+
+			Context("when there is no _order.yml file", func() {
+				BeforeEach(func() {
+					err := os.RemoveAll(filepath.Join(tempDir, "_order.yml"))
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("returns the parts sorted by name", func() {
+					vars, err := reader.Read(tempDir)
+					Expect(err).NotTo(HaveOccurred())
+
+					var names []string
+					for _, part := range vars {
+						names = append(names, part.Name)
+					}
+					Expect(names).To(Equal([]string{"variable-1", "variable-2", "variable-3"}))
+				})
+			})
+
+			Context("when _order.yml file does not have the order key", func() {
+				BeforeEach(func() {
+					err := ioutil.WriteFile(filepath.Join(tempDir, "_order.yml"), []byte(`other_order: [variable-3]`), 0755)
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("returns the parts sorted by name", func() {
+					vars, err := reader.Read(tempDir)
+					Expect(err).NotTo(HaveOccurred())
+
+					var names []string
+					for _, part := range vars {
+						names = append(names, part.Name)
+					}
+					Expect(names).To(Equal([]string{"variable-1", "variable-2", "variable-3"}))
+				})
+			})
+
+			Context("when _order.yml file contains a name that does not exist", func() {
+				BeforeEach(func() {
+					err := ioutil.WriteFile(filepath.Join(tempDir, "_order.yml"), []byte(`variable_order: [some-file, variable-3]`), 0755)
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("skips the name", func() {
+					vars, err := reader.Read(tempDir)
+					Expect(err).NotTo(HaveOccurred())
+
+					var names []string
+					for _, part := range vars {
+						names = append(names, part.Name)
+					}
+					Expect(names).To(Equal([]string{"variable-3", "variable-1", "variable-2"}))
+				})
+			})
+
+			Context("when _order.yml file is not in valid format", func() {
+				BeforeEach(func() {
+					err := ioutil.WriteFile(filepath.Join(tempDir, "_order.yml"), []byte(`variable_order: foo`), 0755)
+					Expect(err).ToNot(HaveOccurred())
+				})
+
+				It("returns an error", func() {
+					_, err := reader.Read(tempDir)
+					Expect(err.Error()).To(ContainSubstring("Invalid format"))
+				})
+			})
+		})
+
 		Context("when specifying an Order key", func() {
 			BeforeEach(func() {
 				reader = builder.NewMetadataPartsDirectoryReaderWithOrder("variables", "variable_order")
@@ -270,7 +406,7 @@ variables:
 			It("returns the contents of the files in the directory sorted by _order.yml", func() {
 				vars, err := reader.Read(tempDir)
 				Expect(err).NotTo(HaveOccurred())
-				Expect(vars).To(Equal([]builder.Part{
+				Expect(withoutSources(vars)).To(Equal([]builder.Part{
 					{
 						File: "vars-file-2.yml",
 						Name: "variable-3",
@@ -350,3 +486,12 @@ variables:
 		})
 	})
 })
+
+func withoutSources(parts []builder.Part) []builder.Part {
+	var withoutSources []builder.Part
+	for _, part := range parts {
+		part.Source = nil
+		withoutSources = append(withoutSources, part)
+	}
+	return withoutSources
+}
@@ -0,0 +1,339 @@
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// OpsFilePatcher applies BOSH ops files to the interpolated metadata. It
+// supports the replace and remove operations of go-patch with map keys, array
+// indexes, /- to append to an array, name=value to find an array item by one
+// of its keys and a trailing ? to make a path segment optional. The rest of
+// go-patch, such as the test operation, the error field, negative indexes and
+// the :prev, :next, :before and :after modifiers, is rejected rather than
+// applied differently.
+type OpsFilePatcher struct{}
+
+func NewOpsFilePatcher() OpsFilePatcher {
+	return OpsFilePatcher{}
+}
+
+type opsFileOperation struct {
+	Type  string    `yaml:"type"`
+	Path  string    `yaml:"path"`
+	Value yaml.Node `yaml:"value"`
+}
+
+// Patch applies the operations of each ops file in turn and returns the
+// patched metadata. Keys keep their order, so an ops file that changes one
+// value leaves the rest of the metadata as it was.
+func (p OpsFilePatcher) Patch(metadata []byte, opsFiles []string) ([]byte, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal(metadata, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	if document.Kind == 0 {
+		return nil, errors.New("metadata is empty")
+	}
+
+	for _, opsFile := range opsFiles {
+		contents, err := ioutil.ReadFile(opsFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var operations []opsFileOperation
+		decoder := yaml.NewDecoder(bytes.NewReader(contents))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&operations)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %s", opsFile, err)
+		}
+
+		for i, operation := range operations {
+			err = applyOperation(&document, operation)
+			if err != nil {
+				return nil, fmt.Errorf("%s: operation %d (%s %s): %s", opsFile, i, operation.Type, operation.Path, err)
+			}
+		}
+	}
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&document)
+	if err != nil {
+		return nil, err // un-tested
+	}
+	err = encoder.Close()
+	if err != nil {
+		return nil, err // un-tested
+	}
+
+	return buffer.Bytes(), nil
+}
+
+type patchToken struct {
+	segment  string
+	key      string
+	optional bool
+
+	// an array item is either appended (-), at an index or the one item
+	// whose matchKey is matchValue
+	appendItem bool
+	index      int
+	isIndex    bool
+	matchKey   string
+	matchValue string
+}
+
+func (t patchToken) isArrayToken() bool {
+	return t.appendItem || t.isIndex || t.matchKey != ""
+}
+
+// unsupportedPathModifiers are the go-patch modifiers that select an array
+// item relative to another one.
+var unsupportedPathModifiers = []string{":prev", ":next", ":before", ":after"}
+
+// parsePatchPath splits a path such as /job_types/name=web/templates/- into
+// tokens. Once a token is optional, the tokens after it are too.
+func parsePatchPath(path string) ([]patchToken, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("expected path to start with /")
+	}
+
+	if path == "/" {
+		return nil, nil
+	}
+
+	var (
+		tokens   []patchToken
+		optional bool
+	)
+	for _, segment := range strings.Split(path[1:], "/") {
+		token := patchToken{segment: segment}
+		if strings.HasSuffix(segment, "?") {
+			segment = strings.TrimSuffix(segment, "?")
+			optional = true
+		}
+		segment = strings.Replace(strings.Replace(segment, "~1", "/", -1), "~0", "~", -1)
+
+		if segment == "" {
+			return nil, fmt.Errorf("expected path to not have empty segments")
+		}
+
+		for _, modifier := range unsupportedPathModifiers {
+			if strings.HasSuffix(segment, modifier) {
+				return nil, fmt.Errorf("path modifier %s in %q is not supported", modifier, token.segment)
+			}
+		}
+
+		token.key, token.optional = segment, optional
+		if segment == "-" {
+			token.appendItem = true
+		} else if index, err := strconv.Atoi(segment); err == nil {
+			if index < 0 {
+				return nil, fmt.Errorf("negative index %d in %q is not supported", index, token.segment)
+			}
+			token.index, token.isIndex = index, true
+		} else if separator := strings.Index(segment, "="); separator > 0 {
+			token.matchKey, token.matchValue = segment[:separator], segment[separator+1:]
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func applyOperation(document *yaml.Node, operation opsFileOperation) error {
+	tokens, err := parsePatchPath(operation.Path)
+	if err != nil {
+		return err
+	}
+
+	switch operation.Type {
+	case "replace":
+		if operation.Value.Kind == 0 {
+			return errors.New("expected a value")
+		}
+	case "remove":
+	default:
+		return fmt.Errorf("operation type %q is not supported, expected replace or remove", operation.Type)
+	}
+
+	node := document.Content[0]
+	for i, token := range tokens {
+		path := "/" + patchPathString(tokens[:i+1])
+		last := i == len(tokens)-1
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			index := mappingKeyIndex(node, token.key)
+			if index < 0 {
+				if !token.optional {
+					return fmt.Errorf("expected to find a map key %q for path %q (found map keys: %s)", token.key, path, mappingKeys(node))
+				}
+				if operation.Type == "remove" {
+					return nil
+				}
+
+				child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				if !last && tokens[i+1].isArrayToken() {
+					child = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: token.key}, child)
+				index = len(node.Content) - 2
+			}
+
+			if last {
+				if operation.Type == "remove" {
+					node.Content = append(node.Content[:index], node.Content[index+2:]...)
+				} else {
+					node.Content[index+1] = copyNode(&operation.Value)
+				}
+				return nil
+			}
+
+			node = node.Content[index+1]
+
+		case yaml.SequenceNode:
+			index, err := sequenceIndex(node, token, path)
+			if err != nil {
+				return err
+			}
+
+			if index == len(node.Content) {
+				if operation.Type == "remove" {
+					if token.optional && !token.appendItem {
+						return nil
+					}
+					return fmt.Errorf("expected to find an array item for path %q", path)
+				}
+
+				if last {
+					node.Content = append(node.Content, copyNode(&operation.Value))
+					return nil
+				}
+
+				child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				if token.matchKey != "" {
+					child.Content = []*yaml.Node{
+						{Kind: yaml.ScalarNode, Tag: "!!str", Value: token.matchKey},
+						{Kind: yaml.ScalarNode, Tag: "!!str", Value: token.matchValue},
+					}
+				} else if tokens[i+1].isArrayToken() {
+					child = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+				}
+				node.Content = append(node.Content, child)
+			}
+
+			if last {
+				if operation.Type == "remove" {
+					node.Content = append(node.Content[:index], node.Content[index+1:]...)
+				} else {
+					node.Content[index] = copyNode(&operation.Value)
+				}
+				return nil
+			}
+
+			node = node.Content[index]
+
+		default:
+			parent := "/" + patchPathString(tokens[:i])
+			return fmt.Errorf("expected to find a map or array at path %q but found %s", parent, nodeKind(node))
+		}
+	}
+
+	// The path is /, the whole document.
+	if operation.Type == "remove" {
+		return errors.New("cannot remove the whole document")
+	}
+	document.Content[0] = copyNode(&operation.Value)
+
+	return nil
+}
+
+// sequenceIndex returns the index of the item the token refers to, or the
+// length of the sequence when the item is to be appended.
+func sequenceIndex(node *yaml.Node, token patchToken, path string) (int, error) {
+	switch {
+	case token.appendItem:
+		return len(node.Content), nil
+
+	case token.isIndex:
+		if token.index >= len(node.Content) {
+			return 0, fmt.Errorf("expected index %d to be less than array length %d for path %q", token.index, len(node.Content), path)
+		}
+		return token.index, nil
+
+	case token.matchKey != "":
+		var matches []int
+		for i, item := range node.Content {
+			if item.Kind != yaml.MappingNode {
+				continue
+			}
+			index := mappingKeyIndex(item, token.matchKey)
+			if index >= 0 && item.Content[index+1].Value == token.matchValue {
+				matches = append(matches, i)
+			}
+		}
+
+		switch {
+		case len(matches) == 1:
+			return matches[0], nil
+		case len(matches) == 0 && token.optional:
+			return len(node.Content), nil
+		default:
+			return 0, fmt.Errorf("expected to find exactly one matching array item for path %q but found %d", path, len(matches))
+		}
+
+	default:
+		return 0, fmt.Errorf("expected to find an index, - or key=value for path %q in an array", path)
+	}
+}
+
+func mappingKeyIndex(node *yaml.Node, key string) int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func mappingKeys(node *yaml.Node) string {
+	var keys []string
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys = append(keys, strconv.Quote(node.Content[i].Value))
+	}
+	return strings.Join(keys, ", ")
+}
+
+func patchPathString(tokens []patchToken) string {
+	var segments []string
+	for _, token := range tokens {
+		segments = append(segments, token.segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func nodeKind(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return "a scalar"
+	case yaml.AliasNode:
+		return "an alias"
+	default:
+		return "a document"
+	}
+}
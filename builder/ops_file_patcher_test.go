@@ -0,0 +1,237 @@
+package builder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/kiln/builder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("OpsFilePatcher", func() {
+	var (
+		tempDir  string
+		patcher  builder.OpsFilePatcher
+		metadata []byte
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "ops-files")
+		Expect(err).NotTo(HaveOccurred())
+
+		patcher = builder.NewOpsFilePatcher()
+
+		metadata = []byte(`---
+name: some-product
+form_types:
+- name: some-form
+  label: Some Form
+- name: other-form
+  label: Other Form
+job_types:
+- name: web
+  templates:
+  - name: some-job
+    release: some-release
+property_blueprints:
+- name: some-property
+  type: boolean
+  default: false
+`)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	writeOpsFile := func(name, contents string) string {
+		path := filepath.Join(tempDir, name)
+		Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+		return path
+	}
+
+	Describe("Patch", func() {
+		It("applies the operations of each ops file in order", func() {
+			opsFile := writeOpsFile("ops.yml", `---
+- type: replace
+  path: /property_blueprints/name=some-property/default
+  value: true
+- type: replace
+  path: /job_types/name=web/templates/-
+  value:
+    name: other-job
+    release: other-release
+- type: remove
+  path: /form_types/name=other-form
+`)
+			otherOpsFile := writeOpsFile("other-ops.yml", `---
+- type: replace
+  path: /job_types/0/templates/1/release
+  value: some-release
+`)
+
+			patchedMetadata, err := patcher.Patch(metadata, []string{opsFile, otherOpsFile})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(patchedMetadata)).To(Equal(`name: some-product
+form_types:
+  - name: some-form
+    label: Some Form
+job_types:
+  - name: web
+    templates:
+      - name: some-job
+        release: some-release
+      - name: other-job
+        release: some-release
+property_blueprints:
+  - name: some-property
+    type: boolean
+    default: true
+`))
+		})
+
+		It("creates optional paths that do not exist", func() {
+			opsFile := writeOpsFile("ops.yml", `---
+- type: replace
+  path: /job_types/name=worker?/templates/-
+  value: {name: worker-job, release: some-release}
+- type: replace
+  path: /requires_product_versions?/-
+  value: {name: cf, version: ~> 2.0}
+- type: replace
+  path: /form_types/name=some-form/description?
+  value: Some description
+- type: remove
+  path: /form_types/name=missing-form?
+- type: remove
+  path: /missing_key?
+`)
+
+			patchedMetadata, err := patcher.Patch(metadata, []string{opsFile})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patchedMetadata).To(HelpfullyMatchYAML(`
+name: some-product
+form_types:
+- name: some-form
+  label: Some Form
+  description: Some description
+- name: other-form
+  label: Other Form
+job_types:
+- name: web
+  templates:
+  - name: some-job
+    release: some-release
+- name: worker
+  templates:
+  - name: worker-job
+    release: some-release
+property_blueprints:
+- name: some-property
+  type: boolean
+  default: false
+requires_product_versions:
+- name: cf
+  version: ~> 2.0
+`))
+		})
+
+		It("unescapes ~1 and ~0 in path segments", func() {
+			metadata = []byte(`{labels: {"a/b": one, "c~d": two}}`)
+			opsFile := writeOpsFile("ops.yml", `---
+- type: replace
+  path: /labels/a~1b
+  value: three
+- type: remove
+  path: /labels/c~0d
+`)
+
+			patchedMetadata, err := patcher.Patch(metadata, []string{opsFile})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patchedMetadata).To(HelpfullyMatchYAML(`labels: {"a/b": three}`))
+		})
+
+		Context("failure cases", func() {
+			It("returns an error when a map key does not exist", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: replace, path: /form_types/name=some-form/description, value: x}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(opsFile + `: operation 0 (replace /form_types/name=some-form/description): expected to find a map key "description" for path "/form_types/name=some-form/description" (found map keys: "name", "label")`))
+			})
+
+			It("returns an error when no array item matches", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: remove, path: /form_types/name=missing-form}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring(`expected to find exactly one matching array item for path "/form_types/name=missing-form" but found 0`)))
+			})
+
+			It("returns an error when an index is out of range", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: replace, path: /form_types/5/label, value: x}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring(`expected index 5 to be less than array length 2 for path "/form_types/5"`)))
+			})
+
+			It("returns an error when the path goes through a scalar", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: replace, path: /name/label, value: x}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring(`expected to find a map or array at path "/name" but found a scalar`)))
+			})
+
+			It("returns an error for an unsupported operation type", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: test, path: /name, value: x}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring(`operation type "test" is not supported, expected replace or remove`)))
+			})
+
+			It("returns an error for an unsupported operation field", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: remove, path: /name, error: the name is required}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring(opsFile + ": yaml: unmarshal errors")))
+				Expect(err).To(MatchError(ContainSubstring("field error not found")))
+			})
+
+			It("returns an error for a negative index", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: remove, path: /form_types/-1}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring(`negative index -1 in "-1" is not supported`)))
+			})
+
+			It("returns an error for a path modifier", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: replace, path: /form_types/name=some-form:after, value: {name: new-form}}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring(`path modifier :after in "name=some-form:after" is not supported`)))
+			})
+
+			It("returns an error when a replace has no value", func() {
+				opsFile := writeOpsFile("ops.yml", `[{type: replace, path: /name}]`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring("expected a value")))
+			})
+
+			It("returns an error when the ops file is not a list of operations", func() {
+				opsFile := writeOpsFile("ops.yml", `type: replace`)
+
+				_, err := patcher.Patch(metadata, []string{opsFile})
+				Expect(err).To(MatchError(ContainSubstring(opsFile + ": yaml: unmarshal errors")))
+			})
+
+			It("returns an error when the ops file does not exist", func() {
+				_, err := patcher.Patch(metadata, []string{filepath.Join(tempDir, "missing.yml")})
+				Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+			})
+		})
+	})
+})
@@ -0,0 +1,164 @@
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+//go:generate counterfeiter -o ./fakes/part_reader.go --fake-name PartReader . partReader
+type partReader interface {
+	Read(path string) (Part, error)
+}
+
+// ReleaseManifestCache wraps a ReleaseManifestReader and remembers the
+// manifests it read in a file, keyed by the tarball's path, size and
+// modification time. Bakes with unchanged releases then do not read the
+// tarballs again. The cache is best effort: when its file cannot be read or
+// written, releases are read as if it were empty. Manifests read since the
+// cache was loaded are only written to the file by Save.
+type ReleaseManifestCache struct {
+	reader partReader
+	path   string
+	logger logger
+
+	load    sync.Once
+	mutex   sync.Mutex
+	entries map[string]releaseManifestCacheEntry
+	dirty   bool
+}
+
+type releaseManifestCacheEntry struct {
+	Size     int64           `json:"size"`
+	ModTime  time.Time       `json:"mod_time"`
+	Manifest ReleaseManifest `json:"manifest"`
+}
+
+func NewReleaseManifestCache(reader partReader, path string, logger logger) *ReleaseManifestCache {
+	return &ReleaseManifestCache{
+		reader: reader,
+		path:   path,
+		logger: logger,
+	}
+}
+
+func (c *ReleaseManifestCache) Read(releaseTarball string) (Part, error) {
+	c.load.Do(c.loadEntries)
+
+	key, err := filepath.Abs(releaseTarball)
+	if err != nil {
+		return Part{}, err
+	}
+
+	info, err := os.Stat(releaseTarball)
+	if err != nil {
+		return Part{}, err
+	}
+
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+
+	if ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+		return Part{
+			Name:     entry.Manifest.Name,
+			Metadata: entry.Manifest,
+		}, nil
+	}
+
+	part, err := c.reader.Read(releaseTarball)
+	if err != nil {
+		return Part{}, err
+	}
+
+	manifest, ok := part.Metadata.(ReleaseManifest)
+	if !ok {
+		return part, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[key] = releaseManifestCacheEntry{
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Manifest: manifest,
+	}
+	c.dirty = true
+
+	return part, nil
+}
+
+// Save writes the cache to its file when manifests were read since it was
+// loaded or last saved. Entries for tarballs that no longer exist are
+// dropped first, so the file does not grow with every releases directory
+// that was ever baked.
+func (c *ReleaseManifestCache) Save() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.entries {
+		if _, err := os.Stat(key); os.IsNotExist(err) {
+			delete(c.entries, key)
+			c.dirty = true
+		}
+	}
+
+	if !c.dirty {
+		return
+	}
+
+	c.saveEntries()
+	c.dirty = false
+}
+
+func (c *ReleaseManifestCache) loadEntries() {
+	c.entries = map[string]releaseManifestCacheEntry{}
+
+	contents, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(contents, &c.entries)
+	if err != nil {
+		c.logger.Printf("ignoring release manifest cache %s: %s", c.path, err)
+		c.entries = map[string]releaseManifestCacheEntry{}
+	}
+}
+
+// saveEntries writes the cache to a temporary file and renames it into
+// place, so a concurrent bake never reads a partially written cache.
+func (c *ReleaseManifestCache) saveEntries() {
+	contents, err := json.Marshal(c.entries)
+	if err != nil {
+		return // should never happen
+	}
+
+	err = os.MkdirAll(filepath.Dir(c.path), 0755)
+	if err != nil {
+		c.logger.Printf("could not save release manifest cache: %s", err)
+		return
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path))
+	if err != nil {
+		c.logger.Printf("could not save release manifest cache: %s", err)
+		return
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(contents)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), c.path)
+	}
+	if err != nil {
+		c.logger.Printf("could not save release manifest cache: %s", err)
+	}
+}
@@ -0,0 +1,187 @@
+package builder_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pivotal-cf/kiln/builder"
+	"github.com/pivotal-cf/kiln/builder/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReleaseManifestCache", func() {
+	var (
+		tmpDir    string
+		tarball   string
+		cachePath string
+		reader    *fakes.PartReader
+		logger    *fakes.Logger
+		manifest  builder.ReleaseManifest
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "release-manifest-cache")
+		Expect(err).NotTo(HaveOccurred())
+
+		tarball = filepath.Join(tmpDir, "some-release-1.2.3.tgz")
+		Expect(ioutil.WriteFile(tarball, []byte("some-release-contents"), 0644)).To(Succeed())
+
+		cachePath = filepath.Join(tmpDir, "cache", "release-manifests.json")
+
+		manifest = builder.ReleaseManifest{
+			Name:            "some-release",
+			Version:         "1.2.3",
+			File:            "some-release-1.2.3.tgz",
+			SHA1:            "some-sha1",
+			StemcellOS:      "some-os",
+			StemcellVersion: "1.2",
+		}
+
+		reader = &fakes.PartReader{}
+		reader.ReadReturns(builder.Part{Name: "some-release", Metadata: manifest}, nil)
+		logger = &fakes.Logger{}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	// bake reads the tarball with a new cache and saves it, like a bake does.
+	bake := func(tarball string) (builder.Part, error) {
+		cache := builder.NewReleaseManifestCache(reader, cachePath, logger)
+		part, err := cache.Read(tarball)
+		cache.Save()
+		return part, err
+	}
+
+	It("reads a tarball once across bakes", func() {
+		part, err := bake(tarball)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(part).To(Equal(builder.Part{Name: "some-release", Metadata: manifest}))
+
+		part, err = bake(tarball)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(part).To(Equal(builder.Part{Name: "some-release", Metadata: manifest}))
+
+		Expect(reader.ReadCallCount()).To(Equal(1))
+		Expect(reader.ReadArgsForCall(0)).To(Equal(tarball))
+	})
+
+	It("only writes the cache file when it is saved", func() {
+		otherTarball := filepath.Join(tmpDir, "other-release-1.2.3.tgz")
+		Expect(ioutil.WriteFile(otherTarball, []byte("other-release-contents"), 0644)).To(Succeed())
+
+		cache := builder.NewReleaseManifestCache(reader, cachePath, logger)
+		_, err := cache.Read(tarball)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = cache.Read(otherTarball)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = os.Stat(cachePath)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+
+		cache.Save()
+
+		_, err = bake(tarball)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = bake(otherTarball)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reader.ReadCallCount()).To(Equal(2))
+	})
+
+	It("does not write the cache file when nothing was read", func() {
+		_, err := bake(tarball)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(cachePath)).To(Succeed())
+
+		cache := builder.NewReleaseManifestCache(reader, cachePath, logger)
+		cache.Save()
+
+		_, err = os.Stat(cachePath)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("drops the entries of tarballs that no longer exist when it is saved", func() {
+		otherTarball := filepath.Join(tmpDir, "other-release-1.2.3.tgz")
+		Expect(ioutil.WriteFile(otherTarball, []byte("other-release-contents"), 0644)).To(Succeed())
+
+		_, err := bake(otherTarball)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.Remove(otherTarball)).To(Succeed())
+
+		_, err = bake(tarball)
+		Expect(err).NotTo(HaveOccurred())
+
+		contents, err := ioutil.ReadFile(cachePath)
+		Expect(err).NotTo(HaveOccurred())
+
+		var entries map[string]interface{}
+		Expect(json.Unmarshal(contents, &entries)).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries).To(HaveKey(tarball))
+	})
+
+	Context("when the tarball changed since it was cached", func() {
+		It("reads it again", func() {
+			_, err := bake(tarball)
+			Expect(err).NotTo(HaveOccurred())
+
+			later := time.Now().Add(time.Hour)
+			Expect(os.Chtimes(tarball, later, later)).To(Succeed())
+
+			_, err = bake(tarball)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(tarball, []byte("other-release-contents"), 0644)).To(Succeed())
+			Expect(os.Chtimes(tarball, later, later)).To(Succeed())
+
+			_, err = bake(tarball)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(reader.ReadCallCount()).To(Equal(3))
+		})
+	})
+
+	Context("when the cache file is corrupt", func() {
+		It("reads the tarball and replaces the cache", func() {
+			Expect(os.MkdirAll(filepath.Dir(cachePath), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(cachePath, []byte("not json"), 0644)).To(Succeed())
+
+			part, err := bake(tarball)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(part.Metadata).To(Equal(manifest))
+			Expect(logger.PrintfCall.Receives.LogLines).To(ConsistOf(ContainSubstring("ignoring release manifest cache")))
+
+			_, err = bake(tarball)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reader.ReadCallCount()).To(Equal(1))
+		})
+	})
+
+	Context("when the reader fails", func() {
+		It("returns the error and does not cache anything", func() {
+			reader.ReadReturns(builder.Part{}, errors.New("failed to read release"))
+
+			_, err := bake(tarball)
+			Expect(err).To(MatchError("failed to read release"))
+
+			_, err = os.Stat(cachePath)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("when the tarball does not exist", func() {
+		It("returns an error", func() {
+			_, err := bake(filepath.Join(tmpDir, "missing.tgz"))
+			Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+			Expect(reader.ReadCallCount()).To(Equal(0))
+		})
+	})
+})
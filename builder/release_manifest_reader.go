@@ -46,7 +46,12 @@ func (r ReleaseManifestReader) Read(releaseTarball string) (Part, error) {
 	}
 	defer file.Close()
 
-	gr, err := gzip.NewReader(file)
+	// Hash every byte as the tarball is read, so that after release.MF is
+	// found only the rest of the file has to be read to finish the SHA1.
+	hash := sha1.New()
+	tarball := io.TeeReader(file, hash)
+
+	gr, err := gzip.NewReader(tarball)
 	if err != nil {
 		return Part{}, err
 	}
@@ -102,13 +107,7 @@ func (r ReleaseManifestReader) Read(releaseTarball string) (Part, error) {
 
 	outputReleaseManifest.File = filepath.Base(releaseTarball)
 
-	_, err = file.Seek(0, 0)
-	if err != nil {
-		return Part{}, err // NOTE: cannot replicate this error scenario in a test
-	}
-
-	hash := sha1.New()
-	_, err = io.Copy(hash, file)
+	_, err = io.Copy(ioutil.Discard, tarball)
 	if err != nil {
 		return Part{}, err // NOTE: cannot replicate this error scenario in a test
 	}
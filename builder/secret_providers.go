@@ -0,0 +1,156 @@
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SecretProvider looks up the secrets the secret template helper asks for,
+// so that credentials do not have to be written to a variables file. Found
+// is false when the provider does not have the secret.
+type SecretProvider interface {
+	Secret(name string) (value string, found bool, err error)
+	String() string
+}
+
+// NewSecretProviders returns the providers for a secrets directory and a
+// secrets helper command. Either may be empty.
+func NewSecretProviders(directory, helperCommand string) []SecretProvider {
+	var providers []SecretProvider
+	if directory != "" {
+		providers = append(providers, NewDirectorySecrets(directory))
+	}
+	if helperCommand != "" {
+		providers = append(providers, NewHelperSecrets(helperCommand))
+	}
+	return providers
+}
+
+// DirectorySecrets reads each secret from a file named after it, the way
+// Kubernetes and Docker mount secrets. A trailing newline is dropped.
+type DirectorySecrets struct {
+	directory string
+}
+
+func NewDirectorySecrets(directory string) DirectorySecrets {
+	return DirectorySecrets{directory: directory}
+}
+
+func (d DirectorySecrets) Secret(name string) (string, bool, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") {
+		return "", false, fmt.Errorf("secret name %q must be a path inside the secrets directory", name)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(d.directory, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+
+	return strings.TrimSuffix(string(contents), "\n"), true, nil
+}
+
+func (d DirectorySecrets) String() string {
+	return "secrets directory " + d.directory
+}
+
+// HelperSecrets runs a credential helper to look up secrets. The helper is
+// run as `<command> get` with {"name": "<secret name>"} on stdin and answers
+// with {"value": "<secret>"} on stdout, or {} when it does not have the
+// secret. The secret never appears in the arguments of a process. Answers
+// are cached, so the helper is asked for each secret once, even by
+// concurrent callers.
+type HelperSecrets struct {
+	command []string
+
+	mutex sync.Mutex
+	cache map[string]*string
+}
+
+func NewHelperSecrets(command string) *HelperSecrets {
+	return &HelperSecrets{
+		command: strings.Fields(command),
+		cache:   map[string]*string{},
+	}
+}
+
+func (h *HelperSecrets) Secret(name string) (string, bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if value, ok := h.cache[name]; ok {
+		if value == nil {
+			return "", false, nil
+		}
+		return *value, true, nil
+	}
+
+	if len(h.command) == 0 {
+		return "", false, fmt.Errorf("secrets helper command is empty")
+	}
+
+	request, err := json.Marshal(map[string]string{"name": name})
+	if err != nil {
+		return "", false, err // should never happen
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(h.command[0], append(h.command[1:], "get")...)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return "", false, fmt.Errorf("secrets helper %q failed to get %q: %s: %s", h.command[0], name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var response struct {
+		Value *string `json:"value"`
+	}
+	err = json.Unmarshal(stdout.Bytes(), &response)
+	if err != nil {
+		return "", false, fmt.Errorf("secrets helper %q returned an invalid response for %q: %s", h.command[0], name, err)
+	}
+
+	h.cache[name] = response.Value
+	if response.Value == nil {
+		return "", false, nil
+	}
+
+	return *response.Value, true, nil
+}
+
+func (h *HelperSecrets) String() string {
+	return "secrets helper " + strings.Join(h.command, " ")
+}
+
+// lookupSecret asks each provider in turn for the secret.
+func lookupSecret(providers []SecretProvider, name string) (string, error) {
+	if len(providers) == 0 {
+		return "", fmt.Errorf("secret %q requires --secrets-directory or --secrets-helper", name)
+	}
+
+	var places []string
+	for _, provider := range providers {
+		value, found, err := provider.Secret(name)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return value, nil
+		}
+		places = append(places, provider.String())
+	}
+
+	return "", fmt.Errorf("secret %q was not found in %s", name, strings.Join(places, " or "))
+}
@@ -0,0 +1,143 @@
+package builder_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/onsi/gomega/gexec"
+	"github.com/pivotal-cf/kiln/builder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SecretProviders", func() {
+	var tempDir string
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "secret-providers")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Describe("NewSecretProviders", func() {
+		It("returns a provider for each configured source", func() {
+			Expect(builder.NewSecretProviders("", "")).To(BeEmpty())
+			Expect(builder.NewSecretProviders(tempDir, "some-helper --flag")).To(Equal([]builder.SecretProvider{
+				builder.NewDirectorySecrets(tempDir),
+				builder.NewHelperSecrets("some-helper --flag"),
+			}))
+		})
+	})
+
+	Describe("DirectorySecrets", func() {
+		var provider builder.DirectorySecrets
+
+		BeforeEach(func() {
+			provider = builder.NewDirectorySecrets(tempDir)
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "aws_key"), []byte("some-aws-key\n"), 0600)).To(Succeed())
+		})
+
+		It("reads the secret from the file named after it", func() {
+			value, found, err := provider.Secret("aws_key")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(value).To(Equal("some-aws-key"))
+		})
+
+		It("does not find a secret without a file", func() {
+			_, found, err := provider.Secret("missing")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+		})
+
+		It("rejects names outside of the directory", func() {
+			_, _, err := provider.Secret("../aws_key")
+			Expect(err).To(MatchError(`secret name "../aws_key" must be a path inside the secrets directory`))
+		})
+	})
+
+	Describe("HelperSecrets", func() {
+		var (
+			helperPath string
+			logPath    string
+			provider   *builder.HelperSecrets
+		)
+
+		BeforeEach(func() {
+			var err error
+			helperPath, err = gexec.Build("github.com/pivotal-cf/kiln/builder/fixtures/secrets-helper")
+			Expect(err).NotTo(HaveOccurred())
+
+			logPath = filepath.Join(tempDir, "helper.log")
+			os.Setenv("SECRETS_HELPER_LOG", logPath)
+
+			provider = builder.NewHelperSecrets(helperPath)
+		})
+
+		AfterEach(func() {
+			os.Unsetenv("SECRETS_HELPER_LOG")
+			gexec.CleanupBuildArtifacts()
+		})
+
+		It("asks the helper for the secret once", func() {
+			value, found, err := provider.Secret("certificate")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(value).To(Equal("-----BEGIN CERTIFICATE-----\nsome-certificate\n-----END CERTIFICATE-----\n"))
+
+			value, found, err = provider.Secret("certificate")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeTrue())
+			Expect(value).To(ContainSubstring("some-certificate"))
+
+			log, err := ioutil.ReadFile(logPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(log)).To(Equal("certificate\n"))
+		})
+
+		It("asks the helper for the secret once when it is looked up concurrently", func() {
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+
+					_, found, err := provider.Secret("certificate")
+					Expect(err).NotTo(HaveOccurred())
+					Expect(found).To(BeTrue())
+				}()
+			}
+			wg.Wait()
+
+			log, err := ioutil.ReadFile(logPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(log)).To(Equal("certificate\n"))
+		})
+
+		It("does not find a secret the helper does not have", func() {
+			_, found, err := provider.Secret("missing")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(found).To(BeFalse())
+		})
+
+		Context("failure cases", func() {
+			It("returns the helper's error output when it fails", func() {
+				_, _, err := provider.Secret("broken")
+				Expect(err).To(MatchError(ContainSubstring(`failed to get "broken": exit status 1: the vault is sealed`)))
+			})
+
+			It("returns an error when the helper's response is not JSON", func() {
+				_, _, err := provider.Secret("garbage")
+				Expect(err).To(MatchError(ContainSubstring(`returned an invalid response for "garbage"`)))
+			})
+		})
+	})
+})
@@ -0,0 +1,174 @@
+package builder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// minimumSensitiveValueLength keeps short values such as "true" or "1" from
+// matching all over a tile. Nothing that short is worth guarding.
+const minimumSensitiveValueLength = 4
+
+// SensitiveValue is a value that must not be baked into a tile, such as a
+// secret or a variable the variables schema declares sensitive. Source says
+// where it came from without repeating the value.
+type SensitiveValue struct {
+	Source string
+	Value  string
+}
+
+// SecretRecorder records the secrets that secret providers return, so that
+// bake can check that none of them end up in the tile.
+type SecretRecorder struct {
+	values []SensitiveValue
+}
+
+func NewSecretRecorder() *SecretRecorder {
+	return &SecretRecorder{}
+}
+
+// Record wraps the providers so that the secrets they find are recorded.
+func (r *SecretRecorder) Record(providers []SecretProvider) []SecretProvider {
+	var recorded []SecretProvider
+	for _, provider := range providers {
+		recorded = append(recorded, recordedSecrets{SecretProvider: provider, recorder: r})
+	}
+	return recorded
+}
+
+// SensitiveValues returns the secrets the providers have found so far.
+func (r *SecretRecorder) SensitiveValues() []SensitiveValue {
+	return r.values
+}
+
+type recordedSecrets struct {
+	SecretProvider
+	recorder *SecretRecorder
+}
+
+func (r recordedSecrets) Secret(name string) (string, bool, error) {
+	value, found, err := r.SecretProvider.Secret(name)
+	if found {
+		r.recorder.values = append(r.recorder.values, SensitiveValue{
+			Source: fmt.Sprintf("secret %q", name),
+			Value:  value,
+		})
+	}
+	return value, found, err
+}
+
+type secretPattern struct {
+	description string
+	regexp      *regexp.Regexp
+}
+
+// defaultSecretPatterns match credentials that never belong in a tile.
+var defaultSecretPatterns = []secretPattern{
+	{"looks like an AWS access key ID", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"looks like an AWS secret access key", regexp.MustCompile(`(?i)aws.{0,20}secret.{0,20}['"=: ]\s*[A-Za-z0-9/+]{40}\b`)},
+	{"looks like a private key", regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY-----`)},
+	{"looks like a GitHub token", regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36}\b`)},
+	{"looks like a Slack token", regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+}
+
+// SecretScanner refuses tiles whose metadata or embedded files contain
+// sensitive values or match the patterns of common credentials.
+type SecretScanner struct{}
+
+func NewSecretScanner() SecretScanner {
+	return SecretScanner{}
+}
+
+// Scan reports every place in the metadata and the files under embedPaths
+// that contains one of the sensitive values or matches a secret pattern.
+// The patterns are regular expressions added to the default ones, unless
+// skipDefaultPatterns is set. Values are never included in the error.
+func (s SecretScanner) Scan(metadata []byte, embedPaths []string, sensitiveValues []SensitiveValue, patterns []string, skipDefaultPatterns bool) error {
+	var secretPatterns []secretPattern
+	if !skipDefaultPatterns {
+		secretPatterns = append(secretPatterns, defaultSecretPatterns...)
+	}
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid secret pattern %q: %s", pattern, err)
+		}
+		secretPatterns = append(secretPatterns, secretPattern{description: fmt.Sprintf("matches the secret pattern %q", pattern), regexp: compiled})
+	}
+
+	findings := scanContents("metadata", metadata, sensitiveValues, secretPatterns)
+
+	for _, embedPath := range embedPaths {
+		err := filepath.Walk(embedPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			findings = append(findings, scanContents("embedded file "+path, contents, sensitiveValues, secretPatterns)...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(findings) > 0 {
+		return errors.New("- " + strings.Join(findings, "\n- "))
+	}
+
+	return nil
+}
+
+func scanContents(name string, contents []byte, values []SensitiveValue, patterns []secretPattern) []string {
+	var findings []string
+
+	for _, value := range values {
+		if index := indexSensitiveValue(contents, value.Value); index >= 0 {
+			findings = append(findings, fmt.Sprintf("%s line %d contains the value of %s", name, lineNumber(contents, index), value.Source))
+		}
+	}
+
+	for _, pattern := range patterns {
+		if location := pattern.regexp.FindIndex(contents); location != nil {
+			findings = append(findings, fmt.Sprintf("%s line %d %s", name, lineNumber(contents, location[0]), pattern.description))
+		}
+	}
+
+	return findings
+}
+
+// indexSensitiveValue looks for each line of the value on its own, since a
+// value with several lines is indented or escaped once it is written as YAML.
+// PEM armor lines are skipped because every certificate has them.
+func indexSensitiveValue(contents []byte, value string) int {
+	for _, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) < minimumSensitiveValueLength || strings.HasPrefix(line, "-----") {
+			continue
+		}
+
+		if index := bytes.Index(contents, []byte(line)); index >= 0 {
+			return index
+		}
+	}
+
+	return -1
+}
+
+func lineNumber(contents []byte, index int) int {
+	return bytes.Count(contents[:index], []byte("\n")) + 1
+}
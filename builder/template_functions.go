@@ -0,0 +1,407 @@
+package builder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// templateFunctions are the general purpose helpers available in metadata and
+// parts alongside the helpers that look up releases, forms and so on. They
+// are modelled on Sprig but only work on their arguments: none of them read
+// files, the environment or the network.
+func templateFunctions() template.FuncMap {
+	return template.FuncMap{
+		// defaults and conditionals
+		"default":  defaultValue,
+		"empty":    isEmpty,
+		"coalesce": coalesce,
+		"ternary":  ternary,
+		"required": required,
+
+		// encoding
+		"toJson":   toJSON,
+		"fromJson": fromJSON,
+		"toYaml":   toYAML,
+
+		// strings
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      title,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"quote":      func(value interface{}) string { return strconv.Quote(toString(value)) },
+		"squote":     func(value interface{}) string { return "'" + strings.Replace(toString(value), "'", "''", -1) + "'" },
+		"indent":     indent,
+		"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+		"join":       join,
+		"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+		"toString":   toString,
+
+		// lists and dicts
+		"list":   func(items ...interface{}) []interface{} { return items },
+		"append": appendItem,
+		"first":  first,
+		"last":   last,
+		"dict":   dict,
+		"get":    get,
+		"hasKey": hasKey,
+		"keys":   keys,
+
+		// arithmetic
+		"int":   toInt64,
+		"float": toFloat64,
+		"add": func(a, b interface{}) (int64, error) {
+			return arithmetic(a, b, func(a, b int64) int64 { return a + b })
+		},
+		"sub": func(a, b interface{}) (int64, error) {
+			return arithmetic(a, b, func(a, b int64) int64 { return a - b })
+		},
+		"mul": func(a, b interface{}) (int64, error) {
+			return arithmetic(a, b, func(a, b int64) int64 { return a * b })
+		},
+		"div": divide,
+		"mod": modulo,
+		"max": func(a, b interface{}) (int64, error) { return arithmetic(a, b, maxInt64) },
+		"min": func(a, b interface{}) (int64, error) { return arithmetic(a, b, minInt64) },
+	}
+}
+
+func defaultValue(defaultValue, value interface{}) interface{} {
+	if isEmpty(value) {
+		return defaultValue
+	}
+	return value
+}
+
+// isEmpty reports whether the value is nil, false, zero or an empty string,
+// list or map.
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return reflect.DeepEqual(value, reflect.Zero(v.Type()).Interface())
+	}
+}
+
+func coalesce(values ...interface{}) interface{} {
+	for _, value := range values {
+		if !isEmpty(value) {
+			return value
+		}
+	}
+	return nil
+}
+
+func ternary(trueValue, falseValue interface{}, condition bool) interface{} {
+	if condition {
+		return trueValue
+	}
+	return falseValue
+}
+
+func required(message string, value interface{}) (interface{}, error) {
+	if isEmpty(value) {
+		return nil, errors.New(message)
+	}
+	return value, nil
+}
+
+// toJSON goes through a YAML node rather than encoding/json so that maps read
+// from YAML files, which have interface{} keys, can be written out too.
+func toJSON(value interface{}) (string, error) {
+	var node yaml.Node
+	err := node.Encode(value)
+	if err != nil {
+		return "", fmt.Errorf("could not JSON marshal %v: %s", value, err)
+	}
+
+	output, err := nodeToJSON(&node)
+	if err != nil {
+		return "", fmt.Errorf("could not JSON marshal %v: %s", value, err)
+	}
+	return string(output), nil
+}
+
+func fromJSON(input string) (interface{}, error) {
+	var value interface{}
+	err := json.Unmarshal([]byte(input), &value)
+	if err != nil {
+		return nil, fmt.Errorf("could not JSON unmarshal %q: %s", input, err)
+	}
+	return value, nil
+}
+
+func toYAML(value interface{}) (string, error) {
+	output, err := yaml.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("could not YAML marshal %v: %s", value, err)
+	}
+	return strings.TrimSuffix(string(output), "\n"), nil
+}
+
+// title capitalizes the first letter of each word and leaves the whitespace
+// between words as it is.
+func title(s string) string {
+	var b strings.Builder
+	wordStart := true
+	for _, r := range s {
+		if wordStart && !unicode.IsSpace(r) {
+			r = unicode.ToTitle(r)
+		}
+		wordStart = unicode.IsSpace(r)
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func indent(spaces int, s string) string {
+	padding := strings.Repeat(" ", spaces)
+	return padding + strings.Replace(s, "\n", "\n"+padding, -1)
+}
+
+func join(sep string, list interface{}) (string, error) {
+	items, err := toList(list)
+	if err != nil {
+		return "", err
+	}
+
+	var values []string
+	for _, item := range items {
+		values = append(values, toString(item))
+	}
+
+	return strings.Join(values, sep), nil
+}
+
+func toString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toList(list interface{}) ([]interface{}, error) {
+	if list == nil {
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a list, got %T", list)
+	}
+
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		items[i] = v.Index(i).Interface()
+	}
+
+	return items, nil
+}
+
+func appendItem(list interface{}, item interface{}) ([]interface{}, error) {
+	items, err := toList(list)
+	if err != nil {
+		return nil, err
+	}
+	return append(items, item), nil
+}
+
+func first(list interface{}) (interface{}, error) {
+	items, err := toList(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+func last(list interface{}) (interface{}, error) {
+	items, err := toList(list)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[len(items)-1], nil
+}
+
+func dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("dict requires an even number of arguments")
+	}
+
+	d := map[string]interface{}{}
+	for i := 0; i < len(pairs); i += 2 {
+		d[toString(pairs[i])] = pairs[i+1]
+	}
+
+	return d, nil
+}
+
+func toMap(value interface{}) (map[string]interface{}, error) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, nil
+	case Metadata:
+		return m, nil
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, value := range m {
+			converted[toString(key)] = value
+		}
+		return converted, nil
+	default:
+		return nil, fmt.Errorf("expected a dict, got %T", value)
+	}
+}
+
+func get(d interface{}, key string) (interface{}, error) {
+	m, err := toMap(d)
+	if err != nil {
+		return nil, err
+	}
+	return m[key], nil
+}
+
+func hasKey(d interface{}, key string) (bool, error) {
+	m, err := toMap(d)
+	if err != nil {
+		return false, err
+	}
+	_, ok := m[key]
+	return ok, nil
+}
+
+func keys(d interface{}) ([]string, error) {
+	m, err := toMap(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int32:
+		return int64(v), nil
+	case uint64:
+		return int64(v), nil
+	case float64:
+		return int64(v), nil
+	case string:
+		trimmed := strings.Trim(strings.TrimSpace(v), `"`)
+		i, err := strconv.ParseInt(trimmed, 10, 64)
+		if err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return 0, fmt.Errorf("could not convert %q to a number", v)
+		}
+		return int64(f), nil
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("could not convert %v (%T) to a number", value, value)
+	}
+}
+
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.Trim(strings.TrimSpace(v), `"`), 64)
+		if err != nil {
+			return 0, fmt.Errorf("could not convert %q to a number", v)
+		}
+		return f, nil
+	default:
+		i, err := toInt64(value)
+		return float64(i), err
+	}
+}
+
+func arithmetic(a, b interface{}, operation func(a, b int64) int64) (int64, error) {
+	x, err := toInt64(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt64(b)
+	if err != nil {
+		return 0, err
+	}
+	return operation(x, y), nil
+}
+
+func divide(a, b interface{}) (int64, error) {
+	y, err := toInt64(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return arithmetic(a, b, func(a, b int64) int64 { return a / b })
+}
+
+func modulo(a, b interface{}) (int64, error) {
+	y, err := toInt64(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return arithmetic(a, b, func(a, b int64) int64 { return a % b })
+}
+
+func maxInt64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
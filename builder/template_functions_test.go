@@ -0,0 +1,157 @@
+package builder_test
+
+import (
+	"github.com/pivotal-cf/kiln/builder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/pivotal-cf-experimental/gomegamatchers"
+)
+
+var _ = Describe("template functions", func() {
+	var (
+		input        builder.InterpolateInput
+		interpolator builder.Interpolator
+	)
+
+	BeforeEach(func() {
+		interpolator = builder.NewInterpolator()
+
+		input = builder.InterpolateInput{
+			Variables: map[string]interface{}{
+				"name":       "some-product",
+				"enterprise": true,
+				"instances":  3,
+				"azs":        []interface{}{"z1", "z2"},
+				"network": map[interface{}]interface{}{
+					"name": "some-network",
+				},
+			},
+			FormTypes: map[string]interface{}{
+				"some-form": builder.Metadata{
+					"name":  "some-form",
+					"label": `$( .name | upper )`,
+				},
+			},
+		}
+	})
+
+	It("provides defaults, conditionals, strings, lists, dicts and arithmetic", func() {
+		interpolatedYAML, err := interpolator.Interpolate(input, []byte(`---
+label: $( if .enterprise )Enterprise$( else )Community$( end ) Edition
+title: $( .name | replace "-" " " | title )
+description: $( .missing | default "no description" )
+edition: $( ternary "enterprise" "community" .enterprise )
+azs: $( .azs | toJson )
+az_list: $( join ", " .azs )
+first_az: $( first .azs )
+network: $( .network | toJson )
+network_name: $( get .network "name" )
+has_network_name: $( hasKey .network "name" )
+dict: $( add .instances 2 | dict "instances" | toJson )
+list: $( list "a" "b" | toJson )
+arithmetic: [$( mul .instances 2 ), $( sub .instances 1 ), $( div 7 2 ), $( mod 7 2 ), $( max 2 .instances ), $( min 2 .instances )]
+quoted: $( quote .name )
+trimmed: $( trimSuffix "-product" .name )
+contains: $( contains "product" .name )
+form: $( form "some-form" | fromJson | toJson )
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+label: Enterprise Edition
+title: Some Product
+description: no description
+edition: enterprise
+azs: [z1, z2]
+az_list: z1, z2
+first_az: z1
+network:
+  name: some-network
+network_name: some-network
+has_network_name: true
+dict:
+  instances: 5
+list: [a, b]
+arithmetic: [6, 2, 3, 1, 3, 2]
+quoted: some-product
+trimmed: some
+contains: true
+form:
+  name: some-form
+  label: SOME-PRODUCT
+`))
+	})
+
+	It("titles words that start with a multi-byte letter", func() {
+		interpolatedYAML, err := interpolator.Interpolate(input, []byte(`title: $( title "éclair über product" )`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interpolatedYAML).To(HelpfullyMatchYAML(`title: Éclair Über Product`))
+	})
+
+	It("keeps the whitespace between the words it titles", func() {
+		input.Variables["text"] = "some  product\n\tfor  everyone"
+
+		interpolatedYAML, err := interpolator.Interpolate(input, []byte(`title: $( .text | title | toJson )`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interpolatedYAML).To(HelpfullyMatchYAML(`title: "Some  Product\n\tFor  Everyone"`))
+	})
+
+	It("ranges over variables", func() {
+		interpolatedYAML, err := interpolator.Interpolate(input, []byte(`---
+description: |
+  Availability zones:
+  $( range .azs )- $( . )
+  $( end -)
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+description: |
+  Availability zones:
+  - z1
+  - z2
+`))
+	})
+
+	It("indents multi-line values", func() {
+		interpolatedYAML, err := interpolator.Interpolate(input, []byte(`---
+manifest: |
+  network:$( .network | toYaml | nindent 4 )
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(interpolatedYAML).To(HelpfullyMatchYAML(`
+manifest: |
+  network:
+      name: some-network
+`))
+	})
+
+	Context("failure cases", func() {
+		Context("when a required value is missing", func() {
+			It("returns the message", func() {
+				_, err := interpolator.Interpolate(input, []byte(`name: $( required "the product name must be set" .missing )`))
+				Expect(err).To(MatchError("1:7: template execution failed: the product name must be set"))
+			})
+		})
+
+		Context("when dividing by zero", func() {
+			It("returns an error", func() {
+				_, err := interpolator.Interpolate(input, []byte(`instances: $( div .instances 0 )`))
+				Expect(err).To(MatchError(ContainSubstring("division by zero")))
+			})
+		})
+
+		Context("when a value is not a number", func() {
+			It("returns an error", func() {
+				_, err := interpolator.Interpolate(input, []byte(`instances: $( add .name 1 )`))
+				Expect(err).To(MatchError(ContainSubstring(`could not convert "some-product" to a number`)))
+			})
+		})
+
+		Context("when dict is given an odd number of arguments", func() {
+			It("returns an error", func() {
+				_, err := interpolator.Interpolate(input, []byte(`dict: $( dict "key" )`))
+				Expect(err).To(MatchError(ContainSubstring("dict requires an even number of arguments")))
+			})
+		})
+	})
+})
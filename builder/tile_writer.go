@@ -1,13 +1,15 @@
 package builder
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"gopkg.in/yaml.v2"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -51,6 +53,20 @@ type WriteInput struct {
 	MigrationDirectories []string
 	ReleaseDirectories   []string
 	EmbedPaths           []string
+
+	// Output receives the tile instead of OutputFile when it is set, for
+	// example to stream it to standard output. OutputFile is then only used in
+	// log messages and nothing is removed when writing fails.
+	Output io.Writer
+
+	// Tee also receives every byte of the tile, for example to checksum it
+	// while it is written.
+	Tee io.Writer
+
+	// ReferencedReleasesOnly leaves out release tarballs that the releases
+	// section of the generated metadata does not list by file. Metadata
+	// without a releases section keeps every tarball.
+	ReferencedReleasesOnly bool
 }
 
 type tileMetadata struct {
@@ -58,60 +74,85 @@ type tileMetadata struct {
 }
 
 type release struct {
-	File string `yaml:"file"`
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+	File    string `yaml:"file"`
 }
 
 func (w TileWriter) Write(generatedMetadataContents []byte, input WriteInput) error {
 	w.logger.Printf("Building %s...", input.OutputFile)
 
-	f, err := w.filesystem.Create(input.OutputFile)
-	if err != nil {
-		return err
+	output := input.Output
+	if output == nil {
+		f, err := w.filesystem.Create(input.OutputFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		output = f
+	}
+
+	if input.Tee != nil {
+		output = io.MultiWriter(output, input.Tee)
 	}
-	defer f.Close()
 
-	w.zipper.SetWriter(f)
+	w.zipper.SetWriter(output)
 
-	err = w.addToZipper(filepath.Join("metadata", "metadata.yml"), bytes.NewBuffer(generatedMetadataContents), input.OutputFile)
+	err := w.addToZipper(filepath.Join("metadata", "metadata.yml"), bytes.NewBuffer(generatedMetadataContents), input.OutputFile)
 	if err != nil {
-		w.removeOutputFile(input.OutputFile)
+		w.removeOutputFile(input)
 		return err
 	}
 
 	err = w.addMigrations(input.MigrationDirectories, input.OutputFile)
 	if err != nil {
-		w.removeOutputFile(input.OutputFile)
+		w.removeOutputFile(input)
 		return err
 	}
 
 	if input.StubReleases {
 		err = w.addStubReleases(generatedMetadataContents, input.OutputFile)
 	} else {
-		err = w.addReleases(input.ReleaseDirectories, input.OutputFile)
+		err = w.addReleases(generatedMetadataContents, input)
 	}
 	if err != nil {
-		w.removeOutputFile(input.OutputFile)
+		w.removeOutputFile(input)
 		return err
 	}
 
 	err = w.addEmbeddedPaths(input.EmbedPaths, input.OutputFile)
 	if err != nil {
-		w.removeOutputFile(input.OutputFile)
+		w.removeOutputFile(input)
 		return err
 	}
 
 	err = w.zipper.Close()
 	if err != nil {
-		w.removeOutputFile(input.OutputFile)
+		w.removeOutputFile(input)
 		return err
 	}
 
 	return nil
 }
 
-func (w TileWriter) addReleases(releasesDirs []string, outputFile string) error {
-	for _, releasesDirectory := range releasesDirs {
-		err := w.addReleaseTarballs(releasesDirectory, outputFile)
+// tileEntry is a file on disk and where it goes in the tile. Entries are
+// sorted by path before they are added so the tile does not depend on the
+// order the filesystem returns files in.
+type tileEntry struct {
+	path     string
+	filePath string
+	mode     os.FileMode
+	withMode bool
+}
+
+func (w TileWriter) addEntries(entries []tileEntry, outputFile string) error {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+
+	for _, entry := range entries {
+		err := w.addEntry(entry, outputFile)
 		if err != nil {
 			return err
 		}
@@ -120,89 +161,180 @@ func (w TileWriter) addReleases(releasesDirs []string, outputFile string) error
 	return nil
 }
 
-func (w TileWriter) addStubReleases(generatedMetadataContents []byte, outputFile string) error {
-	var metadata tileMetadata
-	err := yaml.Unmarshal(generatedMetadataContents, &metadata)
+func (w TileWriter) addEntry(entry tileEntry, outputFile string) error {
+	file, err := w.filesystem.Open(entry.filePath)
 	if err != nil {
 		return err
 	}
-	for _, release := range metadata.Releases {
-		path := filepath.Join("releases", release.File)
-		contents := ioutil.NopCloser(strings.NewReader(""))
-		err = w.addToZipper(path, contents, outputFile)
-		if err != nil {
-			return err
-		}
+	defer file.Close()
+
+	if entry.withMode {
+		return w.addToZipperWithMode(entry.path, file, entry.mode, outputFile)
 	}
-	return nil
+	return w.addToZipper(entry.path, file, outputFile)
 }
 
-func (w TileWriter) addReleaseTarballs(releasesDir string, outputFile string) error {
-	return w.filesystem.Walk(releasesDir, func(filePath string, info os.FileInfo, err error) error {
-		isTarball, _ := regexp.MatchString("tgz$|tar.gz$", filePath)
-		if !isTarball {
-			return nil
-		}
-
+func (w TileWriter) addReleases(generatedMetadataContents []byte, input WriteInput) error {
+	var referenced map[string]bool
+	if input.ReferencedReleasesOnly {
+		var metadata tileMetadata
+		err := yaml.Unmarshal(generatedMetadataContents, &metadata)
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
-			return nil
+		if metadata.Releases != nil {
+			referenced = map[string]bool{}
+			for _, release := range metadata.Releases {
+				referenced[release.File] = true
+			}
 		}
+	}
 
-		file := ioutil.NopCloser(strings.NewReader(""))
-		file, err = w.filesystem.Open(filePath)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+	var entries []tileEntry
+	for _, releasesDirectory := range input.ReleaseDirectories {
+		err := w.filesystem.Walk(releasesDirectory, func(filePath string, info os.FileInfo, err error) error {
+			isTarball, _ := regexp.MatchString("tgz$|tar.gz$", filePath)
+			if !isTarball {
+				return nil
+			}
 
-		return w.addToZipper(filepath.Join("releases", filepath.Base(filePath)), file, outputFile)
-	})
-}
+			if err != nil {
+				return err
+			}
 
-func (w TileWriter) addEmbeddedPaths(embedPaths []string, outputFile string) error {
-	for _, embedPath := range embedPaths {
-		err := w.addEmbeddedPath(embedPath, outputFile)
+			if info.IsDir() {
+				return nil
+			}
+
+			if referenced != nil && !referenced[filepath.Base(filePath)] {
+				w.logger.Printf("Skipping %s, it is not referenced in the metadata...", filePath)
+				return nil
+			}
+
+			entries = append(entries, tileEntry{
+				path:     filepath.Join("releases", filepath.Base(filePath)),
+				filePath: filePath,
+			})
+			return nil
+		})
 		if err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return w.addEntries(entries, input.OutputFile)
 }
 
-func (w TileWriter) addEmbeddedPath(pathToEmbed, outputFile string) error {
-	return w.filesystem.Walk(pathToEmbed, func(filePath string, info os.FileInfo, err error) error {
+func (w TileWriter) addStubReleases(generatedMetadataContents []byte, outputFile string) error {
+	var metadata tileMetadata
+	err := yaml.Unmarshal(generatedMetadataContents, &metadata)
+	if err != nil {
+		return err
+	}
+
+	releases := metadata.Releases
+	sort.SliceStable(releases, func(i, j int) bool {
+		return releases[i].File < releases[j].File
+	})
+
+	for _, release := range releases {
+		contents, err := stubReleaseTarball(release)
 		if err != nil {
 			return err
 		}
 
-		if info.IsDir() {
-			return nil
-		}
-
-		file, err := w.filesystem.Open(filePath)
+		err = w.addToZipper(filepath.Join("releases", release.File), contents, outputFile)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
+	}
+	return nil
+}
+
+// stubReleaseTarball is the smallest valid release tarball: a release.MF with
+// the release's name and version and no jobs or packages.
+func stubReleaseTarball(release release) (io.Reader, error) {
+	manifest, err := yaml.Marshal(map[string]string{
+		"name":    release.Name,
+		"version": release.Version,
+	})
+	if err != nil {
+		return nil, err // should never happen
+	}
+
+	modified, err := entryModified()
+	if err != nil {
+		return nil, err
+	}
+
+	var tarball bytes.Buffer
+	gzipWriter := gzip.NewWriter(&tarball)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name:    "release.MF",
+		Mode:    0644,
+		Size:    int64(len(manifest)),
+		ModTime: modified,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tarWriter.Write(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tarWriter.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	err = gzipWriter.Close()
+	if err != nil {
+		return nil, err
+	}
 
-		relativePath, err := filepath.Rel(pathToEmbed, filePath)
+	return &tarball, nil
+}
+
+func (w TileWriter) addEmbeddedPaths(embedPaths []string, outputFile string) error {
+	var entries []tileEntry
+	for _, pathToEmbed := range embedPaths {
+		err := w.filesystem.Walk(pathToEmbed, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			relativePath, err := filepath.Rel(pathToEmbed, filePath)
+			if err != nil {
+				return err //not tested
+			}
+
+			entries = append(entries, tileEntry{
+				path:     filepath.Join("embed", filepath.Join(filepath.Base(pathToEmbed), relativePath)),
+				filePath: filePath,
+				mode:     info.Mode(),
+				withMode: true,
+			})
+			return nil
+		})
 		if err != nil {
-			return err //not tested
+			return err
 		}
+	}
 
-		entryPath := filepath.Join("embed", filepath.Join(filepath.Base(pathToEmbed), relativePath))
-		return w.addToZipperWithMode(entryPath, file, info.Mode(), outputFile)
-	})
+	return w.addEntries(entries, outputFile)
 }
 
 func (w TileWriter) addMigrations(migrationsDir []string, outputFile string) error {
-	var found bool
-
+	var entries []tileEntry
 	for _, migrationDir := range migrationsDir {
 		err := w.filesystem.Walk(migrationDir, func(filePath string, info os.FileInfo, err error) error {
 			isNodeFile, _ := regexp.MatchString(`node_modules\/`, filePath)
@@ -221,15 +353,11 @@ func (w TileWriter) addMigrations(migrationsDir []string, outputFile string) err
 				return nil
 			}
 
-			found = true
-
-			file, err := w.filesystem.Open(filePath)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			return w.addToZipper(filepath.Join("migrations", "v1", filepath.Base(filePath)), file, outputFile)
+			entries = append(entries, tileEntry{
+				path:     filepath.Join("migrations", "v1", filepath.Base(filePath)),
+				filePath: filePath,
+			})
+			return nil
 		})
 
 		if err != nil {
@@ -237,11 +365,11 @@ func (w TileWriter) addMigrations(migrationsDir []string, outputFile string) err
 		}
 	}
 
-	if !found {
+	if len(entries) == 0 {
 		return w.addEmptyMigrationsDirectory(outputFile)
 	}
 
-	return nil
+	return w.addEntries(entries, outputFile)
 }
 
 func (w TileWriter) addToZipper(path string, contents io.Reader, outputFile string) error {
@@ -275,9 +403,13 @@ func (w TileWriter) addEmptyMigrationsDirectory(outputFile string) error {
 	return nil
 }
 
-func (w TileWriter) removeOutputFile(path string) {
-	err := w.filesystem.Remove(path)
+func (w TileWriter) removeOutputFile(input WriteInput) {
+	if input.Output != nil {
+		return
+	}
+
+	err := w.filesystem.Remove(input.OutputFile)
 	if err != nil {
-		w.logger.Printf("failed cleaning up zip %q: %s", path, err.Error())
+		w.logger.Printf("failed cleaning up zip %q: %s", input.OutputFile, err.Error())
 	}
 }
@@ -1,10 +1,13 @@
 package builder_test
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -220,11 +223,12 @@ releases:
 					StubReleases:       true,
 				}
 
-				err := tileWriter.Write([]byte("releases:\n- file: release-1.tgz"), input)
+				err := tileWriter.Write([]byte("releases:\n- name: release-1\n  version: 1.2.3\n  file: release-1.tgz"), input)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(zipper.AddCallCount()).To(Equal(2))
-				path, _ := zipper.AddArgsForCall(1)
+				path, file := zipper.AddArgsForCall(1)
 				Expect(path).To(Equal(filepath.Join("releases", "release-1.tgz")))
+				Expect(readStubReleaseManifest(file)).To(MatchYAML("name: release-1\nversion: 1.2.3\n"))
 			})
 		})
 
@@ -317,6 +321,123 @@ releases:
 			})
 		})
 
+		Context("when the filesystem returns files out of order", func() {
+			BeforeEach(func() {
+				dirInfo := &fakes.FileInfo{}
+				dirInfo.IsDirReturns(true)
+
+				fileInfo := &fakes.FileInfo{}
+				fileInfo.IsDirReturns(false)
+
+				filesystem.WalkStub = func(root string, walkFn filepath.WalkFunc) error {
+					switch root {
+					case "/some/path/releases":
+						walkFn(root, dirInfo, nil)
+						walkFn(filepath.Join(root, "release-b.tgz"), fileInfo, nil)
+					case "/some/other/path/releases":
+						walkFn(root, dirInfo, nil)
+						walkFn(filepath.Join(root, "release-c.tgz"), fileInfo, nil)
+						walkFn(filepath.Join(root, "release-a.tgz"), fileInfo, nil)
+					case "/some/path/migrations":
+						walkFn(root, dirInfo, nil)
+						walkFn(filepath.Join(root, "migration-2.js"), fileInfo, nil)
+						walkFn(filepath.Join(root, "migration-1.js"), fileInfo, nil)
+					}
+					return nil
+				}
+
+				filesystem.OpenStub = func(path string) (io.ReadCloser, error) {
+					return NewBuffer(bytes.NewBufferString(path)), nil
+				}
+			})
+
+			It("adds the files sorted by their path in the tile", func() {
+				input := builder.WriteInput{
+					ReleaseDirectories:   []string{"/some/path/releases", "/some/other/path/releases"},
+					MigrationDirectories: []string{"/some/path/migrations"},
+					OutputFile:           outputFile,
+				}
+
+				err := tileWriter.Write([]byte("generated-metadata-contents"), input)
+				Expect(err).NotTo(HaveOccurred())
+
+				var paths []string
+				for i := 0; i < zipper.AddCallCount(); i++ {
+					path, _ := zipper.AddArgsForCall(i)
+					paths = append(paths, path)
+				}
+				Expect(paths).To(Equal([]string{
+					filepath.Join("metadata", "metadata.yml"),
+					filepath.Join("migrations", "v1", "migration-1.js"),
+					filepath.Join("migrations", "v1", "migration-2.js"),
+					filepath.Join("releases", "release-a.tgz"),
+					filepath.Join("releases", "release-b.tgz"),
+					filepath.Join("releases", "release-c.tgz"),
+				}))
+			})
+		})
+
+		Context("when only referenced releases are included", func() {
+			var input builder.WriteInput
+
+			BeforeEach(func() {
+				dirInfo := &fakes.FileInfo{}
+				dirInfo.IsDirReturns(true)
+
+				fileInfo := &fakes.FileInfo{}
+				fileInfo.IsDirReturns(false)
+
+				filesystem.WalkStub = func(root string, walkFn filepath.WalkFunc) error {
+					walkFn(root, dirInfo, nil)
+					walkFn(filepath.Join(root, "release-a.tgz"), fileInfo, nil)
+					walkFn(filepath.Join(root, "release-b.tgz"), fileInfo, nil)
+					return nil
+				}
+
+				filesystem.OpenStub = func(path string) (io.ReadCloser, error) {
+					return NewBuffer(bytes.NewBufferString(path)), nil
+				}
+
+				input = builder.WriteInput{
+					ReleaseDirectories:     []string{"/some/path/releases"},
+					OutputFile:             outputFile,
+					ReferencedReleasesOnly: true,
+				}
+			})
+
+			It("leaves out the tarballs the metadata does not list", func() {
+				err := tileWriter.Write([]byte("releases:\n- file: release-b.tgz\n"), input)
+				Expect(err).NotTo(HaveOccurred())
+
+				var paths []string
+				for i := 0; i < zipper.AddCallCount(); i++ {
+					path, _ := zipper.AddArgsForCall(i)
+					paths = append(paths, path)
+				}
+				Expect(paths).To(Equal([]string{
+					filepath.Join("metadata", "metadata.yml"),
+					filepath.Join("releases", "release-b.tgz"),
+				}))
+				Expect(logger.PrintfCall.Receives.LogLines).To(ContainElement("Skipping /some/path/releases/release-a.tgz, it is not referenced in the metadata..."))
+			})
+
+			Context("when the metadata has no releases section", func() {
+				It("includes every tarball", func() {
+					err := tileWriter.Write([]byte("name: some-product\n"), input)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(zipper.AddCallCount()).To(Equal(3))
+				})
+			})
+
+			Context("when the generated metadata is invalid", func() {
+				It("returns the error", func() {
+					err := tileWriter.Write([]byte("generated-metadata"), input)
+					Expect(err).To(MatchError(ContainSubstring("cannot unmarshal")))
+				})
+			})
+		})
+
 		Context("when a file to embed is provided", func() {
 			BeforeEach(func() {
 				dirInfo := &fakes.FileInfo{}
@@ -448,6 +569,55 @@ releases:
 			})
 		})
 
+		Context("when an output writer is given", func() {
+			It("writes the tile to it instead of creating the output file", func() {
+				output := gbytes.NewBuffer()
+				input := builder.WriteInput{
+					OutputFile: "-",
+					Output:     output,
+				}
+
+				err := tileWriter.Write([]byte("generated-metadata-contents"), input)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(filesystem.CreateCallCount()).To(Equal(0))
+				Expect(zipper.SetWriterArgsForCall(0)).To(BeIdenticalTo(output))
+				Expect(logger.PrintfCall.Receives.LogLines).To(ContainElement("Building -..."))
+			})
+
+			It("does not remove anything when writing fails", func() {
+				zipper.CloseReturns(errors.New("failed to close the zip"))
+
+				err := tileWriter.Write([]byte("generated-metadata-contents"), builder.WriteInput{
+					OutputFile: "-",
+					Output:     gbytes.NewBuffer(),
+				})
+				Expect(err).To(MatchError("failed to close the zip"))
+
+				Expect(filesystem.RemoveCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when a tee writer is given", func() {
+			It("copies every byte of the tile to it", func() {
+				output := gbytes.NewBuffer()
+				tee := gbytes.NewBuffer()
+
+				err := tileWriter.Write([]byte("generated-metadata-contents"), builder.WriteInput{
+					OutputFile: "-",
+					Output:     output,
+					Tee:        tee,
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = zipper.SetWriterArgsForCall(0).Write([]byte("some-zip-bytes"))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(output.Contents()).To(Equal([]byte("some-zip-bytes")))
+				Expect(tee.Contents()).To(Equal([]byte("some-zip-bytes")))
+			})
+		})
+
 		Context("failure cases", func() {
 			Context("when creating the zip file fails", func() {
 				BeforeEach(func() {
@@ -810,6 +980,24 @@ func checkReleaseFileContent(releaseContent string, stubbed bool, file io.Reader
 	if stubbed == false {
 		Eventually(gbytes.BufferReader(file)).Should(gbytes.Say(releaseContent))
 	} else {
-		Eventually(gbytes.BufferReader(file)).Should(gbytes.Say(""))
+		Expect(readStubReleaseManifest(file)).To(ContainSubstring("name:"))
 	}
 }
+
+func readStubReleaseManifest(file io.Reader) string {
+	gzipReader, err := gzip.NewReader(file)
+	Expect(err).NotTo(HaveOccurred())
+
+	tarReader := tar.NewReader(gzipReader)
+	header, err := tarReader.Next()
+	Expect(err).NotTo(HaveOccurred())
+	Expect(header.Name).To(Equal("release.MF"))
+
+	manifest, err := ioutil.ReadAll(tarReader)
+	Expect(err).NotTo(HaveOccurred())
+
+	_, err = tarReader.Next()
+	Expect(err).To(Equal(io.EOF))
+
+	return string(manifest)
+}
@@ -0,0 +1,265 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// whenEvaluator evaluates the when conditions of parts. A condition compares
+// template pipelines and literals with == and !=, and combines them with &&,
+// || and !, for example:
+//
+//	(variable "edition") == "enterprise" && !.disable_metrics
+//
+// Pipelines run with the same helpers and data as the metadata, and what they
+// print is read as YAML, so `.enabled` is a bool and `variable "edition"` is
+// the string the variable holds.
+type whenEvaluator struct {
+	funcs template.FuncMap
+	data  interface{}
+
+	condition string
+	position  int
+}
+
+func (e *whenEvaluator) evaluate(condition string) (bool, error) {
+	e.condition, e.position = condition, 0
+
+	value, err := e.parseOr()
+	if err != nil {
+		return false, err
+	}
+
+	e.skipSpace()
+	if e.position < len(e.condition) {
+		return false, fmt.Errorf("unexpected %q at column %d", e.condition[e.position:], e.position+1)
+	}
+
+	return !isEmpty(value), nil
+}
+
+func (e *whenEvaluator) parseOr() (interface{}, error) {
+	left, err := e.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for e.consume("||") {
+		right, err := e.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = !isEmpty(left) || !isEmpty(right)
+	}
+
+	return left, nil
+}
+
+func (e *whenEvaluator) parseAnd() (interface{}, error) {
+	left, err := e.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for e.consume("&&") {
+		right, err := e.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = !isEmpty(left) && !isEmpty(right)
+	}
+
+	return left, nil
+}
+
+func (e *whenEvaluator) parseNot() (interface{}, error) {
+	e.skipSpace()
+	if strings.HasPrefix(e.condition[e.position:], "!") && !strings.HasPrefix(e.condition[e.position:], "!=") {
+		e.position++
+		value, err := e.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return isEmpty(value), nil
+	}
+
+	return e.parseComparison()
+}
+
+func (e *whenEvaluator) parseComparison() (interface{}, error) {
+	left, err := e.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case e.consume("=="):
+		right, err := e.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return whenEqual(left, right), nil
+	case e.consume("!="):
+		right, err := e.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return !whenEqual(left, right), nil
+	}
+
+	return left, nil
+}
+
+func (e *whenEvaluator) parseOperand() (interface{}, error) {
+	e.skipSpace()
+	if e.position >= len(e.condition) {
+		return nil, fmt.Errorf("unexpected end of condition")
+	}
+
+	if e.condition[e.position] == '(' {
+		e.position++
+		value, err := e.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !e.consume(")") {
+			return nil, fmt.Errorf("missing ) at column %d", e.position+1)
+		}
+		return value, nil
+	}
+
+	// Parentheses inside an operand group a template pipeline, as in
+	// len (variable "zones"), so only a ) that closes nothing ends it.
+	start, depth := e.position, 0
+	for e.position < len(e.condition) {
+		rest := e.condition[e.position:]
+		if rest[0] == '"' || rest[0] == '`' {
+			end, err := e.quotedEnd(rest)
+			if err != nil {
+				return nil, err
+			}
+			e.position += end
+			continue
+		}
+		if depth == 0 && (rest[0] == ')' || strings.HasPrefix(rest, "==") || strings.HasPrefix(rest, "!=") || strings.HasPrefix(rest, "&&") || strings.HasPrefix(rest, "||")) {
+			break
+		}
+		switch rest[0] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		e.position++
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("missing ) at column %d", e.position+1)
+	}
+
+	operand := strings.TrimSpace(e.condition[start:e.position])
+	if operand == "" {
+		return nil, fmt.Errorf("missing value at column %d", start+1)
+	}
+
+	return e.evaluateOperand(operand)
+}
+
+// quotedEnd returns the length of the quoted string at the start of s.
+func (e *whenEvaluator) quotedEnd(s string) (int, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quote == '"':
+			i++
+		case s[i] == quote:
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string at column %d", e.position+1)
+}
+
+func (e *whenEvaluator) evaluateOperand(operand string) (interface{}, error) {
+	if unquoted, err := strconv.Unquote(operand); err == nil {
+		return unquoted, nil
+	}
+
+	switch operand {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+
+	if number, err := strconv.ParseFloat(operand, 64); err == nil {
+		return number, nil
+	}
+
+	t, err := template.New("when").Funcs(e.funcs).Option("missingkey=zero").Parse("{{ " + operand + " }}")
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %s", operand, templateErrorPrefix.ReplaceAllString(err.Error(), ""))
+	}
+
+	var buffer bytes.Buffer
+	err = t.Execute(&buffer, e.data)
+	if err != nil {
+		return nil, fmt.Errorf("could not evaluate %q: %s", operand, templateErrorPrefix.ReplaceAllString(rootCause(err).Error(), ""))
+	}
+
+	if buffer.String() == "<no value>" {
+		return nil, nil
+	}
+
+	var value interface{}
+	err = yaml.Unmarshal(buffer.Bytes(), &value)
+	if err != nil {
+		return buffer.String(), nil
+	}
+
+	return value, nil
+}
+
+func (e *whenEvaluator) consume(token string) bool {
+	e.skipSpace()
+	if strings.HasPrefix(e.condition[e.position:], token) {
+		e.position += len(token)
+		return true
+	}
+	return false
+}
+
+func (e *whenEvaluator) skipSpace() {
+	for e.position < len(e.condition) && strings.ContainsRune(" \t\n", rune(e.condition[e.position])) {
+		e.position++
+	}
+}
+
+// whenEqual compares numbers by value, so 3 from a variable equals 3.0 from
+// the condition, and everything else by deep equality.
+func whenEqual(a, b interface{}) bool {
+	if x, ok := whenNumber(a); ok {
+		if y, ok := whenNumber(b); ok {
+			return x == y
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func whenNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -7,9 +7,15 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
+// reproducibleModified is the modification time of every entry unless
+// SOURCE_DATE_EPOCH is set. It is the earliest time a zip file can record,
+// so baking the same inputs twice produces the same bytes.
+var reproducibleModified = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 type Zipper struct {
 	writer *zip.Writer
 }
@@ -27,10 +33,15 @@ func (z Zipper) Add(path string, file io.Reader) error {
 		return errors.New("zipper path must be set")
 	}
 
+	modified, err := entryModified()
+	if err != nil {
+		return err
+	}
+
 	return z.add(&zip.FileHeader{
 		Name:     path,
 		Method:   zip.Store,
-		Modified: time.Now(),
+		Modified: modified,
 	}, file)
 }
 
@@ -39,12 +50,17 @@ func (z Zipper) AddWithMode(path string, file io.Reader, mode os.FileMode) error
 		return errors.New("zipper path must be set")
 	}
 
+	modified, err := entryModified()
+	if err != nil {
+		return err
+	}
+
 	fh := &zip.FileHeader{
 		Name:     path,
 		Method:   zip.Store,
-		Modified: time.Now(),
+		Modified: modified,
 	}
-	fh.SetMode(mode)
+	fh.SetMode(normalizedMode(mode))
 
 	return z.add(fh, file)
 }
@@ -74,14 +90,45 @@ func (z Zipper) CreateFolder(path string) error {
 
 	path = fmt.Sprintf("%s%c", filepath.Clean(path), filepath.Separator)
 
+	modified, err := entryModified()
+	if err != nil {
+		return err
+	}
+
 	fh := &zip.FileHeader{
 		Name:     path,
-		Modified: time.Now(),
+		Modified: modified,
 	}
-	_, err := z.writer.CreateHeader(fh)
+	_, err = z.writer.CreateHeader(fh)
 	if err != nil {
 		return err
 	}
 
 	return err
 }
+
+// entryModified returns the time SOURCE_DATE_EPOCH names, see
+// https://reproducible-builds.org/specs/source-date-epoch/, or a fixed time
+// when it is not set.
+func entryModified() (time.Time, error) {
+	epoch, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !ok || epoch == "" {
+		return reproducibleModified, nil
+	}
+
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH must be a number of seconds, got %q", epoch)
+	}
+
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// normalizedMode keeps only whether a file is executable, so the umask and
+// checkout of the machine baking the tile do not change its bytes.
+func normalizedMode(mode os.FileMode) os.FileMode {
+	if mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
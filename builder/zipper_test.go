@@ -55,7 +55,7 @@ var _ = Describe("Zipper", func() {
 			Expect(reader.File).To(HaveLen(1))
 			Expect(reader.File[0].Name).To(Equal("some/path/to/folder/"))
 			Expect(reader.File[0].Mode().IsDir()).To(BeTrue())
-			Expect(reader.File[0].FileHeader.Modified).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(reader.File[0].FileHeader.Modified).To(BeTemporally("==", time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)))
 		})
 
 		It("does not append separator if already given to the input", func() {
@@ -113,7 +113,7 @@ var _ = Describe("Zipper", func() {
 
 			Expect(contents).To(Equal([]byte("file contents")))
 			Expect(reader.File[0].FileHeader.Mode()).To(Equal(os.FileMode(0666)))
-			Expect(reader.File[0].FileHeader.Modified).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(reader.File[0].FileHeader.Modified).To(BeTemporally("==", time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)))
 		})
 
 		Context("failure cases", func() {
@@ -141,6 +141,41 @@ var _ = Describe("Zipper", func() {
 		})
 	})
 
+	Context("when SOURCE_DATE_EPOCH is set", func() {
+		AfterEach(func() {
+			Expect(os.Unsetenv("SOURCE_DATE_EPOCH")).To(Succeed())
+		})
+
+		It("uses it as the modification time of every entry", func() {
+			Expect(os.Setenv("SOURCE_DATE_EPOCH", "1546300800")).To(Succeed())
+
+			zipper := builder.NewZipper()
+			zipper.SetWriter(tileFile)
+
+			Expect(zipper.CreateFolder("some/path/to/folder")).To(Succeed())
+			Expect(zipper.Add("some/path/to/file.txt", strings.NewReader("file contents"))).To(Succeed())
+			Expect(zipper.Close()).To(Succeed())
+
+			reader, err := zip.OpenReader(pathToTile)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(reader.File).To(HaveLen(2))
+			for _, file := range reader.File {
+				Expect(file.FileHeader.Modified).To(BeTemporally("==", time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)))
+			}
+		})
+
+		It("returns an error when it is not a number", func() {
+			Expect(os.Setenv("SOURCE_DATE_EPOCH", "yesterday")).To(Succeed())
+
+			zipper := builder.NewZipper()
+			zipper.SetWriter(tileFile)
+
+			err := zipper.Add("some/path/to/file.txt", strings.NewReader("file contents"))
+			Expect(err).To(MatchError(`SOURCE_DATE_EPOCH must be a number of seconds, got "yesterday"`))
+		})
+	})
+
 	Describe("AddWithMode", func() {
 		It("writes the given file into the path", func() {
 			zipper := builder.NewZipper()
@@ -166,7 +201,23 @@ var _ = Describe("Zipper", func() {
 
 			Expect(contents).To(Equal([]byte("file contents")))
 			Expect(reader.File[0].FileHeader.Mode()).To(Equal(os.FileMode(0644)))
-			Expect(reader.File[0].FileHeader.Modified).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(reader.File[0].FileHeader.Modified).To(BeTemporally("==", time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)))
+		})
+
+		It("only keeps whether the file is executable", func() {
+			zipper := builder.NewZipper()
+			zipper.SetWriter(tileFile)
+
+			Expect(zipper.AddWithMode("some-script", strings.NewReader("#!/bin/sh"), 0700)).To(Succeed())
+			Expect(zipper.AddWithMode("some-file", strings.NewReader("file contents"), 0600)).To(Succeed())
+			Expect(zipper.Close()).To(Succeed())
+
+			reader, err := zip.OpenReader(pathToTile)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(reader.File).To(HaveLen(2))
+			Expect(reader.File[0].FileHeader.Mode()).To(Equal(os.FileMode(0755)))
+			Expect(reader.File[1].FileHeader.Mode()).To(Equal(os.FileMode(0644)))
 		})
 
 		Context("failure cases", func() {
@@ -1,12 +1,22 @@
 package commands
 
 import (
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/pivotal-cf/jhanda"
 	"github.com/pivotal-cf/kiln/builder"
+	"github.com/pivotal-cf/kiln/internal/baking"
+	"github.com/pivotal-cf/kiln/internal/cargo"
+	"gopkg.in/src-d/go-billy.v4/osfs"
 )
 
 //go:generate counterfeiter -o ./fakes/interpolator.go --fake-name Interpolator . interpolator
@@ -19,6 +29,11 @@ type tileWriter interface {
 	Write(generatedMetadataContents []byte, input builder.WriteInput) error
 }
 
+//go:generate counterfeiter -o ./fakes/variables_schema_service.go --fake-name VariablesSchemaService . variablesSchemaService
+type variablesSchemaService interface {
+	FromPath(path string) (schema baking.VariablesSchema, err error)
+}
+
 //go:generate counterfeiter -o ./fakes/bosh_variables_service.go --fake-name BOSHVariablesService . boshVariablesService
 type boshVariablesService interface {
 	FromDirectories(directories []string) (boshVariables map[string]interface{}, err error)
@@ -27,6 +42,8 @@ type boshVariablesService interface {
 //go:generate counterfeiter -o ./fakes/releases_service.go --fake-name ReleasesService . releasesService
 type releasesService interface {
 	FromDirectories(directories []string) (releases map[string]interface{}, err error)
+	FromKilnfile(path string) (releases map[string]interface{}, err error)
+	VerifyKilnfileLock(kilnfilePath string, releases map[string]interface{}) error
 }
 
 //go:generate counterfeiter -o ./fakes/stemcell_service.go --fake-name StemcellService . stemcellService
@@ -38,7 +55,7 @@ type stemcellService interface {
 
 //go:generate counterfeiter -o ./fakes/template_variables_service.go --fake-name TemplateVariablesService . templateVariablesService
 type templateVariablesService interface {
-	FromPathsAndPairs(paths []string, pairs []string) (templateVariables map[string]interface{}, err error)
+	Read(paths []string, pairs []string, yamlPairs []string) (templateVariables map[string]interface{}, sources map[string]string, err error)
 }
 
 //go:generate counterfeiter -o ./fakes/forms_service.go --fake-name FormsService . formsService
@@ -66,6 +83,11 @@ type runtimeConfigsService interface {
 	FromDirectories(directories []string) (runtimeConfigs map[string]interface{}, err error)
 }
 
+//go:generate counterfeiter -o ./fakes/snippets_service.go --fake-name SnippetsService . snippetsService
+type snippetsService interface {
+	FromDirectories(directories []string) (snippets map[string]interface{}, err error)
+}
+
 //go:generate counterfeiter -o ./fakes/icon_service.go --fake-name IconService . iconService
 type iconService interface {
 	Encode(path string) (encodedIcon string, err error)
@@ -76,17 +98,36 @@ type metadataService interface {
 	Read(path string) (metadata []byte, err error)
 }
 
+//go:generate counterfeiter -o ./fakes/metadata_patcher.go --fake-name MetadataPatcher . metadataPatcher
+type metadataPatcher interface {
+	Patch(metadata []byte, opsFiles []string) (patchedMetadata []byte, err error)
+}
+
+//go:generate counterfeiter -o ./fakes/secret_scanner.go --fake-name SecretScanner . secretScanner
+type secretScanner interface {
+	Scan(metadata []byte, embedPaths []string, sensitiveValues []builder.SensitiveValue, patterns []string, skipDefaultPatterns bool) error
+}
+
 //go:generate counterfeiter -o ./fakes/checksummer.go --fake-name Checksummer . checksummer
 type checksummer interface {
-	Sum(path string) error
+	Record(path string, checksum []byte) error
 }
 
+const (
+	variablesSchemaFile = "variables-schema.yml"
+
+	tileLayoutMetadata = "base.yml"
+	tileLayoutKilnfile = "Kilnfile"
+	tileLayoutVersion  = "version"
+)
+
 type Bake struct {
 	interpolator      interpolator
 	checksummer       checksummer
 	tileWriter        tileWriter
 	output            *log.Logger
 	templateVariables templateVariablesService
+	variablesSchema   variablesSchemaService
 	boshVariables     boshVariablesService
 	releases          releasesService
 	stemcell          stemcellService
@@ -95,31 +136,47 @@ type Bake struct {
 	jobs              jobsService
 	properties        propertiesService
 	runtimeConfigs    runtimeConfigsService
+	snippets          snippetsService
 	icon              iconService
 	metadata          metadataService
+	patcher           metadataPatcher
+	secretScanner     secretScanner
+	fetch             jhanda.Command
+	stdout            io.Writer
 
 	Options struct {
 		Kilnfile           string   `short:"kf"  long:"kilnfile"                        description:"path to Kilnfile  (NOTE: mutually exclusive with --stemcell-directory)"`
-		Metadata           string   `short:"m"  long:"metadata"           required:"true" description:"path to the metadata file"`
-		OutputFile         string   `short:"o"  long:"output-file"                        description:"path to where the tile will be output"`
+		Metadata           string   `short:"m"  long:"metadata"                         description:"path to the metadata file (required unless set in the Kilnfile bake section or base.yml is in the working directory)"`
+		OutputFile         string   `short:"o"  long:"output-file"                        description:"path to where the tile will be output, or - to stream it to stdout"`
 		ReleaseDirectories []string `short:"rd" long:"releases-directory"               description:"path to a directory containing release tarballs"`
 
 		BOSHVariableDirectories  []string `short:"vd"  long:"bosh-variables-directory"  description:"path to a directory containing BOSH variables"`
 		EmbedPaths               []string `short:"e"   long:"embed"                     description:"path to files to include in the tile /embed directory"`
+		Fetch                    bool     `            long:"fetch"                     description:"downloads the releases in Kilnfile.lock that are missing from the releases directory before baking (requires --kilnfile)"`
 		FormDirectories          []string `short:"f"   long:"forms-directory"           description:"path to a directory containing forms"`
 		IconPath                 string   `short:"i"   long:"icon"                      description:"path to icon file"`
 		InstanceGroupDirectories []string `short:"ig"  long:"instance-groups-directory" description:"path to a directory containing instance groups"`
 		JobDirectories           []string `short:"j"   long:"jobs-directory"            description:"path to a directory containing jobs"`
+		ListVariables            bool     `short:"lv"  long:"list-variables"            description:"don't build a tile, print the variables declared in the variables schema"`
 		MetadataOnly             bool     `short:"mo"  long:"metadata-only"             description:"don't build a tile, output the metadata to stdout"`
 		MigrationDirectories     []string `short:"md"  long:"migrations-directory"      description:"path to a directory containing migrations"`
+		OpsFiles                 []string `short:"of"  long:"ops-file"                  description:"path to a BOSH ops file to apply to the interpolated metadata"`
+		Profile                  string   `short:"p"   long:"profile"                   description:"name of a profile under bake_profiles in the Kilnfile to apply to the bake section"`
 		PropertyDirectories      []string `short:"pd"  long:"properties-directory"      description:"path to a directory containing property blueprints"`
 		RuntimeConfigDirectories []string `short:"rcd" long:"runtime-configs-directory" description:"path to a directory containing runtime configs"`
+		SecretPatterns           []string `short:"spt" long:"secret-pattern"            description:"regular expression for credentials that must not be baked into the tile, in addition to the default patterns"`
+		SecretsDirectory         string   `short:"sc"  long:"secrets-directory"         description:"path to a directory with a file per secret for the secret helper"`
+		SecretsHelper            string   `short:"sh"  long:"secrets-helper"            description:"command of a credential helper for the secret helper"`
 		Sha256                   bool     `            long:"sha256"                    description:"calculates a SHA256 checksum of the output file"`
+		SkipDefaultPatterns      bool     `            long:"skip-default-secret-patterns" description:"don't scan the tile for common credentials such as AWS keys and private keys, only for sensitive values and --secret-pattern"`
+		SnippetDirectories       []string `short:"snd" long:"snippets-directory"        description:"path to a directory containing snippets for the include helper"`
 		StemcellTarball          string   `short:"st"  long:"stemcell-tarball"          description:"deprecated -- path to a stemcell tarball  (NOTE: mutually exclusive with --kilnfile)"`
 		StemcellsDirectories     []string `short:"sd"  long:"stemcells-directory"       description:"path to a directory containing stemcells  (NOTE: mutually exclusive with --kilnfile or --stemcell-tarball)"`
-		StubReleases             bool     `short:"sr"  long:"stub-releases"             description:"skips importing release tarballs into the tile"`
+		StubReleases             bool     `short:"sr"  long:"stub-releases"             description:"skips importing release tarballs into the tile, taking the releases from Kilnfile.lock when --kilnfile is given"`
 		VariableFiles            []string `short:"vf"  long:"variables-file"            description:"path to a file containing variables to interpolate"`
 		Variables                []string `short:"vr"  long:"variable"                  description:"key value pairs of variables to interpolate"`
+		VariablesYAML            []string `short:"vy"  long:"variable-yaml"             description:"key value pairs of variables to interpolate, with the value parsed as YAML"`
+		VariablesSchema          string   `short:"vs"  long:"variables-schema"          description:"path to a file declaring the variables (defaults to variables-schema.yml next to the metadata file)"`
 		Version                  string   `short:"v"   long:"version"                   description:"version of the tile"`
 	}
 }
@@ -129,6 +186,7 @@ func NewBake(
 	tileWriter tileWriter,
 	output *log.Logger,
 	templateVariablesService templateVariablesService,
+	variablesSchemaService variablesSchemaService,
 	boshVariablesService boshVariablesService,
 	releasesService releasesService,
 	stemcellService stemcellService,
@@ -137,9 +195,13 @@ func NewBake(
 	jobsService jobsService,
 	propertiesService propertiesService,
 	runtimeConfigsService runtimeConfigsService,
+	snippetsService snippetsService,
 	iconService iconService,
 	metadataService metadataService,
+	metadataPatcher metadataPatcher,
+	secretScanner secretScanner,
 	checksummer checksummer,
+	fetch jhanda.Command,
 ) Bake {
 
 	return Bake{
@@ -148,6 +210,7 @@ func NewBake(
 		checksummer:       checksummer,
 		output:            output,
 		templateVariables: templateVariablesService,
+		variablesSchema:   variablesSchemaService,
 		boshVariables:     boshVariablesService,
 		releases:          releasesService,
 		stemcell:          stemcellService,
@@ -156,8 +219,13 @@ func NewBake(
 		jobs:              jobsService,
 		properties:        propertiesService,
 		runtimeConfigs:    runtimeConfigsService,
+		snippets:          snippetsService,
 		icon:              iconService,
 		metadata:          metadataService,
+		patcher:           metadataPatcher,
+		secretScanner:     secretScanner,
+		fetch:             fetch,
+		stdout:            os.Stdout,
 	}
 }
 
@@ -167,6 +235,50 @@ func (b Bake) Execute(args []string) error {
 		return err
 	}
 
+	err = b.loadBakeConfig(args)
+	if err != nil {
+		return err
+	}
+
+	if b.Options.OutputFile == "-" {
+		// The tile is streamed to stdout, so everything else goes to stderr.
+		b.output = log.New(os.Stderr, b.output.Prefix(), b.output.Flags())
+	}
+
+	if b.Options.Metadata == "" {
+		err = b.discoverTileLayout(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.Options.Metadata == "" {
+		return errors.New("missing required flag \"--metadata\"")
+	}
+
+	if b.Options.VariablesSchema == "" {
+		path := filepath.Join(filepath.Dir(b.Options.Metadata), variablesSchemaFile)
+		if fileExists(path) {
+			b.Options.VariablesSchema = path
+		}
+	}
+
+	var schema baking.VariablesSchema
+	if b.Options.VariablesSchema != "" {
+		schema, err = b.variablesSchema.FromPath(b.Options.VariablesSchema)
+		if err != nil {
+			return fmt.Errorf("failed to read variables schema: %s", err)
+		}
+	}
+
+	if b.Options.ListVariables {
+		if b.Options.VariablesSchema == "" {
+			return fmt.Errorf("--list-variables requires --variables-schema or a %s next to the metadata file", variablesSchemaFile)
+		}
+		b.output.Printf("%s", schema)
+		return nil
+	}
+
 	if len(b.Options.InstanceGroupDirectories) == 0 && len(b.Options.JobDirectories) > 0 {
 		return errors.New("--jobs-directory flag requires --instance-groups-directory to also be specified")
 	}
@@ -196,11 +308,32 @@ func (b Bake) Execute(args []string) error {
 		b.output.Println("warning: --stemcell-tarball is being deprecated in favor of --stemcells-directory")
 	}
 
+	if b.Options.Fetch {
+		err = b.fetchReleases()
+		if err != nil {
+			return err
+		}
+	}
+
 	releaseManifests, err := b.releases.FromDirectories(b.Options.ReleaseDirectories)
 	if err != nil {
 		return fmt.Errorf("failed to parse releases: %s", err)
 	}
 
+	if b.Options.Kilnfile != "" && b.Options.StubReleases {
+		lockedReleases, err := b.releases.FromKilnfile(b.Options.Kilnfile)
+		if err != nil {
+			return fmt.Errorf("failed to parse releases: %s", err)
+		}
+
+		releaseManifests = stubReleaseManifests(lockedReleases, releaseManifests)
+	} else if b.Options.Kilnfile != "" {
+		err = b.releases.VerifyKilnfileLock(b.Options.Kilnfile, releaseManifests)
+		if err != nil {
+			return fmt.Errorf("failed to verify releases: %s", err)
+		}
+	}
+
 	var stemcellManifests map[string]interface{}
 	var stemcellManifest interface{}
 	if b.Options.StemcellTarball != "" {
@@ -215,11 +348,18 @@ func (b Bake) Execute(args []string) error {
 		return fmt.Errorf("failed to parse stemcell: %s", err)
 	}
 
-	templateVariables, err := b.templateVariables.FromPathsAndPairs(b.Options.VariableFiles, b.Options.Variables)
+	templateVariables, variableSources, err := b.templateVariables.Read(b.Options.VariableFiles, b.Options.Variables, b.Options.VariablesYAML)
 	if err != nil {
 		return fmt.Errorf("failed to parse template variables: %s", err)
 	}
 
+	if b.Options.VariablesSchema != "" {
+		templateVariables, err = schema.Check(templateVariables, variableSources)
+		if err != nil {
+			return fmt.Errorf("variables do not match the schema in %s:\n%s", b.Options.VariablesSchema, err)
+		}
+	}
+
 	boshVariables, err := b.boshVariables.FromDirectories(b.Options.BOSHVariableDirectories)
 	if err != nil {
 		return fmt.Errorf("failed to parse bosh variables: %s", err)
@@ -250,6 +390,11 @@ func (b Bake) Execute(args []string) error {
 		return fmt.Errorf("failed to parse runtime configs: %s", err)
 	}
 
+	snippets, err := b.snippets.FromDirectories(b.Options.SnippetDirectories)
+	if err != nil {
+		return fmt.Errorf("failed to parse snippets: %s", err)
+	}
+
 	icon, err := b.icon.Encode(b.Options.IconPath)
 	if err != nil {
 		return fmt.Errorf("failed to encode icon: %s", err)
@@ -260,9 +405,13 @@ func (b Bake) Execute(args []string) error {
 		return fmt.Errorf("failed to read metadata: %s", err)
 	}
 
+	secretRecorder := builder.NewSecretRecorder()
+
 	interpolatedMetadata, err := b.interpolator.Interpolate(builder.InterpolateInput{
+		TemplatePath:       b.Options.Metadata,
 		Version:            b.Options.Version,
 		Variables:          templateVariables,
+		VariableSources:    variableSources,
 		BOSHVariables:      boshVariables,
 		ReleaseManifests:   releaseManifests,
 		StemcellManifests:  stemcellManifests,
@@ -273,30 +422,58 @@ func (b Bake) Execute(args []string) error {
 		Jobs:               jobs,
 		PropertyBlueprints: propertyBlueprints,
 		RuntimeConfigs:     runtimeConfigs,
+		Snippets:           snippets,
+		SecretProviders:    secretRecorder.Record(builder.NewSecretProviders(b.Options.SecretsDirectory, b.Options.SecretsHelper)),
 		StubReleases:       b.Options.StubReleases,
 	}, metadata)
 	if err != nil {
 		return err
 	}
 
+	if len(b.Options.OpsFiles) > 0 {
+		interpolatedMetadata, err = b.patcher.Patch(interpolatedMetadata, b.Options.OpsFiles)
+		if err != nil {
+			return fmt.Errorf("failed to apply ops files: %s", err)
+		}
+	}
+
+	sensitiveValues := append(schema.SensitiveValues(templateVariables), secretRecorder.SensitiveValues()...)
+	err = b.secretScanner.Scan(interpolatedMetadata, b.Options.EmbedPaths, sensitiveValues, b.Options.SecretPatterns, b.Options.SkipDefaultPatterns)
+	if err != nil {
+		return fmt.Errorf("refusing to bake a tile that contains secrets:\n%s", err)
+	}
+
 	if b.Options.MetadataOnly {
 		b.output.Printf("%s", interpolatedMetadata)
 		return nil
 	}
 
-	err = b.tileWriter.Write(interpolatedMetadata, builder.WriteInput{
+	writeInput := builder.WriteInput{
 		OutputFile:           b.Options.OutputFile,
 		StubReleases:         b.Options.StubReleases,
 		MigrationDirectories: b.Options.MigrationDirectories,
 		ReleaseDirectories:   b.Options.ReleaseDirectories,
 		EmbedPaths:           b.Options.EmbedPaths,
-	})
+
+		ReferencedReleasesOnly: b.Options.Kilnfile != "",
+	}
+
+	if b.Options.OutputFile == "-" {
+		writeInput.Output = b.stdout
+	}
+
+	checksum := sha256.New()
+	if b.Options.Sha256 {
+		writeInput.Tee = checksum
+	}
+
+	err = b.tileWriter.Write(interpolatedMetadata, writeInput)
 	if err != nil {
 		return err
 	}
 
 	if b.Options.Sha256 {
-		err = b.checksummer.Sum(b.Options.OutputFile)
+		err = b.checksummer.Record(b.Options.OutputFile, checksum.Sum(nil))
 		if err != nil {
 			return fmt.Errorf("failed to calculate checksum: %s", err)
 		}
@@ -305,9 +482,304 @@ func (b Bake) Execute(args []string) error {
 	return nil
 }
 
+// loadBakeConfig fills the options that were not passed as flags from the
+// bake section of the Kilnfile, with the --profile applied. A Kilnfile that
+// does not exist is ignored unless a profile is requested, since --kilnfile
+// may only point at a Kilnfile.lock.
+func (b *Bake) loadBakeConfig(args []string) error {
+	if b.Options.Kilnfile == "" {
+		if b.Options.Profile != "" {
+			return errors.New("--profile requires --kilnfile")
+		}
+		return nil
+	}
+
+	kilnfile, err := cargo.NewKilnfileLoader(osfs.New(""), cargo.NoInterpolation{}).Load(b.Options.Kilnfile, nil)
+	if err != nil {
+		if os.IsNotExist(err) && b.Options.Profile == "" {
+			return nil
+		}
+		return fmt.Errorf("failed to read Kilnfile: %s", err)
+	}
+
+	config, err := kilnfile.BakeConfig(b.Options.Profile)
+	if err != nil {
+		return err
+	}
+
+	for _, option := range []struct {
+		flag   *string
+		config string
+	}{
+		{&b.Options.Metadata, config.Metadata},
+		{&b.Options.OutputFile, config.OutputFile},
+		{&b.Options.Version, config.Version},
+		{&b.Options.IconPath, config.Icon},
+		{&b.Options.VariablesSchema, config.VariablesSchema},
+	} {
+		if *option.flag == "" {
+			*option.flag = option.config
+		}
+	}
+
+	for _, option := range []struct {
+		flags  *[]string
+		config []string
+	}{
+		{&b.Options.ReleaseDirectories, config.ReleasesDirectories},
+		{&b.Options.BOSHVariableDirectories, config.BOSHVariablesDirectories},
+		{&b.Options.FormDirectories, config.FormsDirectories},
+		{&b.Options.InstanceGroupDirectories, config.InstanceGroupsDirectories},
+		{&b.Options.JobDirectories, config.JobsDirectories},
+		{&b.Options.PropertyDirectories, config.PropertiesDirectories},
+		{&b.Options.RuntimeConfigDirectories, config.RuntimeConfigsDirectories},
+		{&b.Options.SnippetDirectories, config.SnippetsDirectories},
+		{&b.Options.MigrationDirectories, config.MigrationsDirectories},
+		{&b.Options.EmbedPaths, config.Embed},
+		{&b.Options.VariableFiles, config.VariablesFiles},
+		{&b.Options.OpsFiles, config.OpsFiles},
+		{&b.Options.SecretPatterns, config.SecretPatterns},
+	} {
+		if len(*option.flags) == 0 {
+			*option.flags = option.config
+		}
+	}
+
+	flagVariables := map[string]bool{}
+	for _, variable := range append(append([]string(nil), b.Options.Variables...), b.Options.VariablesYAML...) {
+		flagVariables[strings.SplitN(variable, "=", 2)[0]] = true
+	}
+	var variables []string
+	for _, name := range sortedKeys(config.Variables) {
+		if !flagVariables[name] {
+			variables = append(variables, name+"="+config.Variables[name])
+		}
+	}
+	b.Options.Variables = append(variables, b.Options.Variables...)
+
+	for _, option := range []struct {
+		flag   *bool
+		names  []string
+		config *bool
+	}{
+		{&b.Options.StubReleases, []string{"stub-releases", "sr"}, config.StubReleases},
+		{&b.Options.Sha256, []string{"sha256"}, config.Sha256},
+		{&b.Options.SkipDefaultPatterns, []string{"skip-default-secret-patterns"}, config.SkipDefaultSecretPatterns},
+	} {
+		if option.config != nil && !flagPassed(args, option.names) {
+			*option.flag = *option.config
+		}
+	}
+
+	return nil
+}
+
+// flagPassed reports whether one of the names of a flag is in args, so that
+// --sha256=false can turn off what the Kilnfile turns on.
+func flagPassed(args, names []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		for _, flagName := range names {
+			if name == flagName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discoverTileLayout fills the options that were not passed as flags from a
+// working directory laid out like example-tile, so that `kiln bake` needs no
+// flags there. It does nothing unless the directory has a base.yml.
+func (b *Bake) discoverTileLayout(args []string) error {
+	if !fileExists(tileLayoutMetadata) {
+		return nil
+	}
+
+	var discovered []string
+	if b.Options.Kilnfile == "" && b.Options.StemcellTarball == "" && len(b.Options.StemcellsDirectories) == 0 && fileExists(tileLayoutKilnfile+".lock") {
+		b.Options.Kilnfile = tileLayoutKilnfile
+		discovered = append(discovered, tileLayoutKilnfile+".lock")
+
+		err := b.loadBakeConfig(args)
+		if err != nil {
+			return err
+		}
+	}
+
+	discover := func(flag *string, path string) {
+		if *flag == "" && fileExists(path) {
+			*flag = path
+			discovered = append(discovered, path)
+		}
+	}
+	discoverDirectory := func(flags *[]string, path string) {
+		if len(*flags) == 0 && fileExists(path) {
+			*flags = []string{path}
+			discovered = append(discovered, path)
+		}
+	}
+
+	discover(&b.Options.Metadata, tileLayoutMetadata)
+	discover(&b.Options.IconPath, "icon.png")
+	discoverDirectory(&b.Options.ReleaseDirectories, "releases")
+	discoverDirectory(&b.Options.BOSHVariableDirectories, "bosh-variables")
+	discoverDirectory(&b.Options.FormDirectories, "forms")
+	discoverDirectory(&b.Options.InstanceGroupDirectories, "instance-groups")
+	discoverDirectory(&b.Options.JobDirectories, "jobs")
+	discoverDirectory(&b.Options.PropertyDirectories, "properties")
+	discoverDirectory(&b.Options.RuntimeConfigDirectories, "runtime-configs")
+	discoverDirectory(&b.Options.SnippetDirectories, "snippets")
+	discoverDirectory(&b.Options.MigrationDirectories, "migrations")
+	if b.Options.Kilnfile == "" && b.Options.StemcellTarball == "" {
+		discoverDirectory(&b.Options.StemcellsDirectories, "stemcells")
+	}
+
+	if b.Options.Version == "" && fileExists(tileLayoutVersion) {
+		version, err := ioutil.ReadFile(tileLayoutVersion)
+		if err != nil {
+			return fmt.Errorf("failed to read version: %s", err)
+		}
+		b.Options.Version = strings.TrimSpace(string(version))
+		discovered = append(discovered, tileLayoutVersion)
+	}
+
+	if b.Options.OutputFile == "" && !b.Options.MetadataOnly {
+		workingDirectory, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		name := filepath.Base(workingDirectory)
+		if b.Options.Version != "" {
+			name += "-" + b.Options.Version
+		}
+		b.Options.OutputFile = name + ".pivotal"
+	}
+
+	// The metadata is printed on the same stream, so stay quiet for --metadata-only.
+	if !b.Options.MetadataOnly {
+		b.output.Printf("discovered tile layout: %s", strings.Join(discovered, ", "))
+	}
+
+	return nil
+}
+
+// fetchReleases runs kiln fetch with the Kilnfile and variables of the
+// bake. Releases are fetched into the releases directory when one is given,
+// otherwise into a directory under the user cache that belongs to the
+// Kilnfile, so fetches for other tiles do not delete them.
+func (b *Bake) fetchReleases() error {
+	if b.Options.Kilnfile == "" {
+		return errors.New("--fetch requires --kilnfile")
+	}
+
+	if b.Options.StubReleases {
+		return errors.New("--fetch cannot be provided when using --stub-releases")
+	}
+
+	if len(b.Options.ReleaseDirectories) > 1 {
+		return errors.New("--fetch requires at most one --releases-directory")
+	}
+
+	args := []string{"--kilnfile", b.Options.Kilnfile}
+
+	if len(b.Options.ReleaseDirectories) == 0 {
+		releasesDirectory, err := fetchedReleasesDirectory(b.Options.Kilnfile)
+		if err != nil {
+			return err
+		}
+		b.Options.ReleaseDirectories = []string{releasesDirectory}
+
+		// kiln owns this directory, so releases that are no longer locked
+		// are deleted without asking.
+		args = append(args, "--no-confirm")
+	}
+
+	args = append(args, "--releases-directory", b.Options.ReleaseDirectories[0])
+	for _, variablesFile := range b.Options.VariableFiles {
+		args = append(args, "--variables-file", variablesFile)
+	}
+	for _, variable := range b.Options.Variables {
+		args = append(args, "--variable", variable)
+	}
+	for _, variable := range b.Options.VariablesYAML {
+		args = append(args, "--variable-yaml", variable)
+	}
+	if b.Options.SecretsDirectory != "" {
+		args = append(args, "--secrets-directory", b.Options.SecretsDirectory)
+	}
+	if b.Options.SecretsHelper != "" {
+		args = append(args, "--secrets-helper", b.Options.SecretsHelper)
+	}
+
+	err := b.fetch.Execute(args)
+	if err != nil {
+		return fmt.Errorf("failed to fetch releases: %s", err)
+	}
+
+	return nil
+}
+
+func fetchedReleasesDirectory(kilnfilePath string) (string, error) {
+	kilnfilePath, err := filepath.Abs(kilnfilePath)
+	if err != nil {
+		return "", err
+	}
+
+	cacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		cacheDirectory = os.TempDir()
+	}
+
+	return filepath.Join(cacheDirectory, "kiln", "releases", fmt.Sprintf("%x", sha256.Sum256([]byte(kilnfilePath)))[:16]), nil
+}
+
+// stubReleaseManifests takes the releases from Kilnfile.lock. A local
+// tarball of the same release, version and sha1 is preferred since its file
+// name records the stemcell it was compiled against.
+func stubReleaseManifests(lockedReleases, localReleases map[string]interface{}) map[string]interface{} {
+	manifests := map[string]interface{}{}
+	for name, locked := range lockedReleases {
+		manifests[name] = locked
+
+		lockedManifest, ok := locked.(builder.ReleaseManifest)
+		if !ok {
+			continue
+		}
+
+		localManifest, ok := localReleases[name].(builder.ReleaseManifest)
+		if ok && localManifest.Version == lockedManifest.Version && localManifest.SHA1 == lockedManifest.SHA1 {
+			manifests[name] = localManifest
+		}
+	}
+
+	return manifests
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (b Bake) Usage() jhanda.Usage {
 	return jhanda.Usage{
-		Description:      "Bakes tile metadata, stemcell, releases, and migrations into a format that can be consumed by OpsManager.",
+		Description:      "Bakes tile metadata, stemcell, releases, and migrations into a format that can be consumed by OpsManager. Run without --metadata in a directory with a base.yml to pick up the standard tile layout created by kiln init.",
 		ShortDescription: "bakes a tile",
 		Flags:            b.Options,
 	}
@@ -2,6 +2,7 @@ package commands_test
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -11,10 +12,12 @@ import (
 	"github.com/pivotal-cf/kiln/builder"
 	"github.com/pivotal-cf/kiln/commands"
 	"github.com/pivotal-cf/kiln/commands/fakes"
+	"github.com/pivotal-cf/kiln/internal/baking"
 	"gopkg.in/yaml.v2"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	. "github.com/pivotal-cf-experimental/gomegamatchers"
 )
 
@@ -28,13 +31,19 @@ var _ = Describe("Bake", func() {
 		fakeJobsService              *fakes.JobsService
 		fakeLogger                   *log.Logger
 		fakeMetadataService          *fakes.MetadataService
+		fakeMetadataPatcher          *fakes.MetadataPatcher
 		fakePropertiesService        *fakes.PropertiesService
 		fakeReleasesService          *fakes.ReleasesService
 		fakeRuntimeConfigsService    *fakes.RuntimeConfigsService
+		fakeSecretScanner            *fakes.SecretScanner
+		fakeSnippetsService          *fakes.SnippetsService
 		fakeStemcellService          *fakes.StemcellService
 		fakeTemplateVariablesService *fakes.TemplateVariablesService
+		fakeVariablesSchemaService   *fakes.VariablesSchemaService
 		fakeTileWriter               *fakes.TileWriter
 		fakeChecksummer              *fakes.Checksummer
+		fakeFetch                    *fakes.Command
+		output                       *gbytes.Buffer
 
 		otherReleasesDirectory string
 		someReleasesDirectory  string
@@ -64,19 +73,28 @@ var _ = Describe("Bake", func() {
 		fakeInstanceGroupsService = &fakes.InstanceGroupsService{}
 		fakeInterpolator = &fakes.Interpolator{}
 		fakeJobsService = &fakes.JobsService{}
-		fakeLogger = log.New(GinkgoWriter, "", 0)
+		output = gbytes.NewBuffer()
+		fakeLogger = log.New(output, "", 0)
 		fakeMetadataService = &fakes.MetadataService{}
+		fakeMetadataPatcher = &fakes.MetadataPatcher{}
+		fakeSecretScanner = &fakes.SecretScanner{}
 		fakePropertiesService = &fakes.PropertiesService{}
 		fakeReleasesService = &fakes.ReleasesService{}
 		fakeRuntimeConfigsService = &fakes.RuntimeConfigsService{}
+		fakeSnippetsService = &fakes.SnippetsService{}
 		fakeStemcellService = &fakes.StemcellService{}
 		fakeTemplateVariablesService = &fakes.TemplateVariablesService{}
+		fakeVariablesSchemaService = &fakes.VariablesSchemaService{}
 		fakeTileWriter = &fakes.TileWriter{}
 		fakeChecksummer = &fakes.Checksummer{}
+		fakeFetch = &fakes.Command{}
 
-		fakeTemplateVariablesService.FromPathsAndPairsReturns(map[string]interface{}{
+		fakeTemplateVariablesService.ReadReturns(map[string]interface{}{
 			"some-variable-from-file": "some-variable-value-from-file",
 			"some-variable":           "some-variable-value",
+		}, map[string]string{
+			"some-variable-from-file": "some-variables-file",
+			"some-variable":           "--variable some-variable",
 		}, nil)
 
 		fakeReleasesService.FromDirectoriesReturns(map[string]interface{}{
@@ -144,6 +162,12 @@ var _ = Describe("Bake", func() {
 			},
 		}, nil)
 
+		fakeSnippetsService.FromDirectoriesReturns(map[string]interface{}{
+			"some-snippet": builder.Metadata{
+				"name": "some-snippet",
+			},
+		}, nil)
+
 		fakeIconService.EncodeReturns("some-encoded-icon", nil)
 
 		fakeMetadataService.ReadReturns([]byte("some-metadata"), nil)
@@ -155,6 +179,7 @@ var _ = Describe("Bake", func() {
 			fakeTileWriter,
 			fakeLogger,
 			fakeTemplateVariablesService,
+			fakeVariablesSchemaService,
 			fakeBOSHVariablesService,
 			fakeReleasesService,
 			fakeStemcellService,
@@ -163,9 +188,13 @@ var _ = Describe("Bake", func() {
 			fakeJobsService,
 			fakePropertiesService,
 			fakeRuntimeConfigsService,
+			fakeSnippetsService,
 			fakeIconService,
 			fakeMetadataService,
+			fakeMetadataPatcher,
+			fakeSecretScanner,
 			fakeChecksummer,
+			fakeFetch,
 		)
 	})
 
@@ -188,21 +217,24 @@ var _ = Describe("Bake", func() {
 				"--releases-directory", someReleasesDirectory,
 				"--runtime-configs-directory", "some-other-runtime-configs-directory",
 				"--runtime-configs-directory", "some-runtime-configs-directory",
+				"--snippets-directory", "some-snippets-directory",
 				"--stemcell-tarball", "some-stemcell-tarball",
 				"--bosh-variables-directory", "some-other-variables-directory",
 				"--bosh-variables-directory", "some-variables-directory",
 				"--version", "1.2.3", "--migrations-directory", "some-migrations-directory",
 				"--migrations-directory", "some-other-migrations-directory",
 				"--variable", "some-variable=some-variable-value",
+				"--variable-yaml", "some-yaml-variable={some-key: 1}",
 				"--variables-file", "some-variables-file",
 				"--sha256",
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(fakeTemplateVariablesService.FromPathsAndPairsCallCount()).To(Equal(1))
-			varFiles, variables := fakeTemplateVariablesService.FromPathsAndPairsArgsForCall(0)
+			Expect(fakeTemplateVariablesService.ReadCallCount()).To(Equal(1))
+			varFiles, variables, yamlVariables := fakeTemplateVariablesService.ReadArgsForCall(0)
 			Expect(varFiles).To(Equal([]string{"some-variables-file"}))
 			Expect(variables).To(Equal([]string{"some-variable=some-variable-value"}))
+			Expect(yamlVariables).To(Equal([]string{"some-yaml-variable={some-key: 1}"}))
 
 			Expect(fakeBOSHVariablesService.FromDirectoriesCallCount()).To(Equal(1))
 			Expect(fakeBOSHVariablesService.FromDirectoriesArgsForCall(0)).To(Equal([]string{
@@ -234,6 +266,9 @@ var _ = Describe("Bake", func() {
 				"some-runtime-configs-directory",
 			}))
 
+			Expect(fakeSnippetsService.FromDirectoriesCallCount()).To(Equal(1))
+			Expect(fakeSnippetsService.FromDirectoriesArgsForCall(0)).To(Equal([]string{"some-snippets-directory"}))
+
 			Expect(fakeIconService.EncodeCallCount()).To(Equal(1))
 			Expect(fakeIconService.EncodeArgsForCall(0)).To(Equal("some-icon-path"))
 
@@ -244,7 +279,8 @@ var _ = Describe("Bake", func() {
 
 			input, metadata := fakeInterpolator.InterpolateArgsForCall(0)
 			Expect(input).To(Equal(builder.InterpolateInput{
-				Version: "1.2.3",
+				TemplatePath: "some-metadata",
+				Version:      "1.2.3",
 				BOSHVariables: map[string]interface{}{
 					"some-secret": builder.Metadata{
 						"name": "some-secret",
@@ -255,6 +291,10 @@ var _ = Describe("Bake", func() {
 					"some-variable-from-file": "some-variable-value-from-file",
 					"some-variable":           "some-variable-value",
 				},
+				VariableSources: map[string]string{
+					"some-variable-from-file": "some-variables-file",
+					"some-variable":           "--variable some-variable",
+				},
 				ReleaseManifests: map[string]interface{}{
 					"some-release-1": builder.ReleaseManifest{
 						Name:    "some-release-1",
@@ -307,6 +347,11 @@ var _ = Describe("Bake", func() {
 						"runtime_config": "some-addon-runtime-config",
 					},
 				},
+				Snippets: map[string]interface{}{
+					"some-snippet": builder.Metadata{
+						"name": "some-snippet",
+					},
+				},
 			}))
 
 			Expect(string(metadata)).To(Equal("some-metadata"))
@@ -314,6 +359,8 @@ var _ = Describe("Bake", func() {
 			Expect(fakeTileWriter.WriteCallCount()).To(Equal(1))
 			metadata, writeInput := fakeTileWriter.WriteArgsForCall(0)
 			Expect(string(metadata)).To(Equal("some-interpolated-metadata"))
+			Expect(writeInput.Tee).NotTo(BeNil())
+			writeInput.Tee = nil
 			Expect(writeInput).To(Equal(builder.WriteInput{
 				OutputFile:           filepath.Join("some-output-dir", "some-product-file-1.2.3-build.4"),
 				StubReleases:         false,
@@ -322,11 +369,252 @@ var _ = Describe("Bake", func() {
 				EmbedPaths:           []string{"some-embed-path"},
 			}))
 
-			Expect(fakeChecksummer.SumCallCount()).To(Equal(1))
-			outputFilePath := fakeChecksummer.SumArgsForCall(0)
+			Expect(fakeChecksummer.RecordCallCount()).To(Equal(1))
+			outputFilePath, _ := fakeChecksummer.RecordArgsForCall(0)
 			Expect(outputFilePath).To(Equal(filepath.Join("some-output-dir", "some-product-file-1.2.3-build.4")))
 		})
 
+		Context("when the --sha256 flag is specified", func() {
+			It("checksums the tile while it is written", func() {
+				fakeTileWriter.WriteStub = func(_ []byte, input builder.WriteInput) error {
+					_, err := input.Tee.Write([]byte("some-tile-contents"))
+					return err
+				}
+
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-tile.pivotal",
+					"--sha256",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				path, checksum := fakeChecksummer.RecordArgsForCall(0)
+				Expect(path).To(Equal("some-tile.pivotal"))
+				Expect(fmt.Sprintf("%x", checksum)).To(Equal("237d5da4ab568ea8ea5a918cebe441626290459e7f14e0ddbbcfcdbed29544c2"))
+			})
+		})
+
+		Context("when the output file is -", func() {
+			It("streams the tile to stdout", func() {
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "-",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				_, writeInput := fakeTileWriter.WriteArgsForCall(0)
+				Expect(writeInput.OutputFile).To(Equal("-"))
+				Expect(writeInput.Output).To(BeIdenticalTo(os.Stdout))
+			})
+		})
+
+		Context("when ops files are provided", func() {
+			It("patches the interpolated metadata before writing the tile", func() {
+				fakeMetadataPatcher.PatchReturns([]byte("some-patched-metadata"), nil)
+
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-dir/some-product-file-1.2.3-build.4",
+					"--ops-file", "some-ops-file.yml",
+					"--ops-file", "some-other-ops-file.yml",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeMetadataPatcher.PatchCallCount()).To(Equal(1))
+				metadata, opsFiles := fakeMetadataPatcher.PatchArgsForCall(0)
+				Expect(string(metadata)).To(Equal("some-interpolated-metadata"))
+				Expect(opsFiles).To(Equal([]string{"some-ops-file.yml", "some-other-ops-file.yml"}))
+
+				generatedMetadataContents, _ := fakeTileWriter.WriteArgsForCall(0)
+				Expect(string(generatedMetadataContents)).To(Equal("some-patched-metadata"))
+			})
+
+			It("prints the patched metadata with --metadata-only", func() {
+				fakeMetadataPatcher.PatchReturns([]byte("some-patched-metadata"), nil)
+
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--metadata-only",
+					"--ops-file", "some-ops-file.yml",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(output.Contents())).To(Equal("some-patched-metadata\n"))
+			})
+		})
+
+		Context("when the tile is scanned for secrets", func() {
+			BeforeEach(func() {
+				secretsDirectory := filepath.Join(tmpDir, "secrets")
+				Expect(os.Mkdir(secretsDirectory, 0700)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(secretsDirectory, "aws_secret"), []byte("some-aws-secret\n"), 0600)).To(Succeed())
+
+				fakeInterpolator.InterpolateStub = func(input builder.InterpolateInput, _ []byte) ([]byte, error) {
+					for _, provider := range input.SecretProviders {
+						_, _, err := provider.Secret("aws_secret")
+						if err != nil {
+							return nil, err
+						}
+					}
+					return []byte("some-interpolated-metadata"), nil
+				}
+
+				fakeTemplateVariablesService.ReadReturns(map[string]interface{}{
+					"password": "some-password",
+				}, nil, nil)
+				fakeVariablesSchemaService.FromPathReturns(baking.VariablesSchema{
+					{Name: "password", Sensitive: true},
+				}, nil)
+			})
+
+			It("scans the metadata and embedded files for sensitive values and the secret patterns", func() {
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-dir/some-product-file-1.2.3-build.4",
+					"--embed", "some-embed-path",
+					"--variables-schema", "some-variables-schema.yml",
+					"--secrets-directory", filepath.Join(tmpDir, "secrets"),
+					"--secret-pattern", "internal-[0-9]+",
+					"--skip-default-secret-patterns",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeSecretScanner.ScanCallCount()).To(Equal(1))
+				metadata, embedPaths, sensitiveValues, patterns, skipDefaultPatterns := fakeSecretScanner.ScanArgsForCall(0)
+				Expect(string(metadata)).To(Equal("some-interpolated-metadata"))
+				Expect(embedPaths).To(Equal([]string{"some-embed-path"}))
+				Expect(sensitiveValues).To(Equal([]builder.SensitiveValue{
+					{Source: `sensitive variable "password"`, Value: "some-password"},
+					{Source: `secret "aws_secret"`, Value: "some-aws-secret"},
+				}))
+				Expect(patterns).To(Equal([]string{"internal-[0-9]+"}))
+				Expect(skipDefaultPatterns).To(BeTrue())
+			})
+
+			It("refuses to write a tile that contains secrets", func() {
+				fakeSecretScanner.ScanReturns(errors.New("- metadata line 3 contains the value of secret \"aws_secret\""))
+
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-dir/some-product-file-1.2.3-build.4",
+				})
+				Expect(err).To(MatchError("refusing to bake a tile that contains secrets:\n- metadata line 3 contains the value of secret \"aws_secret\""))
+				Expect(fakeTileWriter.WriteCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when a variables schema is provided", func() {
+			BeforeEach(func() {
+				fakeVariablesSchemaService.FromPathReturns(baking.VariablesSchema{
+					{Name: "some-variable", Type: "string", Required: true, Description: "some description"},
+					{Name: "some-variable-from-file", Type: "string"},
+					{Name: "some-default-variable", Type: "integer", Default: 3},
+				}, nil)
+			})
+
+			It("checks the variables and fills in the defaults before interpolating", func() {
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-dir/some-product-file-1.2.3-build.4",
+					"--variables-schema", "some-variables-schema.yml",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeVariablesSchemaService.FromPathArgsForCall(0)).To(Equal("some-variables-schema.yml"))
+
+				input, _ := fakeInterpolator.InterpolateArgsForCall(0)
+				Expect(input.Variables).To(Equal(map[string]interface{}{
+					"some-variable-from-file": "some-variable-value-from-file",
+					"some-variable":           "some-variable-value",
+					"some-default-variable":   3,
+				}))
+			})
+
+			It("reports every variable that does not match the schema", func() {
+				fakeTemplateVariablesService.ReadReturns(map[string]interface{}{
+					"some-variable-from-file": true,
+					"some-typo":               "some-value",
+				}, map[string]string{
+					"some-variable-from-file": "some-variables-file",
+					"some-typo":               "--variable some-typo",
+				}, nil)
+
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-dir/some-product-file-1.2.3-build.4",
+					"--variables-schema", "some-variables-schema.yml",
+				})
+				Expect(err).To(MatchError(`variables do not match the schema in some-variables-schema.yml:
+- missing required variable "some-variable": some description
+- variable "some-variable-from-file" from some-variables-file is a boolean, expected a string
+- variable "some-typo" from --variable some-typo is not declared in the schema`))
+				Expect(fakeInterpolator.InterpolateCallCount()).To(Equal(0))
+			})
+
+			It("lists the variables with --list-variables", func() {
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--variables-schema", "some-variables-schema.yml",
+					"--list-variables",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(output.Contents())).To(Equal(`NAME                     TYPE     REQUIRED  DEFAULT  DESCRIPTION
+some-variable            string   true               some description
+some-variable-from-file  string   false
+some-default-variable    integer  false     3
+`))
+				Expect(fakeInterpolator.InterpolateCallCount()).To(Equal(0))
+				Expect(fakeTileWriter.WriteCallCount()).To(Equal(0))
+			})
+
+			It("uses the variables-schema.yml next to the metadata file", func() {
+				schemaPath := filepath.Join(tmpDir, "variables-schema.yml")
+				Expect(ioutil.WriteFile(schemaPath, []byte("[]"), 0644)).To(Succeed())
+
+				err := bake.Execute([]string{
+					"--metadata", filepath.Join(tmpDir, "base.yml"),
+					"--metadata-only",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeVariablesSchemaService.FromPathArgsForCall(0)).To(Equal(schemaPath))
+			})
+
+			Context("when the schema cannot be read", func() {
+				It("returns an error", func() {
+					fakeVariablesSchemaService.FromPathReturns(nil, errors.New("some-error"))
+
+					err := bake.Execute([]string{
+						"--metadata", "some-metadata",
+						"--metadata-only",
+						"--variables-schema", "some-variables-schema.yml",
+					})
+					Expect(err).To(MatchError("failed to read variables schema: some-error"))
+				})
+			})
+		})
+
+		Context("when --list-variables is passed without a variables schema", func() {
+			It("returns an error", func() {
+				err := bake.Execute([]string{"--metadata", "some-metadata", "--list-variables"})
+				Expect(err).To(MatchError("--list-variables requires --variables-schema or a variables-schema.yml next to the metadata file"))
+				Expect(fakeVariablesSchemaService.FromPathCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when no ops files are provided", func() {
+			It("does not patch the metadata", func() {
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-dir/some-product-file-1.2.3-build.4",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeMetadataPatcher.PatchCallCount()).To(Equal(0))
+			})
+		})
+
 		Context("when the --sha256 flag is not specified", func() {
 			It("does not calculate a checksum", func() {
 				err := bake.Execute([]string{
@@ -352,7 +640,7 @@ var _ = Describe("Bake", func() {
 				})
 				Expect(err).NotTo(HaveOccurred())
 
-				Expect(fakeChecksummer.SumCallCount()).To(Equal(0))
+				Expect(fakeChecksummer.RecordCallCount()).To(Equal(0))
 			})
 		})
 
@@ -459,6 +747,415 @@ var _ = Describe("Bake", func() {
 				Expect(fakeStemcellService.FromKilnfileCallCount()).To(Equal(1))
 				Expect(fakeStemcellService.FromKilnfileArgsForCall(0)).To(Equal("Kilnfile"))
 			})
+
+			It("verifies the releases against the Kilnfile.lock and bakes only the referenced tarballs", func() {
+				releases := map[string]interface{}{"some-release": "some-release-manifest"}
+				fakeReleasesService.FromDirectoriesReturns(releases, nil)
+
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-file",
+					"--releases-directory", someReleasesDirectory,
+					"--kilnfile", "Kilnfile",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeReleasesService.VerifyKilnfileLockCallCount()).To(Equal(1))
+				kilnfilePath, verifiedReleases := fakeReleasesService.VerifyKilnfileLockArgsForCall(0)
+				Expect(kilnfilePath).To(Equal("Kilnfile"))
+				Expect(verifiedReleases).To(Equal(releases))
+
+				_, writeInput := fakeTileWriter.WriteArgsForCall(0)
+				Expect(writeInput.ReferencedReleasesOnly).To(BeTrue())
+			})
+
+			Context("when the releases do not match the Kilnfile.lock", func() {
+				It("returns an error", func() {
+					fakeReleasesService.VerifyKilnfileLockReturns(errors.New("release tarballs do not match Kilnfile.lock"))
+
+					err := bake.Execute([]string{
+						"--metadata", "some-metadata",
+						"--output-file", "some-output-file",
+						"--kilnfile", "Kilnfile",
+					})
+					Expect(err).To(MatchError("failed to verify releases: release tarballs do not match Kilnfile.lock"))
+					Expect(fakeTileWriter.WriteCallCount()).To(Equal(0))
+				})
+			})
+
+			Context("when the releases are stubbed", func() {
+				It("takes the releases from the Kilnfile.lock instead of verifying them", func() {
+					fakeReleasesService.FromDirectoriesReturns(map[string]interface{}{
+						"some-release": builder.ReleaseManifest{
+							Name:            "some-release",
+							Version:         "1.2.3",
+							File:            "some-release-1.2.3-some-os-4.5.tgz",
+							SHA1:            "some-sha1",
+							StemcellOS:      "some-os",
+							StemcellVersion: "4.5",
+						},
+						"other-release": builder.ReleaseManifest{
+							Name:    "other-release",
+							Version: "0.0.1",
+							File:    "other-release-0.0.1.tgz",
+							SHA1:    "stale-sha1",
+						},
+					}, nil)
+					fakeReleasesService.FromKilnfileReturns(map[string]interface{}{
+						"some-release": builder.ReleaseManifest{
+							Name:    "some-release",
+							Version: "1.2.3",
+							File:    "some-release-1.2.3.tgz",
+							SHA1:    "some-sha1",
+						},
+						"other-release": builder.ReleaseManifest{
+							Name:    "other-release",
+							Version: "0.0.2",
+							File:    "other-release-0.0.2.tgz",
+							SHA1:    "other-sha1",
+						},
+					}, nil)
+
+					err := bake.Execute([]string{
+						"--metadata", "some-metadata",
+						"--output-file", "some-output-file",
+						"--kilnfile", "Kilnfile",
+						"--stub-releases",
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(fakeReleasesService.VerifyKilnfileLockCallCount()).To(Equal(0))
+
+					Expect(fakeReleasesService.FromKilnfileCallCount()).To(Equal(1))
+					Expect(fakeReleasesService.FromKilnfileArgsForCall(0)).To(Equal("Kilnfile"))
+
+					interpolateInput, _ := fakeInterpolator.InterpolateArgsForCall(0)
+					Expect(interpolateInput.ReleaseManifests).To(Equal(map[string]interface{}{
+						"some-release": builder.ReleaseManifest{
+							Name:            "some-release",
+							Version:         "1.2.3",
+							File:            "some-release-1.2.3-some-os-4.5.tgz",
+							SHA1:            "some-sha1",
+							StemcellOS:      "some-os",
+							StemcellVersion: "4.5",
+						},
+						"other-release": builder.ReleaseManifest{
+							Name:    "other-release",
+							Version: "0.0.2",
+							File:    "other-release-0.0.2.tgz",
+							SHA1:    "other-sha1",
+						},
+					}))
+				})
+
+				Context("when the Kilnfile.lock cannot be read", func() {
+					It("returns an error", func() {
+						fakeReleasesService.FromKilnfileReturns(nil, errors.New("no such file"))
+
+						err := bake.Execute([]string{
+							"--metadata", "some-metadata",
+							"--output-file", "some-output-file",
+							"--kilnfile", "Kilnfile",
+							"--stub-releases",
+						})
+						Expect(err).To(MatchError("failed to parse releases: no such file"))
+					})
+				})
+			})
+		})
+
+		Context("when --fetch is provided", func() {
+			It("fetches the releases into the releases directory before reading them", func() {
+				fakeFetch.ExecuteStub = func([]string) error {
+					Expect(fakeReleasesService.FromDirectoriesCallCount()).To(Equal(0))
+					return nil
+				}
+
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-file",
+					"--kilnfile", "Kilnfile",
+					"--releases-directory", "some-releases-directory",
+					"--variables-file", "some-variables-file",
+					"--variable", "some-variable=some-value",
+					"--variable-yaml", "some-yaml-variable=[1, 2]",
+					"--fetch",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeFetch.ExecuteCallCount()).To(Equal(1))
+				Expect(fakeFetch.ExecuteArgsForCall(0)).To(Equal([]string{
+					"--kilnfile", "Kilnfile",
+					"--releases-directory", "some-releases-directory",
+					"--variables-file", "some-variables-file",
+					"--variable", "some-variable=some-value",
+					"--variable-yaml", "some-yaml-variable=[1, 2]",
+				}))
+
+				Expect(fakeReleasesService.FromDirectoriesArgsForCall(0)).To(Equal([]string{"some-releases-directory"}))
+			})
+
+			It("passes the secret providers to fetch", func() {
+				err := bake.Execute([]string{
+					"--metadata", "some-metadata",
+					"--output-file", "some-output-file",
+					"--kilnfile", "Kilnfile",
+					"--releases-directory", "some-releases-directory",
+					"--secrets-directory", "some-secrets-directory",
+					"--secrets-helper", "some-secrets-helper",
+					"--fetch",
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(fakeFetch.ExecuteArgsForCall(0)).To(Equal([]string{
+					"--kilnfile", "Kilnfile",
+					"--releases-directory", "some-releases-directory",
+					"--secrets-directory", "some-secrets-directory",
+					"--secrets-helper", "some-secrets-helper",
+				}))
+			})
+
+			Context("when no releases directory is provided", func() {
+				It("fetches the releases into a cache directory for the Kilnfile", func() {
+					err := bake.Execute([]string{
+						"--metadata", "some-metadata",
+						"--output-file", "some-output-file",
+						"--kilnfile", "Kilnfile",
+						"--fetch",
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					args := fakeFetch.ExecuteArgsForCall(0)
+					Expect(args[:3]).To(Equal([]string{"--kilnfile", "Kilnfile", "--no-confirm"}))
+					Expect(args[3]).To(Equal("--releases-directory"))
+
+					releasesDirectory := args[4]
+					Expect(releasesDirectory).To(ContainSubstring(filepath.Join("kiln", "releases")))
+					Expect(fakeReleasesService.FromDirectoriesArgsForCall(0)).To(Equal([]string{releasesDirectory}))
+
+					Expect(bake.Execute([]string{
+						"--metadata", "some-metadata",
+						"--output-file", "some-output-file",
+						"--kilnfile", "Kilnfile",
+						"--fetch",
+					})).To(Succeed())
+					Expect(fakeFetch.ExecuteArgsForCall(1)[4]).To(Equal(releasesDirectory))
+				})
+			})
+
+			Context("failure cases", func() {
+				It("requires --kilnfile", func() {
+					err := bake.Execute([]string{"--metadata", "some-metadata", "--output-file", "some-output-file", "--fetch"})
+					Expect(err).To(MatchError("--fetch requires --kilnfile"))
+				})
+
+				It("does not stub releases", func() {
+					err := bake.Execute([]string{"--metadata", "some-metadata", "--output-file", "some-output-file", "--kilnfile", "Kilnfile", "--fetch", "--stub-releases"})
+					Expect(err).To(MatchError("--fetch cannot be provided when using --stub-releases"))
+				})
+
+				It("fetches into at most one releases directory", func() {
+					err := bake.Execute([]string{
+						"--metadata", "some-metadata",
+						"--output-file", "some-output-file",
+						"--kilnfile", "Kilnfile",
+						"--releases-directory", "some-releases-directory",
+						"--releases-directory", "other-releases-directory",
+						"--fetch",
+					})
+					Expect(err).To(MatchError("--fetch requires at most one --releases-directory"))
+				})
+
+				It("returns the error when fetching fails", func() {
+					fakeFetch.ExecuteReturns(errors.New("could not find the following releases"))
+
+					err := bake.Execute([]string{"--metadata", "some-metadata", "--output-file", "some-output-file", "--kilnfile", "Kilnfile", "--fetch"})
+					Expect(err).To(MatchError("failed to fetch releases: could not find the following releases"))
+					Expect(fakeTileWriter.WriteCallCount()).To(Equal(0))
+				})
+			})
+		})
+
+		Context("when the Kilnfile has a bake section", func() {
+			var kilnfilePath string
+
+			BeforeEach(func() {
+				kilnfilePath = filepath.Join(tmpDir, "Kilnfile")
+				Expect(ioutil.WriteFile(kilnfilePath, []byte(`---
+bake:
+  metadata: base.yml
+  output_file: tile.pivotal
+  version: 1.2.3
+  forms_directories: [forms]
+  releases_directories: [releases]
+  variables_files: [variables.yml]
+  variables:
+    some-variable: some-value
+    other-variable: other-config-value
+  secret_patterns: ["internal-[0-9]+"]
+bake_profiles:
+  dev:
+    stub_releases: true
+    variables_files: [dev-variables.yml]
+    ops_files: [dev-ops.yml]
+  ci:
+    sha256: true
+`), 0644)).To(Succeed())
+			})
+
+			It("bakes with the Kilnfile settings", func() {
+				Expect(bake.Execute([]string{"--kilnfile", kilnfilePath})).To(Succeed())
+
+				Expect(fakeMetadataService.ReadArgsForCall(0)).To(Equal(filepath.Join(tmpDir, "base.yml")))
+				Expect(fakeFormsService.FromDirectoriesArgsForCall(0)).To(Equal([]string{filepath.Join(tmpDir, "forms")}))
+				Expect(fakeReleasesService.FromDirectoriesArgsForCall(0)).To(Equal([]string{filepath.Join(tmpDir, "releases")}))
+
+				varFiles, variables, _ := fakeTemplateVariablesService.ReadArgsForCall(0)
+				Expect(varFiles).To(Equal([]string{filepath.Join(tmpDir, "variables.yml")}))
+				Expect(variables).To(Equal([]string{"other-variable=other-config-value", "some-variable=some-value"}))
+
+				interpolateInput, _ := fakeInterpolator.InterpolateArgsForCall(0)
+				Expect(interpolateInput.Version).To(Equal("1.2.3"))
+
+				_, _, _, patterns, _ := fakeSecretScanner.ScanArgsForCall(0)
+				Expect(patterns).To(Equal([]string{"internal-[0-9]+"}))
+
+				_, writeInput := fakeTileWriter.WriteArgsForCall(0)
+				Expect(writeInput.OutputFile).To(Equal(filepath.Join(tmpDir, "tile.pivotal")))
+				Expect(writeInput.StubReleases).To(BeFalse())
+				Expect(fakeChecksummer.RecordCallCount()).To(Equal(0))
+			})
+
+			It("applies the selected profile", func() {
+				Expect(bake.Execute([]string{"--kilnfile", kilnfilePath, "--profile", "dev"})).To(Succeed())
+
+				varFiles, _, _ := fakeTemplateVariablesService.ReadArgsForCall(0)
+				Expect(varFiles).To(Equal([]string{filepath.Join(tmpDir, "variables.yml"), filepath.Join(tmpDir, "dev-variables.yml")}))
+
+				_, opsFiles := fakeMetadataPatcher.PatchArgsForCall(0)
+				Expect(opsFiles).To(Equal([]string{filepath.Join(tmpDir, "dev-ops.yml")}))
+
+				_, writeInput := fakeTileWriter.WriteArgsForCall(0)
+				Expect(writeInput.StubReleases).To(BeTrue())
+			})
+
+			It("lets flags override the Kilnfile settings", func() {
+				Expect(bake.Execute([]string{
+					"--kilnfile", kilnfilePath,
+					"--profile", "ci",
+					"--forms-directory", "other-forms",
+					"--version", "2.0.0",
+					"--variable", "some-variable=other-value",
+				})).To(Succeed())
+
+				Expect(fakeFormsService.FromDirectoriesArgsForCall(0)).To(Equal([]string{"other-forms"}))
+				interpolateInput, _ := fakeInterpolator.InterpolateArgsForCall(0)
+				Expect(interpolateInput.Version).To(Equal("2.0.0"))
+				_, variables, _ := fakeTemplateVariablesService.ReadArgsForCall(0)
+				Expect(variables).To(Equal([]string{"other-variable=other-config-value", "some-variable=other-value"}))
+				Expect(fakeChecksummer.RecordCallCount()).To(Equal(1))
+			})
+
+			It("lets flags turn off what the Kilnfile turns on", func() {
+				Expect(bake.Execute([]string{
+					"--kilnfile", kilnfilePath,
+					"--profile", "ci",
+					"--sha256=false",
+				})).To(Succeed())
+
+				Expect(fakeChecksummer.RecordCallCount()).To(Equal(0))
+			})
+
+			It("lets a profile turn off what the bake section turns on", func() {
+				Expect(ioutil.WriteFile(kilnfilePath, []byte("bake:\n  metadata: base.yml\n  stub_releases: true\nbake_profiles:\n  full:\n    stub_releases: false\n"), 0644)).To(Succeed())
+
+				Expect(bake.Execute([]string{"--kilnfile", kilnfilePath, "--profile", "full", "--output-file", "some-output-file"})).To(Succeed())
+
+				_, writeInput := fakeTileWriter.WriteArgsForCall(0)
+				Expect(writeInput.StubReleases).To(BeFalse())
+			})
+
+			When("the profile does not exist", func() {
+				It("returns an error listing the profiles", func() {
+					err := bake.Execute([]string{"--kilnfile", kilnfilePath, "--profile", "prod"})
+					Expect(err).To(MatchError(`bake profile "prod" not found in Kilnfile (available profiles: ci, dev)`))
+				})
+			})
+		})
+
+		Context("when --profile is passed without --kilnfile", func() {
+			It("returns an error", func() {
+				err := bake.Execute([]string{"--metadata", "some-metadata", "--metadata-only", "--profile", "dev"})
+				Expect(err).To(MatchError("--profile requires --kilnfile"))
+			})
+		})
+
+		Context("when run without flags in a standard tile layout", func() {
+			var (
+				tileDir          string
+				workingDirectory string
+			)
+
+			BeforeEach(func() {
+				var err error
+				workingDirectory, err = os.Getwd()
+				Expect(err).NotTo(HaveOccurred())
+
+				tileDir = filepath.Join(tmpDir, "some-tile")
+				for _, directory := range []string{"forms", "jobs", "instance-groups", "releases", "migrations"} {
+					Expect(os.MkdirAll(filepath.Join(tileDir, directory), 0755)).To(Succeed())
+				}
+				for name, contents := range map[string]string{
+					"base.yml":      "---\nname: some-tile\n",
+					"icon.png":      "some-icon",
+					"version":       "1.2.3\n",
+					"Kilnfile.lock": "---\nstemcell_criteria: {os: some-os, version: \"1.2\"}\n",
+				} {
+					Expect(ioutil.WriteFile(filepath.Join(tileDir, name), []byte(contents), 0644)).To(Succeed())
+				}
+
+				Expect(os.Chdir(tileDir)).To(Succeed())
+			})
+
+			AfterEach(func() {
+				Expect(os.Chdir(workingDirectory)).To(Succeed())
+			})
+
+			It("bakes the tile from the directories it finds", func() {
+				Expect(bake.Execute([]string{})).To(Succeed())
+
+				Expect(fakeMetadataService.ReadArgsForCall(0)).To(Equal("base.yml"))
+				Expect(fakeIconService.EncodeArgsForCall(0)).To(Equal("icon.png"))
+				Expect(fakeFormsService.FromDirectoriesArgsForCall(0)).To(Equal([]string{"forms"}))
+				Expect(fakeJobsService.FromDirectoriesArgsForCall(0)).To(Equal([]string{"jobs"}))
+				Expect(fakeInstanceGroupsService.FromDirectoriesArgsForCall(0)).To(Equal([]string{"instance-groups"}))
+				Expect(fakeReleasesService.FromDirectoriesArgsForCall(0)).To(Equal([]string{"releases"}))
+				Expect(fakePropertiesService.FromDirectoriesArgsForCall(0)).To(BeEmpty())
+				Expect(fakeStemcellService.FromKilnfileArgsForCall(0)).To(Equal("Kilnfile"))
+
+				interpolateInput, _ := fakeInterpolator.InterpolateArgsForCall(0)
+				Expect(interpolateInput.Version).To(Equal("1.2.3"))
+
+				_, writeInput := fakeTileWriter.WriteArgsForCall(0)
+				Expect(writeInput.OutputFile).To(Equal("some-tile-1.2.3.pivotal"))
+				Expect(writeInput.MigrationDirectories).To(Equal([]string{"migrations"}))
+
+				Expect(output).To(gbytes.Say("discovered tile layout: Kilnfile.lock, base.yml, icon.png, releases, forms, instance-groups, jobs, migrations, version"))
+			})
+
+			It("keeps the flags that are passed", func() {
+				Expect(bake.Execute([]string{"--forms-directory", "other-forms", "--output-file", "other.pivotal"})).To(Succeed())
+
+				Expect(fakeFormsService.FromDirectoriesArgsForCall(0)).To(Equal([]string{"other-forms"}))
+				_, writeInput := fakeTileWriter.WriteArgsForCall(0)
+				Expect(writeInput.OutputFile).To(Equal("other.pivotal"))
+			})
+
+			It("does not discover anything when --metadata is passed", func() {
+				Expect(bake.Execute([]string{"--metadata", "base.yml", "--metadata-only"})).To(Succeed())
+
+				Expect(fakeFormsService.FromDirectoriesArgsForCall(0)).To(BeEmpty())
+				Expect(fakeStemcellService.FromKilnfileCallCount()).To(Equal(0))
+			})
 		})
 
 		Context("when neither the --kilnfile nor --stemcell-tarball flags are provided", func() {
@@ -477,7 +1174,7 @@ var _ = Describe("Bake", func() {
 		Context("failure cases", func() {
 			Context("when the template variables service errors", func() {
 				It("returns an error", func() {
-					fakeTemplateVariablesService.FromPathsAndPairsReturns(nil, errors.New("parsing template variables failed"))
+					fakeTemplateVariablesService.ReadReturns(nil, nil, errors.New("parsing template variables failed"))
 
 					err := bake.Execute([]string{
 						"--metadata", "some-metadata",
@@ -651,6 +1348,24 @@ var _ = Describe("Bake", func() {
 				})
 			})
 
+			Context("when the snippets service fails", func() {
+				It("returns an error", func() {
+					fakeSnippetsService.FromDirectoriesReturns(nil, errors.New("parsing snippets failed"))
+
+					err := bake.Execute([]string{
+						"--icon", "some-icon-path",
+						"--metadata", "some-metadata",
+						"--output-file", "some-output-dir/some-product-file-1.2.3-build.4",
+						"--snippets-directory", "some-snippets-directory",
+						"--releases-directory", someReleasesDirectory,
+						"--stemcell-tarball", "some-stemcell-tarball",
+						"--version", "1.2.3",
+					})
+
+					Expect(err).To(MatchError("failed to parse snippets: parsing snippets failed"))
+				})
+			})
+
 			Context("when the template interpolator returns an error", func() {
 				It("returns the error", func() {
 					fakeInterpolator.InterpolateReturns(nil, errors.New("some-error"))
@@ -670,6 +1385,21 @@ var _ = Describe("Bake", func() {
 				})
 			})
 
+			Context("when the ops files cannot be applied", func() {
+				It("returns an error", func() {
+					fakeMetadataPatcher.PatchReturns(nil, errors.New("some-error"))
+
+					err := bake.Execute([]string{
+						"--metadata", "some-metadata",
+						"--output-file", "some-output-dir/some-product-file-1.2.3-build.4",
+						"--ops-file", "some-ops-file.yml",
+					})
+
+					Expect(err).To(MatchError("failed to apply ops files: some-error"))
+					Expect(fakeTileWriter.WriteCallCount()).To(Equal(0))
+				})
+			})
+
 			Context("when the metadata flag is missing", func() {
 				It("returns an error", func() {
 					err := bake.Execute([]string{
@@ -803,7 +1533,7 @@ var _ = Describe("Bake", func() {
 
 			Context("when the checksummer returns an error", func() {
 				It("returns an error", func() {
-					fakeChecksummer.SumReturns(errors.New("failed"))
+					fakeChecksummer.RecordReturns(errors.New("failed"))
 
 					err := bake.Execute([]string{
 						"--embed", "some-embed-path",
@@ -837,7 +1567,7 @@ var _ = Describe("Bake", func() {
 	Describe("Usage", func() {
 		It("returns usage information for the command", func() {
 			Expect(bake.Usage()).To(Equal(jhanda.Usage{
-				Description:      "Bakes tile metadata, stemcell, releases, and migrations into a format that can be consumed by OpsManager.",
+				Description:      "Bakes tile metadata, stemcell, releases, and migrations into a format that can be consumed by OpsManager. Run without --metadata in a directory with a base.yml to pick up the standard tile layout created by kiln init.",
 				ShortDescription: "bakes a tile",
 				Flags:            bake.Options,
 			}))
@@ -6,77 +6,84 @@ import (
 )
 
 type Checksummer struct {
-	SumStub        func(string) error
-	sumMutex       sync.RWMutex
-	sumArgsForCall []struct {
+	RecordStub        func(string, []byte) error
+	recordMutex       sync.RWMutex
+	recordArgsForCall []struct {
 		arg1 string
+		arg2 []byte
 	}
-	sumReturns struct {
+	recordReturns struct {
 		result1 error
 	}
-	sumReturnsOnCall map[int]struct {
+	recordReturnsOnCall map[int]struct {
 		result1 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *Checksummer) Sum(arg1 string) error {
-	fake.sumMutex.Lock()
-	ret, specificReturn := fake.sumReturnsOnCall[len(fake.sumArgsForCall)]
-	fake.sumArgsForCall = append(fake.sumArgsForCall, struct {
+func (fake *Checksummer) Record(arg1 string, arg2 []byte) error {
+	var arg2Copy []byte
+	if arg2 != nil {
+		arg2Copy = make([]byte, len(arg2))
+		copy(arg2Copy, arg2)
+	}
+	fake.recordMutex.Lock()
+	ret, specificReturn := fake.recordReturnsOnCall[len(fake.recordArgsForCall)]
+	fake.recordArgsForCall = append(fake.recordArgsForCall, struct {
 		arg1 string
-	}{arg1})
-	fake.recordInvocation("Sum", []interface{}{arg1})
-	fake.sumMutex.Unlock()
-	if fake.SumStub != nil {
-		return fake.SumStub(arg1)
+		arg2 []byte
+	}{arg1, arg2Copy})
+	fake.recordInvocation("Record", []interface{}{arg1, arg2Copy})
+	fake.recordMutex.Unlock()
+	if fake.RecordStub != nil {
+		return fake.RecordStub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1
 	}
-	fakeReturns := fake.sumReturns
+	fakeReturns := fake.recordReturns
 	return fakeReturns.result1
 }
 
-func (fake *Checksummer) SumCallCount() int {
-	fake.sumMutex.RLock()
-	defer fake.sumMutex.RUnlock()
-	return len(fake.sumArgsForCall)
+func (fake *Checksummer) RecordCallCount() int {
+	fake.recordMutex.RLock()
+	defer fake.recordMutex.RUnlock()
+	return len(fake.recordArgsForCall)
 }
 
-func (fake *Checksummer) SumCalls(stub func(string) error) {
-	fake.sumMutex.Lock()
-	defer fake.sumMutex.Unlock()
-	fake.SumStub = stub
+func (fake *Checksummer) RecordCalls(stub func(string, []byte) error) {
+	fake.recordMutex.Lock()
+	defer fake.recordMutex.Unlock()
+	fake.RecordStub = stub
 }
 
-func (fake *Checksummer) SumArgsForCall(i int) string {
-	fake.sumMutex.RLock()
-	defer fake.sumMutex.RUnlock()
-	argsForCall := fake.sumArgsForCall[i]
-	return argsForCall.arg1
+func (fake *Checksummer) RecordArgsForCall(i int) (string, []byte) {
+	fake.recordMutex.RLock()
+	defer fake.recordMutex.RUnlock()
+	argsForCall := fake.recordArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
 }
 
-func (fake *Checksummer) SumReturns(result1 error) {
-	fake.sumMutex.Lock()
-	defer fake.sumMutex.Unlock()
-	fake.SumStub = nil
-	fake.sumReturns = struct {
+func (fake *Checksummer) RecordReturns(result1 error) {
+	fake.recordMutex.Lock()
+	defer fake.recordMutex.Unlock()
+	fake.RecordStub = nil
+	fake.recordReturns = struct {
 		result1 error
 	}{result1}
 }
 
-func (fake *Checksummer) SumReturnsOnCall(i int, result1 error) {
-	fake.sumMutex.Lock()
-	defer fake.sumMutex.Unlock()
-	fake.SumStub = nil
-	if fake.sumReturnsOnCall == nil {
-		fake.sumReturnsOnCall = make(map[int]struct {
+func (fake *Checksummer) RecordReturnsOnCall(i int, result1 error) {
+	fake.recordMutex.Lock()
+	defer fake.recordMutex.Unlock()
+	fake.RecordStub = nil
+	if fake.recordReturnsOnCall == nil {
+		fake.recordReturnsOnCall = make(map[int]struct {
 			result1 error
 		})
 	}
-	fake.sumReturnsOnCall[i] = struct {
+	fake.recordReturnsOnCall[i] = struct {
 		result1 error
 	}{result1}
 }
@@ -84,8 +91,8 @@ func (fake *Checksummer) SumReturnsOnCall(i int, result1 error) {
 func (fake *Checksummer) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
-	fake.sumMutex.RLock()
-	defer fake.sumMutex.RUnlock()
+	fake.recordMutex.RLock()
+	defer fake.recordMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -0,0 +1,22 @@
+package fakes
+
+type LocalStemcellsVersionsService struct {
+	VersionsCall struct {
+		CallCount int
+		Receives  struct {
+			Directory  string
+			StemcellOS string
+		}
+		Returns struct {
+			Versions []string
+			Err      error
+		}
+	}
+}
+
+func (mock *LocalStemcellsVersionsService) Versions(directory, stemcellOS string) ([]string, error) {
+	mock.VersionsCall.CallCount++
+	mock.VersionsCall.Receives.Directory = directory
+	mock.VersionsCall.Receives.StemcellOS = stemcellOS
+	return mock.VersionsCall.Returns.Versions, mock.VersionsCall.Returns.Err
+}
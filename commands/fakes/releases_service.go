@@ -19,6 +19,31 @@ type ReleasesService struct {
 		result1 map[string]interface{}
 		result2 error
 	}
+	FromKilnfileStub        func(string) (map[string]interface{}, error)
+	fromKilnfileMutex       sync.RWMutex
+	fromKilnfileArgsForCall []struct {
+		arg1 string
+	}
+	fromKilnfileReturns struct {
+		result1 map[string]interface{}
+		result2 error
+	}
+	fromKilnfileReturnsOnCall map[int]struct {
+		result1 map[string]interface{}
+		result2 error
+	}
+	VerifyKilnfileLockStub        func(string, map[string]interface{}) error
+	verifyKilnfileLockMutex       sync.RWMutex
+	verifyKilnfileLockArgsForCall []struct {
+		arg1 string
+		arg2 map[string]interface{}
+	}
+	verifyKilnfileLockReturns struct {
+		result1 error
+	}
+	verifyKilnfileLockReturnsOnCall map[int]struct {
+		result1 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
@@ -91,11 +116,139 @@ func (fake *ReleasesService) FromDirectoriesReturnsOnCall(i int, result1 map[str
 	}{result1, result2}
 }
 
+func (fake *ReleasesService) FromKilnfile(arg1 string) (map[string]interface{}, error) {
+	fake.fromKilnfileMutex.Lock()
+	ret, specificReturn := fake.fromKilnfileReturnsOnCall[len(fake.fromKilnfileArgsForCall)]
+	fake.fromKilnfileArgsForCall = append(fake.fromKilnfileArgsForCall, struct {
+		arg1 string
+	}{arg1})
+	fake.recordInvocation("FromKilnfile", []interface{}{arg1})
+	fake.fromKilnfileMutex.Unlock()
+	if fake.FromKilnfileStub != nil {
+		return fake.FromKilnfileStub(arg1)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	fakeReturns := fake.fromKilnfileReturns
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *ReleasesService) FromKilnfileCallCount() int {
+	fake.fromKilnfileMutex.RLock()
+	defer fake.fromKilnfileMutex.RUnlock()
+	return len(fake.fromKilnfileArgsForCall)
+}
+
+func (fake *ReleasesService) FromKilnfileCalls(stub func(string) (map[string]interface{}, error)) {
+	fake.fromKilnfileMutex.Lock()
+	defer fake.fromKilnfileMutex.Unlock()
+	fake.FromKilnfileStub = stub
+}
+
+func (fake *ReleasesService) FromKilnfileArgsForCall(i int) string {
+	fake.fromKilnfileMutex.RLock()
+	defer fake.fromKilnfileMutex.RUnlock()
+	argsForCall := fake.fromKilnfileArgsForCall[i]
+	return argsForCall.arg1
+}
+
+func (fake *ReleasesService) FromKilnfileReturns(result1 map[string]interface{}, result2 error) {
+	fake.fromKilnfileMutex.Lock()
+	defer fake.fromKilnfileMutex.Unlock()
+	fake.FromKilnfileStub = nil
+	fake.fromKilnfileReturns = struct {
+		result1 map[string]interface{}
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *ReleasesService) FromKilnfileReturnsOnCall(i int, result1 map[string]interface{}, result2 error) {
+	fake.fromKilnfileMutex.Lock()
+	defer fake.fromKilnfileMutex.Unlock()
+	fake.FromKilnfileStub = nil
+	if fake.fromKilnfileReturnsOnCall == nil {
+		fake.fromKilnfileReturnsOnCall = make(map[int]struct {
+			result1 map[string]interface{}
+			result2 error
+		})
+	}
+	fake.fromKilnfileReturnsOnCall[i] = struct {
+		result1 map[string]interface{}
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *ReleasesService) VerifyKilnfileLock(arg1 string, arg2 map[string]interface{}) error {
+	fake.verifyKilnfileLockMutex.Lock()
+	ret, specificReturn := fake.verifyKilnfileLockReturnsOnCall[len(fake.verifyKilnfileLockArgsForCall)]
+	fake.verifyKilnfileLockArgsForCall = append(fake.verifyKilnfileLockArgsForCall, struct {
+		arg1 string
+		arg2 map[string]interface{}
+	}{arg1, arg2})
+	fake.recordInvocation("VerifyKilnfileLock", []interface{}{arg1, arg2})
+	fake.verifyKilnfileLockMutex.Unlock()
+	if fake.VerifyKilnfileLockStub != nil {
+		return fake.VerifyKilnfileLockStub(arg1, arg2)
+	}
+	if specificReturn {
+		return ret.result1
+	}
+	fakeReturns := fake.verifyKilnfileLockReturns
+	return fakeReturns.result1
+}
+
+func (fake *ReleasesService) VerifyKilnfileLockCallCount() int {
+	fake.verifyKilnfileLockMutex.RLock()
+	defer fake.verifyKilnfileLockMutex.RUnlock()
+	return len(fake.verifyKilnfileLockArgsForCall)
+}
+
+func (fake *ReleasesService) VerifyKilnfileLockCalls(stub func(string, map[string]interface{}) error) {
+	fake.verifyKilnfileLockMutex.Lock()
+	defer fake.verifyKilnfileLockMutex.Unlock()
+	fake.VerifyKilnfileLockStub = stub
+}
+
+func (fake *ReleasesService) VerifyKilnfileLockArgsForCall(i int) (string, map[string]interface{}) {
+	fake.verifyKilnfileLockMutex.RLock()
+	defer fake.verifyKilnfileLockMutex.RUnlock()
+	argsForCall := fake.verifyKilnfileLockArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2
+}
+
+func (fake *ReleasesService) VerifyKilnfileLockReturns(result1 error) {
+	fake.verifyKilnfileLockMutex.Lock()
+	defer fake.verifyKilnfileLockMutex.Unlock()
+	fake.VerifyKilnfileLockStub = nil
+	fake.verifyKilnfileLockReturns = struct {
+		result1 error
+	}{result1}
+}
+
+func (fake *ReleasesService) VerifyKilnfileLockReturnsOnCall(i int, result1 error) {
+	fake.verifyKilnfileLockMutex.Lock()
+	defer fake.verifyKilnfileLockMutex.Unlock()
+	fake.VerifyKilnfileLockStub = nil
+	if fake.verifyKilnfileLockReturnsOnCall == nil {
+		fake.verifyKilnfileLockReturnsOnCall = make(map[int]struct {
+			result1 error
+		})
+	}
+	fake.verifyKilnfileLockReturnsOnCall[i] = struct {
+		result1 error
+	}{result1}
+}
+
 func (fake *ReleasesService) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.fromDirectoriesMutex.RLock()
 	defer fake.fromDirectoriesMutex.RUnlock()
+	fake.fromKilnfileMutex.RLock()
+	defer fake.fromKilnfileMutex.RUnlock()
+	fake.verifyKilnfileLockMutex.RLock()
+	defer fake.verifyKilnfileLockMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
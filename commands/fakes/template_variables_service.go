@@ -6,25 +6,28 @@ import (
 )
 
 type TemplateVariablesService struct {
-	FromPathsAndPairsStub        func([]string, []string) (map[string]interface{}, error)
-	fromPathsAndPairsMutex       sync.RWMutex
-	fromPathsAndPairsArgsForCall []struct {
+	ReadStub        func([]string, []string, []string) (map[string]interface{}, map[string]string, error)
+	readMutex       sync.RWMutex
+	readArgsForCall []struct {
 		arg1 []string
 		arg2 []string
+		arg3 []string
 	}
-	fromPathsAndPairsReturns struct {
+	readReturns struct {
 		result1 map[string]interface{}
-		result2 error
+		result2 map[string]string
+		result3 error
 	}
-	fromPathsAndPairsReturnsOnCall map[int]struct {
+	readReturnsOnCall map[int]struct {
 		result1 map[string]interface{}
-		result2 error
+		result2 map[string]string
+		result3 error
 	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *TemplateVariablesService) FromPathsAndPairs(arg1 []string, arg2 []string) (map[string]interface{}, error) {
+func (fake *TemplateVariablesService) Read(arg1 []string, arg2 []string, arg3 []string) (map[string]interface{}, map[string]string, error) {
 	var arg1Copy []string
 	if arg1 != nil {
 		arg1Copy = make([]string, len(arg1))
@@ -35,74 +38,83 @@ func (fake *TemplateVariablesService) FromPathsAndPairs(arg1 []string, arg2 []st
 		arg2Copy = make([]string, len(arg2))
 		copy(arg2Copy, arg2)
 	}
-	fake.fromPathsAndPairsMutex.Lock()
-	ret, specificReturn := fake.fromPathsAndPairsReturnsOnCall[len(fake.fromPathsAndPairsArgsForCall)]
-	fake.fromPathsAndPairsArgsForCall = append(fake.fromPathsAndPairsArgsForCall, struct {
+	var arg3Copy []string
+	if arg3 != nil {
+		arg3Copy = make([]string, len(arg3))
+		copy(arg3Copy, arg3)
+	}
+	fake.readMutex.Lock()
+	ret, specificReturn := fake.readReturnsOnCall[len(fake.readArgsForCall)]
+	fake.readArgsForCall = append(fake.readArgsForCall, struct {
 		arg1 []string
 		arg2 []string
-	}{arg1Copy, arg2Copy})
-	fake.recordInvocation("FromPathsAndPairs", []interface{}{arg1Copy, arg2Copy})
-	fake.fromPathsAndPairsMutex.Unlock()
-	if fake.FromPathsAndPairsStub != nil {
-		return fake.FromPathsAndPairsStub(arg1, arg2)
+		arg3 []string
+	}{arg1Copy, arg2Copy, arg3Copy})
+	fake.recordInvocation("Read", []interface{}{arg1Copy, arg2Copy, arg3Copy})
+	fake.readMutex.Unlock()
+	if fake.ReadStub != nil {
+		return fake.ReadStub(arg1, arg2, arg3)
 	}
 	if specificReturn {
-		return ret.result1, ret.result2
+		return ret.result1, ret.result2, ret.result3
 	}
-	fakeReturns := fake.fromPathsAndPairsReturns
-	return fakeReturns.result1, fakeReturns.result2
+	fakeReturns := fake.readReturns
+	return fakeReturns.result1, fakeReturns.result2, fakeReturns.result3
 }
 
-func (fake *TemplateVariablesService) FromPathsAndPairsCallCount() int {
-	fake.fromPathsAndPairsMutex.RLock()
-	defer fake.fromPathsAndPairsMutex.RUnlock()
-	return len(fake.fromPathsAndPairsArgsForCall)
+func (fake *TemplateVariablesService) ReadCallCount() int {
+	fake.readMutex.RLock()
+	defer fake.readMutex.RUnlock()
+	return len(fake.readArgsForCall)
 }
 
-func (fake *TemplateVariablesService) FromPathsAndPairsCalls(stub func([]string, []string) (map[string]interface{}, error)) {
-	fake.fromPathsAndPairsMutex.Lock()
-	defer fake.fromPathsAndPairsMutex.Unlock()
-	fake.FromPathsAndPairsStub = stub
+func (fake *TemplateVariablesService) ReadCalls(stub func([]string, []string, []string) (map[string]interface{}, map[string]string, error)) {
+	fake.readMutex.Lock()
+	defer fake.readMutex.Unlock()
+	fake.ReadStub = stub
 }
 
-func (fake *TemplateVariablesService) FromPathsAndPairsArgsForCall(i int) ([]string, []string) {
-	fake.fromPathsAndPairsMutex.RLock()
-	defer fake.fromPathsAndPairsMutex.RUnlock()
-	argsForCall := fake.fromPathsAndPairsArgsForCall[i]
-	return argsForCall.arg1, argsForCall.arg2
+func (fake *TemplateVariablesService) ReadArgsForCall(i int) ([]string, []string, []string) {
+	fake.readMutex.RLock()
+	defer fake.readMutex.RUnlock()
+	argsForCall := fake.readArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
 }
 
-func (fake *TemplateVariablesService) FromPathsAndPairsReturns(result1 map[string]interface{}, result2 error) {
-	fake.fromPathsAndPairsMutex.Lock()
-	defer fake.fromPathsAndPairsMutex.Unlock()
-	fake.FromPathsAndPairsStub = nil
-	fake.fromPathsAndPairsReturns = struct {
+func (fake *TemplateVariablesService) ReadReturns(result1 map[string]interface{}, result2 map[string]string, result3 error) {
+	fake.readMutex.Lock()
+	defer fake.readMutex.Unlock()
+	fake.ReadStub = nil
+	fake.readReturns = struct {
 		result1 map[string]interface{}
-		result2 error
-	}{result1, result2}
+		result2 map[string]string
+		result3 error
+	}{result1, result2, result3}
 }
 
-func (fake *TemplateVariablesService) FromPathsAndPairsReturnsOnCall(i int, result1 map[string]interface{}, result2 error) {
-	fake.fromPathsAndPairsMutex.Lock()
-	defer fake.fromPathsAndPairsMutex.Unlock()
-	fake.FromPathsAndPairsStub = nil
-	if fake.fromPathsAndPairsReturnsOnCall == nil {
-		fake.fromPathsAndPairsReturnsOnCall = make(map[int]struct {
+func (fake *TemplateVariablesService) ReadReturnsOnCall(i int, result1 map[string]interface{}, result2 map[string]string, result3 error) {
+	fake.readMutex.Lock()
+	defer fake.readMutex.Unlock()
+	fake.ReadStub = nil
+	if fake.readReturnsOnCall == nil {
+		fake.readReturnsOnCall = make(map[int]struct {
 			result1 map[string]interface{}
-			result2 error
+			result2 map[string]string
+			result3 error
 		})
 	}
-	fake.fromPathsAndPairsReturnsOnCall[i] = struct {
+	fake.readReturnsOnCall[i] = struct {
 		result1 map[string]interface{}
-		result2 error
-	}{result1, result2}
+		result2 map[string]string
+		result3 error
+	}{result1, result2, result3}
 }
 
 func (fake *TemplateVariablesService) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
-	fake.fromPathsAndPairsMutex.RLock()
-	defer fake.fromPathsAndPairsMutex.RUnlock()
+	fake.readMutex.RLock()
+	defer fake.readMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
 	for key, value := range fake.invocations {
 		copiedInvocations[key] = value
@@ -2,18 +2,17 @@ package commands
 
 import (
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 
 	"github.com/pivotal-cf/kiln/fetcher"
+	"gopkg.in/src-d/go-billy.v4/osfs"
 
 	"github.com/pivotal-cf/jhanda"
 	"github.com/pivotal-cf/kiln/builder"
 	"github.com/pivotal-cf/kiln/internal/baking"
 	"github.com/pivotal-cf/kiln/internal/cargo"
-	"gopkg.in/yaml.v2"
 )
 
 type multipleError []error
@@ -59,10 +58,13 @@ type Fetch struct {
 		Kilnfile    string `short:"kf" long:"kilnfile" default:"Kilnfile" description:"path to Kilnfile"`
 		ReleasesDir string `short:"rd" long:"releases-directory" default:"releases" description:"path to a directory to download releases into"`
 
-		VariablesFiles  []string `short:"vf" long:"variables-file" description:"path to variables file"`
-		Variables       []string `short:"vr" long:"variable" description:"variable in key=value format"`
-		DownloadThreads int      `short:"dt" long:"download-threads" description:"number of parallel threads to download parts from S3"`
-		NoConfirm       bool     `short:"n" long:"no-confirm" description:"non-interactive mode, will delete extra releases in releases dir without prompting"`
+		VariablesFiles   []string `short:"vf" long:"variables-file" description:"path to variables file"`
+		Variables        []string `short:"vr" long:"variable" description:"variable in key=value format"`
+		VariablesYAML    []string `short:"vy" long:"variable-yaml" description:"variable in key=value format, with the value parsed as YAML"`
+		SecretsDirectory string   `short:"sc" long:"secrets-directory" description:"path to a directory with a file per secret for the secret helper"`
+		SecretsHelper    string   `short:"sh" long:"secrets-helper" description:"command of a credential helper for the secret helper"`
+		DownloadThreads  int      `short:"dt" long:"download-threads" description:"number of parallel threads to download parts from S3"`
+		NoConfirm        bool     `short:"n" long:"no-confirm" description:"non-interactive mode, will delete extra releases in releases dir without prompting"`
 	}
 }
 
@@ -101,44 +103,35 @@ func (f Fetch) Execute(args []string) error {
 	}
 
 	templateVariablesService := baking.NewTemplateVariablesService()
-	templateVariables, err := templateVariablesService.FromPathsAndPairs(f.Options.VariablesFiles, f.Options.Variables)
+	templateVariables, _, err := templateVariablesService.Read(f.Options.VariablesFiles, f.Options.Variables, f.Options.VariablesYAML)
 	if err != nil {
 		return fmt.Errorf("failed to parse template variables: %s", err)
 	}
 
-	kilnfileYAML, err := ioutil.ReadFile(f.Options.Kilnfile)
-	if err != nil {
-		return err
-	}
-
-	interpolator := builder.NewInterpolator()
-	interpolatedMetadata, err := interpolator.Interpolate(builder.InterpolateInput{
-		Variables: templateVariables,
-	}, kilnfileYAML)
-	if err != nil {
-		return ConfigFileError{err: err, HumanReadableConfigFileName: "interpolating variable files with Kilnfile"}
-	}
-
 	f.logger.Println("getting release information from " + f.Options.Kilnfile)
 
-	var kilnfile cargo.Kilnfile
-	err = yaml.Unmarshal(interpolatedMetadata, &kilnfile)
+	secretProviders := builder.NewSecretProviders(f.Options.SecretsDirectory, f.Options.SecretsHelper)
+	kilnfileLoader := cargo.NewKilnfileLoader(osfs.New(""), builder.NewKilnfileInterpolator(secretProviders))
+	kilnfile, err := kilnfileLoader.Load(f.Options.Kilnfile, templateVariables)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return err
+		}
 		return ConfigFileError{err: err, HumanReadableConfigFileName: "Kilnfile specification " + f.Options.Kilnfile}
 	}
 
-	f.logger.Println("getting release information from Kilnfile.lock")
-	lockFileName := fmt.Sprintf("%s.lock", f.Options.Kilnfile)
-	lockFile, err := os.Open(lockFileName)
+	err = kilnfile.ValidateReleaseSources()
 	if err != nil {
-		return err
+		return ConfigFileError{err: err, HumanReadableConfigFileName: "Kilnfile specification " + f.Options.Kilnfile}
 	}
-	defer lockFile.Close()
 
-	var kilnfileLock cargo.KilnfileLock
-	err = yaml.NewDecoder(lockFile).Decode(&kilnfileLock)
+	f.logger.Println("getting release information from Kilnfile.lock")
+	kilnfileLock, err := kilnfileLoader.LoadLock(f.Options.Kilnfile)
 	if err != nil {
-		return ConfigFileError{err: err, HumanReadableConfigFileName: "Kilnfile.lock " + lockFileName}
+		if os.IsNotExist(err) {
+			return err
+		}
+		return ConfigFileError{err: err, HumanReadableConfigFileName: "Kilnfile.lock " + f.Options.Kilnfile + ".lock"}
 	}
 
 	availableLocalReleaseSet, err := f.localReleaseDirectory.GetLocalReleases(f.Options.ReleasesDir)
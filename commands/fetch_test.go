@@ -12,6 +12,7 @@ import (
 
 	"github.com/pivotal-cf/jhanda"
 	"github.com/pivotal-cf/kiln/fetcher"
+	"github.com/pivotal-cf/kiln/internal/cargo"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -515,6 +516,39 @@ release_sources:
 				})
 			})
 
+			Context("when the Kilnfile extends a base Kilnfile", func() {
+				BeforeEach(func() {
+					Expect(os.MkdirAll(filepath.Join(tmpDir, "base"), 0755)).To(Succeed())
+					Expect(ioutil.WriteFile(filepath.Join(tmpDir, "base", "Kilnfile"), []byte("release_sources:\n- type: bosh.io\n"), 0644)).To(Succeed())
+					Expect(ioutil.WriteFile(someKilnfilePath, []byte("extends: base/Kilnfile\nslug: some-slug\n"), 0644)).To(Succeed())
+				})
+
+				It("passes the merged Kilnfile to the release sources factory", func() {
+					Expect(fetchExecuteErr).NotTo(HaveOccurred())
+					Expect(releaseSourcesFactory.ReleaseSourcesArgsForCall(0)).To(Equal(cargo.Kilnfile{
+						Slug:           "some-slug",
+						ReleaseSources: []cargo.ReleaseSourceConfig{{Type: "bosh.io"}},
+					}))
+				})
+			})
+
+			Context("when a variable is given as YAML", func() {
+				BeforeEach(func() {
+					Expect(ioutil.WriteFile(someKilnfilePath, []byte("release_sources:\n- type: bosh.io\npre_ga_user_groups: $( variable \"groups\" )\n"), 0644)).To(Succeed())
+
+					fetchExecuteArgs = []string{
+						"--releases-directory", someReleasesDirectory,
+						"--kilnfile", someKilnfilePath,
+						"--variable-yaml", "groups=[some-group, other-group]",
+					}
+				})
+
+				It("interpolates the parsed value", func() {
+					Expect(fetchExecuteErr).NotTo(HaveOccurred())
+					Expect(releaseSourcesFactory.ReleaseSourcesArgsForCall(0).PreGaUserGroups).To(Equal([]string{"some-group", "other-group"}))
+				})
+			})
+
 			Context("when # of download threads is specified", func() {
 				BeforeEach(func() {
 					fetchExecuteArgs = []string{
@@ -542,6 +576,21 @@ release_sources:
 						Expect(err).To(MatchError(fmt.Sprintf("open %s: no such file or directory", badKilnfilePath)))
 					})
 				})
+				Context("the Kilnfile has an unknown key", func() {
+					It("returns an error", func() {
+						Expect(ioutil.WriteFile(someKilnfilePath, []byte("relase_sources: []\n"), 0644)).To(Succeed())
+						err := fetch.Execute(fetchExecuteArgs)
+						Expect(err).To(MatchError(ContainSubstring("field relase_sources not found in type cargo.Kilnfile")))
+					})
+				})
+				Context("an s3 release source is incomplete", func() {
+					It("returns an error", func() {
+						Expect(ioutil.WriteFile(someKilnfilePath, []byte("release_sources:\n- type: s3\n  region: us-west-1\n"), 0644)).To(Succeed())
+						err := fetch.Execute(fetchExecuteArgs)
+						Expect(err).To(MatchError(ContainSubstring("release_sources[0]: bucket is required for s3 release sources")))
+						Expect(releaseSourcesFactory.ReleaseSourcesCallCount()).To(Equal(1)) // only the call from JustBeforeEach
+					})
+				})
 				Context("# of download threads is not a number", func() {
 					It("returns an error", func() {
 						err := fetch.Execute([]string{
@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/jhanda"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/util"
+)
+
+// tileLayoutDirectories are the directories `kiln bake` picks up when run
+// without flags, in the order `kiln init` creates them.
+var tileLayoutDirectories = []string{
+	"bosh-variables",
+	"forms",
+	"instance-groups",
+	"jobs",
+	"migrations",
+	"properties",
+	"releases",
+	"runtime-configs",
+}
+
+// Init scaffolds a new tile in the layout `kiln bake` discovers on its own.
+type Init struct {
+	logger *log.Logger
+	fs     billy.Filesystem
+
+	Options struct {
+		Directory       string `short:"d" long:"directory"        default:"."             description:"path to the directory to create the tile in"`
+		Name            string `short:"n" long:"name"                                     description:"name of the tile (defaults to the directory name)"`
+		StemcellOS      string `          long:"stemcell-os"      default:"ubuntu-xenial" description:"operating system of the stemcell the tile runs on"`
+		StemcellVersion string `          long:"stemcell-version" default:"621.0"         description:"version of the stemcell to lock"`
+	}
+}
+
+func NewInit(logger *log.Logger, fs billy.Filesystem) Init {
+	return Init{
+		logger: logger,
+		fs:     fs,
+	}
+}
+
+func (i Init) Execute(args []string) error {
+	_, err := jhanda.Parse(&i.Options, args)
+	if err != nil {
+		return err
+	}
+
+	name := i.Options.Name
+	if name == "" {
+		directory, err := filepath.Abs(i.Options.Directory)
+		if err != nil {
+			return err
+		}
+		name = filepath.Base(directory)
+	}
+
+	icon, err := placeholderIcon()
+	if err != nil {
+		return err
+	}
+
+	files := []struct {
+		name     string
+		contents []byte
+	}{
+		{tileLayoutMetadata, []byte(fmt.Sprintf(initBaseYML, name))},
+		{tileLayoutKilnfile, []byte(fmt.Sprintf(initKilnfile, i.Options.StemcellOS, i.Options.StemcellVersion))},
+		{tileLayoutKilnfile + ".lock", []byte(fmt.Sprintf(initKilnfileLock, i.Options.StemcellOS, i.Options.StemcellVersion))},
+		{tileLayoutVersion, []byte("0.1.0\n")},
+		{"icon.png", icon},
+	}
+
+	var existing []string
+	for _, file := range files {
+		_, err := i.fs.Stat(filepath.Join(i.Options.Directory, file.name))
+		if err == nil {
+			existing = append(existing, file.name)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("refusing to overwrite existing files in %s: %s", i.Options.Directory, strings.Join(existing, ", "))
+	}
+
+	for _, directory := range tileLayoutDirectories {
+		path := filepath.Join(i.Options.Directory, directory)
+		err := i.fs.MkdirAll(path, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %s", path, err)
+		}
+		i.logger.Printf("created %s/", path)
+	}
+
+	for _, file := range files {
+		path := filepath.Join(i.Options.Directory, file.name)
+		err := util.WriteFile(i.fs, path, file.contents, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %s", path, err)
+		}
+		i.logger.Printf("created %s", path)
+	}
+
+	i.logger.Printf("run `kiln bake` in %s to build the tile", i.Options.Directory)
+
+	return nil
+}
+
+func (i Init) Usage() jhanda.Usage {
+	return jhanda.Usage{
+		Description:      "Creates base.yml, a Kilnfile, Kilnfile.lock, version, icon.png and the part directories for a new tile, so that `kiln bake` can build it without flags.",
+		ShortDescription: "scaffolds a new tile",
+		Flags:            i.Options,
+	}
+}
+
+func placeholderIcon() ([]byte, error) {
+	var icon bytes.Buffer
+	err := png.Encode(&icon, image.NewNRGBA(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		return nil, err // should never happen
+	}
+	return icon.Bytes(), nil
+}
+
+const initBaseYML = `---
+name: %[1]s
+label: %[1]s
+description: Describe the %[1]s tile here.
+
+icon_image: $( icon )
+
+metadata_version: "2.7"
+minimum_version_for_upgrade: 0.1.0
+product_version: $( version )
+provides_product_versions:
+- name: %[1]s
+  version: $( version )
+
+rank: 90
+serial: false
+
+stemcell_criteria: $( stemcell )
+
+releases: []
+form_types: []
+job_types: []
+property_blueprints: []
+runtime_configs: []
+variables: []
+`
+
+const initKilnfile = `---
+release_sources: []
+stemcell_criteria:
+  os: %s
+  version: "~%s"
+`
+
+const initKilnfileLock = `---
+releases: []
+stemcell_criteria:
+  os: %s
+  version: "%s"
+`
@@ -0,0 +1,105 @@
+package commands_test
+
+import (
+	"io/ioutil"
+	"log"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	"github.com/pivotal-cf/jhanda"
+	"github.com/pivotal-cf/kiln/commands"
+	"github.com/pivotal-cf/kiln/internal/cargo"
+)
+
+var _ = Describe("Init", func() {
+	var _ jhanda.Command = commands.Init{}
+
+	var (
+		fs          billy.Filesystem
+		output      *gbytes.Buffer
+		initCommand commands.Init
+	)
+
+	BeforeEach(func() {
+		fs = memfs.New()
+		output = gbytes.NewBuffer()
+		initCommand = commands.NewInit(log.New(output, "", 0), fs)
+	})
+
+	It("creates the standard tile layout", func() {
+		Expect(initCommand.Execute([]string{"--directory", "some-tile"})).To(Succeed())
+
+		for _, directory := range []string{"bosh-variables", "forms", "instance-groups", "jobs", "migrations", "properties", "releases", "runtime-configs"} {
+			info, err := fs.Stat("some-tile/" + directory)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.IsDir()).To(BeTrue())
+		}
+
+		baseYML, err := readFile(fs, "some-tile/base.yml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(baseYML)).To(ContainSubstring("name: some-tile\n"))
+		Expect(string(baseYML)).To(ContainSubstring("product_version: $( version )\n"))
+
+		version, err := readFile(fs, "some-tile/version")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(version)).To(Equal("0.1.0\n"))
+
+		_, err = fs.Stat("some-tile/icon.png")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(output).To(gbytes.Say("created some-tile/bosh-variables/"))
+		Expect(output).To(gbytes.Say("run `kiln bake` in some-tile to build the tile"))
+	})
+
+	It("writes a valid Kilnfile and Kilnfile.lock for the stemcell", func() {
+		Expect(initCommand.Execute([]string{"--directory", "some-tile", "--name", "other-name", "--stemcell-os", "some-os", "--stemcell-version", "1.2"})).To(Succeed())
+
+		kilnfileYAML, err := readFile(fs, "some-tile/Kilnfile")
+		Expect(err).NotTo(HaveOccurred())
+		kilnfile, err := cargo.ParseKilnfile(kilnfileYAML)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kilnfile.Validate()).To(Succeed())
+		Expect(kilnfile.Stemcell).To(Equal(cargo.Stemcell{OS: "some-os", Version: "~1.2"}))
+
+		lockYAML, err := readFile(fs, "some-tile/Kilnfile.lock")
+		Expect(err).NotTo(HaveOccurred())
+		lock, err := cargo.ParseKilnfileLock(lockYAML)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Validate()).To(Succeed())
+		Expect(lock.Stemcell).To(Equal(cargo.Stemcell{OS: "some-os", Version: "1.2"}))
+
+		baseYML, err := readFile(fs, "some-tile/base.yml")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(baseYML)).To(ContainSubstring("name: other-name\n"))
+	})
+
+	When("the tile files already exist", func() {
+		BeforeEach(func() {
+			Expect(util.WriteFile(fs, "some-tile/base.yml", []byte("some-metadata"), 0644)).To(Succeed())
+		})
+
+		It("does not overwrite them", func() {
+			err := initCommand.Execute([]string{"--directory", "some-tile"})
+			Expect(err).To(MatchError("refusing to overwrite existing files in some-tile: base.yml"))
+
+			baseYML, err := readFile(fs, "some-tile/base.yml")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(baseYML)).To(Equal("some-metadata"))
+		})
+	})
+})
+
+func readFile(fs billy.Filesystem, path string) ([]byte, error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/jhanda"
+)
+
+// Lock groups the subcommands that work with Kilnfile.lock files,
+// for example `kiln lock diff`.
+type Lock struct {
+	subcommands jhanda.CommandSet
+}
+
+func NewLock(subcommands jhanda.CommandSet) Lock {
+	return Lock{
+		subcommands: subcommands,
+	}
+}
+
+func (l Lock) Execute(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing subcommand, expected one of: %s", strings.Join(l.subcommandNames(), ", "))
+	}
+
+	return l.subcommands.Execute(args[0], args[1:])
+}
+
+func (l Lock) Usage() jhanda.Usage {
+	var description strings.Builder
+	description.WriteString("Works with Kilnfile.lock files.\n\nSubcommands:")
+	for _, name := range l.subcommandNames() {
+		usage := l.subcommands[name].Usage()
+		fmt.Fprintf(&description, "\n\n  kiln lock %s\n  %s", name, usage.Description)
+		if usage.Flags == nil {
+			continue
+		}
+		flags, err := jhanda.PrintUsage(usage.Flags)
+		if err != nil {
+			continue // should never happen
+		}
+		for _, flag := range strings.Split(strings.TrimSpace(flags), "\n") {
+			description.WriteString("\n    " + flag)
+		}
+	}
+
+	return jhanda.Usage{
+		Description:      description.String(),
+		ShortDescription: "works with Kilnfile.lock files",
+	}
+}
+
+func (l Lock) subcommandNames() []string {
+	var names []string
+	for name := range l.subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
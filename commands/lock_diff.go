@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/jhanda"
+	"github.com/pivotal-cf/kiln/internal/cargo"
+)
+
+// LockDiff reports the release and stemcell changes between two Kilnfile.lock files.
+type LockDiff struct {
+	logger *log.Logger
+
+	Options struct {
+		Revision string `short:"r" long:"revision" description:"git revision of the lock file to compare with the working copy"`
+		Format   string `short:"f" long:"format" default:"text" description:"output format: text, json or markdown"`
+	}
+}
+
+func NewLockDiff(logger *log.Logger) LockDiff {
+	return LockDiff{
+		logger: logger,
+	}
+}
+
+func (d LockDiff) Execute(args []string) error {
+	paths, err := jhanda.Parse(&d.Options, args)
+	if err != nil {
+		return err
+	}
+
+	var oldLockYAML []byte
+	var oldName, newName string
+	if d.Options.Revision != "" {
+		if len(paths) > 1 {
+			return errors.New("expected at most one lock file path when --revision is set")
+		}
+		path := "Kilnfile.lock"
+		if len(paths) == 1 {
+			path = paths[0]
+		}
+
+		oldName, newName = fmt.Sprintf("%s at %s", path, d.Options.Revision), path
+		oldLockYAML, err = readFileAtRevision(d.Options.Revision, path)
+		if err != nil {
+			return err
+		}
+	} else {
+		if len(paths) != 2 {
+			return errors.New("expected two lock file paths (or one with --revision)")
+		}
+
+		oldName, newName = paths[0], paths[1]
+		oldLockYAML, err = ioutil.ReadFile(oldName)
+		if err != nil {
+			return err
+		}
+	}
+
+	newLockYAML, err := ioutil.ReadFile(newName)
+	if err != nil {
+		return err
+	}
+
+	oldLock, err := parseKilnfileLock(oldName, oldLockYAML)
+	if err != nil {
+		return err
+	}
+
+	newLock, err := parseKilnfileLock(newName, newLockYAML)
+	if err != nil {
+		return err
+	}
+
+	diff := cargo.DiffKilnfileLocks(oldLock, newLock)
+
+	switch d.Options.Format {
+	case "text":
+		d.logger.Print(diff.String())
+	case "markdown":
+		d.logger.Print(diff.Markdown())
+	case "json":
+		diffJSON, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return err // should never happen
+		}
+		d.logger.Printf("%s", diffJSON)
+	default:
+		return fmt.Errorf("unknown format %q, expected text, json or markdown", d.Options.Format)
+	}
+
+	return nil
+}
+
+func (d LockDiff) Usage() jhanda.Usage {
+	return jhanda.Usage{
+		Description:      "Compares two Kilnfile.lock files (kiln lock diff OLD NEW) or a lock file with its state at a git revision (kiln lock diff --revision REV [PATH]) and reports release and stemcell changes.",
+		ShortDescription: "compares Kilnfile.lock files",
+		Flags:            d.Options,
+	}
+}
+
+func parseKilnfileLock(name string, lockYAML []byte) (cargo.KilnfileLock, error) {
+	lock, err := cargo.ParseKilnfileLock(lockYAML)
+	if err != nil {
+		return cargo.KilnfileLock{}, fmt.Errorf("could not parse yaml in %s: %s", name, err)
+	}
+	return lock, nil
+}
+
+func readFileAtRevision(revision, path string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "show", revision+":./"+filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	cmd.Stderr = &stderr
+
+	contents, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s at revision %s: %s", path, revision, strings.TrimSpace(stderr.String()))
+	}
+
+	return contents, nil
+}
@@ -0,0 +1,175 @@
+package commands_test
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+
+	"github.com/pivotal-cf/jhanda"
+	"github.com/pivotal-cf/kiln/commands"
+)
+
+var _ = Describe("LockDiff", func() {
+	var _ jhanda.Command = commands.LockDiff{}
+
+	var (
+		tmpDir                   string
+		oldLockPath, newLockPath string
+		output                   *gbytes.Buffer
+		lockDiff                 commands.LockDiff
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lock-diff-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		oldLockPath = filepath.Join(tmpDir, "old.lock")
+		newLockPath = filepath.Join(tmpDir, "Kilnfile.lock")
+		Expect(ioutil.WriteFile(oldLockPath, []byte(oldLockDiffContents), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(newLockPath, []byte(newLockDiffContents), 0644)).To(Succeed())
+
+		output = gbytes.NewBuffer()
+		lockDiff = commands.NewLockDiff(log.New(output, "", 0))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("prints the changes as text", func() {
+		Expect(lockDiff.Execute([]string{oldLockPath, newLockPath})).To(Succeed())
+		Expect(string(output.Contents())).To(Equal(
+			"Stemcells:\n" +
+				"  ~ ubuntu-xenial 621.71 -> 621.74 (minor upgrade)\n" +
+				"Releases:\n" +
+				"  ~ bpm 1.1.0 -> 2.0.0 (major upgrade)\n" +
+				"  - capi 1.80.0\n" +
+				"  + uaa 74.0.0\n",
+		))
+	})
+
+	It("prints the changes as json", func() {
+		Expect(lockDiff.Execute([]string{"--format", "json", oldLockPath, newLockPath})).To(Succeed())
+		Expect(output.Contents()).To(MatchJSON(`{
+			"stemcells": [{"os": "ubuntu-xenial", "change": "upgraded", "level": "minor", "old_version": "621.71", "new_version": "621.74"}],
+			"releases": [
+				{"name": "bpm", "change": "upgraded", "level": "major", "old_version": "1.1.0", "new_version": "2.0.0", "old_sha1": "a", "new_sha1": "b"},
+				{"name": "capi", "change": "removed", "old_version": "1.80.0", "old_sha1": "c"},
+				{"name": "uaa", "change": "added", "new_version": "74.0.0", "new_sha1": "d"}
+			]
+		}`))
+	})
+
+	It("prints the changes as markdown", func() {
+		Expect(lockDiff.Execute([]string{"--format", "markdown", oldLockPath, newLockPath})).To(Succeed())
+		Expect(output).To(gbytes.Say(`\| bpm \| major upgrade \| 1.1.0 \| 2.0.0 \|`))
+	})
+
+	When("the format is unknown", func() {
+		It("returns an error", func() {
+			Expect(lockDiff.Execute([]string{"--format", "xml", oldLockPath, newLockPath})).To(MatchError(ContainSubstring(`unknown format "xml"`)))
+		})
+	})
+
+	When("one path is given without a revision", func() {
+		It("returns an error", func() {
+			Expect(lockDiff.Execute([]string{newLockPath})).To(MatchError(ContainSubstring("expected two lock file paths")))
+		})
+	})
+
+	When("a lock file is not valid yaml", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(oldLockPath, []byte("{{{"), 0644)).To(Succeed())
+		})
+		It("returns an error", func() {
+			Expect(lockDiff.Execute([]string{oldLockPath, newLockPath})).To(MatchError(ContainSubstring("could not parse yaml in " + oldLockPath)))
+		})
+	})
+
+	When("a git revision is given", func() {
+		BeforeEach(func() {
+			if _, err := exec.LookPath("git"); err != nil {
+				Skip("git is not installed")
+			}
+			Expect(ioutil.WriteFile(newLockPath, []byte(oldLockDiffContents), 0644)).To(Succeed())
+			for _, args := range [][]string{
+				{"init", "-q"},
+				{"add", "Kilnfile.lock"},
+				{"-c", "user.name=kiln", "-c", "user.email=kiln@example.com", "commit", "-q", "-m", "initial"},
+			} {
+				cmd := exec.Command("git", args...)
+				cmd.Dir = tmpDir
+				Expect(cmd.Run()).To(Succeed())
+			}
+			Expect(ioutil.WriteFile(newLockPath, []byte(newLockDiffContents), 0644)).To(Succeed())
+		})
+
+		It("compares the working copy with the lock file at that revision", func() {
+			Expect(lockDiff.Execute([]string{"--revision", "HEAD", newLockPath})).To(Succeed())
+			Expect(output).To(gbytes.Say(`~ bpm 1.1.0 -> 2.0.0`))
+		})
+
+		When("the revision does not exist", func() {
+			It("returns an error", func() {
+				Expect(lockDiff.Execute([]string{"--revision", "no-such-rev", newLockPath})).To(MatchError(ContainSubstring("could not read " + newLockPath + " at revision no-such-rev")))
+			})
+		})
+	})
+})
+
+var _ = Describe("Lock", func() {
+	var _ jhanda.Command = commands.Lock{}
+
+	It("runs the named subcommand", func() {
+		output := gbytes.NewBuffer()
+		lock := commands.NewLock(jhanda.CommandSet{"diff": commands.NewLockDiff(log.New(output, "", 0))})
+		Expect(lock.Execute([]string{"diff", "missing.lock", "missing.lock"})).To(MatchError(ContainSubstring(`could not execute "diff"`)))
+	})
+
+	It("lists its subcommands in the usage", func() {
+		lock := commands.NewLock(jhanda.CommandSet{"diff": commands.LockDiff{}})
+		Expect(lock.Usage().Description).To(ContainSubstring("kiln lock diff"))
+		Expect(lock.Usage().Description).To(ContainSubstring("--revision"))
+	})
+
+	When("no subcommand is given", func() {
+		It("returns an error", func() {
+			lock := commands.NewLock(jhanda.CommandSet{"diff": commands.LockDiff{}})
+			Expect(lock.Execute(nil)).To(MatchError("missing subcommand, expected one of: diff"))
+		})
+	})
+})
+
+const (
+	oldLockDiffContents = `---
+releases:
+- name: bpm
+  sha1: a
+  version: 1.1.0
+- name: capi
+  sha1: c
+  version: 1.80.0
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.71"
+`
+	newLockDiffContents = `---
+releases:
+- name: uaa
+  sha1: d
+  version: 74.0.0
+- name: bpm
+  sha1: b
+  version: 2.0.0
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.74"
+`
+)
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/pivotal-cf/jhanda"
+	"github.com/pivotal-cf/kiln/internal/cargo"
+)
+
+// ErrorLockMergeConflicts is returned by `kiln lock merge` when releases or
+// stemcells could not be merged. The non-zero exit status tells git the merge
+// needs to be resolved by hand.
+type ErrorLockMergeConflicts []cargo.MergeConflict
+
+func (conflicts ErrorLockMergeConflicts) Error() string {
+	lines := []string{"could not merge the following Kilnfile.lock entries"}
+	for _, conflict := range conflicts {
+		lines = append(lines, "- "+conflict.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// LockMerge is a three way merge for Kilnfile.lock files that can be
+// registered as a git merge driver.
+type LockMerge struct {
+	logger *log.Logger
+
+	Options struct {
+		Output string `short:"o" long:"output" description:"path to write the merged lock file (default: the OURS path, as git merge drivers expect)"`
+	}
+}
+
+func NewLockMerge(logger *log.Logger) LockMerge {
+	return LockMerge{
+		logger: logger,
+	}
+}
+
+func (m LockMerge) Execute(args []string) error {
+	paths, err := jhanda.Parse(&m.Options, args)
+	if err != nil {
+		return err
+	}
+
+	if len(paths) != 3 {
+		return errors.New("expected three lock file paths: BASE OURS THEIRS")
+	}
+
+	var (
+		locks     [3]cargo.KilnfileLock
+		oursYAML  []byte
+		oursIndex = 1
+	)
+	for i, path := range paths {
+		lockYAML, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		locks[i], err = parseKilnfileLock(path, lockYAML)
+		if err != nil {
+			return err
+		}
+
+		if i == oursIndex {
+			oursYAML = lockYAML
+		}
+	}
+
+	merged, conflicts := cargo.MergeKilnfileLocks(locks[0], locks[1], locks[2])
+
+	mergedYAML, err := marshalKilnfileLock(merged, oursYAML)
+	if err != nil {
+		return err
+	}
+
+	output := m.Options.Output
+	if output == "" {
+		output = paths[oursIndex]
+	}
+
+	err = writeKilnfileLock(output, mergedYAML)
+	if err != nil {
+		return err
+	}
+
+	if len(conflicts) > 0 {
+		return ErrorLockMergeConflicts(conflicts)
+	}
+
+	m.logger.Printf("merged %s", output)
+
+	return nil
+}
+
+func (m LockMerge) Usage() jhanda.Usage {
+	return jhanda.Usage{
+		Description:      "Merges Kilnfile.lock files changed on two branches (kiln lock merge BASE OURS THEIRS). Register it as a git merge driver with `kiln lock merge %O %A %B`.",
+		ShortDescription: "merges Kilnfile.lock files",
+		Flags:            m.Options,
+	}
+}
@@ -0,0 +1,173 @@
+package commands_test
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+
+	"github.com/pivotal-cf/jhanda"
+	"github.com/pivotal-cf/kiln/commands"
+)
+
+var _ = Describe("LockMerge", func() {
+	var _ jhanda.Command = commands.LockMerge{}
+
+	var (
+		tmpDir                         string
+		basePath, oursPath, theirsPath string
+		output                         *gbytes.Buffer
+		lockMerge                      commands.LockMerge
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lock-merge-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		basePath = filepath.Join(tmpDir, "base.lock")
+		oursPath = filepath.Join(tmpDir, "ours.lock")
+		theirsPath = filepath.Join(tmpDir, "theirs.lock")
+		Expect(ioutil.WriteFile(basePath, []byte(baseLockMergeContents), 0644)).To(Succeed())
+
+		output = gbytes.NewBuffer()
+		lockMerge = commands.NewLockMerge(log.New(output, "", 0))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	When("the changes do not conflict", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(oursPath, []byte(`---
+releases:
+- name: bpm
+  sha1: b
+  version: 1.1.1
+- name: capi
+  sha1: c
+  version: 1.80.0
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.71"
+custom_key: kept
+`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(theirsPath, []byte(`---
+releases:
+- name: bpm
+  sha1: a
+  version: 1.1.0
+- name: capi
+  sha1: d
+  version: 1.81.0
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.74"
+`), 0644)).To(Succeed())
+		})
+
+		It("writes the merged lock to the ours path", func() {
+			Expect(lockMerge.Execute([]string{basePath, oursPath, theirsPath})).To(Succeed())
+
+			merged, err := ioutil.ReadFile(oursPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(merged)).To(HavePrefix("########### DO NOT EDIT! ############\n"))
+			Expect(string(merged)).To(ContainSubstring(`releases:
+- name: bpm
+  sha1: b
+  version: 1.1.1
+- name: capi
+  sha1: d
+  version: 1.81.0
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.74"
+custom_key: kept
+`))
+			Expect(output).To(gbytes.Say("merged " + oursPath))
+		})
+
+		When("--output is set", func() {
+			It("writes the merged lock there and leaves ours alone", func() {
+				outputPath := filepath.Join(tmpDir, "Kilnfile.lock")
+				Expect(lockMerge.Execute([]string{"--output", outputPath, basePath, oursPath, theirsPath})).To(Succeed())
+
+				merged, err := ioutil.ReadFile(outputPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(merged)).To(ContainSubstring("version: 1.81.0"))
+
+				ours, err := ioutil.ReadFile(oursPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(ours)).NotTo(ContainSubstring("version: 1.81.0"))
+			})
+		})
+	})
+
+	When("both sides locked the same version with different sha1s", func() {
+		BeforeEach(func() {
+			Expect(ioutil.WriteFile(oursPath, []byte(`releases:
+- name: capi
+  sha1: c
+  version: 1.81.0
+`), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(theirsPath, []byte(`releases:
+- name: capi
+  sha1: d
+  version: 1.81.0
+`), 0644)).To(Succeed())
+		})
+
+		It("writes ours and returns the conflicts", func() {
+			err := lockMerge.Execute([]string{basePath, oursPath, theirsPath})
+			Expect(err).To(BeAssignableToTypeOf(commands.ErrorLockMergeConflicts{}))
+			Expect(err.(commands.ErrorLockMergeConflicts)).To(HaveLen(1))
+			Expect(err).To(MatchError(ContainSubstring("could not merge the following Kilnfile.lock entries")))
+			Expect(err).To(MatchError(ContainSubstring(`- release "capi": both sides have the same version with different sha1s`)))
+		})
+	})
+
+	When("the base is empty", func() {
+		It("merges the releases both sides added", func() {
+			Expect(ioutil.WriteFile(basePath, nil, 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(oursPath, []byte("releases:\n- name: bpm\n  sha1: a\n  version: 1.1.0\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(theirsPath, []byte("releases:\n- name: capi\n  sha1: c\n  version: 1.80.0\n"), 0644)).To(Succeed())
+
+			Expect(lockMerge.Execute([]string{basePath, oursPath, theirsPath})).To(Succeed())
+
+			merged, err := ioutil.ReadFile(oursPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(merged)).To(ContainSubstring("- name: bpm"))
+			Expect(string(merged)).To(ContainSubstring("- name: capi"))
+		})
+	})
+
+	When("there are not three paths", func() {
+		It("returns an error", func() {
+			Expect(lockMerge.Execute([]string{basePath, oursPath})).To(MatchError("expected three lock file paths: BASE OURS THEIRS"))
+		})
+	})
+
+	When("a lock file does not exist", func() {
+		It("returns an error", func() {
+			Expect(lockMerge.Execute([]string{basePath, oursPath, theirsPath})).To(MatchError(ContainSubstring("no such file or directory")))
+		})
+	})
+})
+
+const baseLockMergeContents = `---
+releases:
+- name: bpm
+  sha1: a
+  version: 1.1.0
+- name: capi
+  sha1: c
+  version: 1.80.0
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.71"
+`
@@ -18,7 +18,6 @@ import (
 	"github.com/pivotal-cf/go-pivnet/v2/logshim"
 	"github.com/pivotal-cf/jhanda"
 	"gopkg.in/src-d/go-billy.v4"
-	"gopkg.in/yaml.v2"
 )
 
 const (
@@ -142,14 +141,11 @@ func (p *Publish) parseArgsAndSetup(args []string) (cargo.Kilnfile, *semver.Vers
 		return cargo.Kilnfile{}, nil, err
 	}
 
-	file, err := p.FS.Open(p.Options.Kilnfile)
+	kilnfile, err := cargo.NewKilnfileLoader(p.FS, cargo.NoInterpolation{}).Load(p.Options.Kilnfile, nil)
 	if err != nil {
-		return cargo.Kilnfile{}, nil, err
-	}
-	defer file.Close()
-
-	var kilnfile cargo.Kilnfile
-	if err := yaml.NewDecoder(file).Decode(&kilnfile); err != nil {
+		if os.IsNotExist(err) {
+			return cargo.Kilnfile{}, nil, err
+		}
 		return cargo.Kilnfile{}, nil, fmt.Errorf("could not parse Kilnfile: %s", err)
 	}
 
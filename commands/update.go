@@ -4,15 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
 	"github.com/Masterminds/semver"
 	"github.com/pivotal-cf/jhanda"
 	"github.com/pivotal-cf/kiln/builder"
+	"github.com/pivotal-cf/kiln/fetcher"
+	"github.com/pivotal-cf/kiln/helper"
 	"github.com/pivotal-cf/kiln/internal/baking"
 	"github.com/pivotal-cf/kiln/internal/cargo"
+	"gopkg.in/src-d/go-billy.v4/osfs"
 	"gopkg.in/yaml.v2"
 )
 
@@ -25,15 +30,28 @@ const (
 // Update wraps the dependancies and flag options for the `kiln update` command
 type Update struct {
 	Options struct {
-		Kilnfile       string   `short:"kf" long:"kilnfile" required:"true" description:"path to Kilnfile"`
-		VariablesFiles []string `short:"vf" long:"variables-file" description:"path to variables file"`
-		Variables      []string `short:"vr" long:"variable" description:"variable in key=value format"`
-		PivNetToken    string   `short:"pt" env:"PIVOTAL_NETWORK_API_TOKEN" long:"pivotal-network-token" description:"uaa access token for network.pivotal.io"`
+		Kilnfile         string   `short:"kf" long:"kilnfile" required:"true" description:"path to Kilnfile"`
+		VariablesFiles   []string `short:"vf" long:"variables-file" description:"path to variables file"`
+		Variables        []string `short:"vr" long:"variable" description:"variable in key=value format"`
+		VariablesYAML    []string `short:"vy" long:"variable-yaml" description:"variable in key=value format, with the value parsed as YAML"`
+		SecretsDirectory string   `short:"sc" long:"secrets-directory" description:"path to a directory with a file per secret for the secret helper"`
+		SecretsHelper    string   `short:"sh" long:"secrets-helper" description:"command of a credential helper for the secret helper"`
+		PivNetToken      string   `short:"pt" env:"PIVOTAL_NETWORK_API_TOKEN" long:"pivotal-network-token" description:"uaa access token for network.pivotal.io"`
+		DryRun           bool     `long:"dry-run" description:"print the changes to Kilnfile.lock without writing it"`
+		ExitCode         bool     `long:"exit-code" description:"exit with a non-zero status when Kilnfile.lock has changes"`
 	}
+	OutLogger *log.Logger
+
 	StemcellsVersionsService interface {
 		Versions(string) ([]string, error)
 		SetToken(string)
 	}
+	BOSHIOStemcellsVersionsService interface {
+		Versions(string) ([]string, error)
+	}
+	LocalStemcellsVersionsService interface {
+		Versions(directory, os string) ([]string, error)
+	}
 }
 
 // Execute expects a Kilnfile to exist and be passed as a flag
@@ -45,12 +63,19 @@ func (update Update) Execute(args []string) error {
 
 	update.StemcellsVersionsService.SetToken(update.Options.PivNetToken)
 
-	kilnfileYAML, err := ioutil.ReadFile(update.Options.Kilnfile)
+	templateVariablesService := baking.NewTemplateVariablesService()
+	templateVariables, _, err := templateVariablesService.Read(update.Options.VariablesFiles, update.Options.Variables, update.Options.VariablesYAML)
 	if err != nil {
-		return errors.New("could not read kilnfile")
+		return fmt.Errorf("failed to parse template variables: %s", err)
 	}
-	var kilnfile cargo.Kilnfile
-	if err := yaml.Unmarshal(kilnfileYAML, &kilnfile); err != nil {
+
+	interpolator := builder.NewKilnfileInterpolator(builder.NewSecretProviders(update.Options.SecretsDirectory, update.Options.SecretsHelper))
+
+	kilnfile, err := cargo.NewKilnfileLoader(osfs.New(""), interpolator).Load(update.Options.Kilnfile, templateVariables)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("could not read kilnfile")
+		}
 		return fmt.Errorf("could not parse yaml in kilnfile: %s", err)
 	}
 
@@ -63,45 +88,79 @@ func (update Update) Execute(args []string) error {
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("could not read kilnfile: %s", err)
 	}
-	templateVariablesService := baking.NewTemplateVariablesService()
-	templateVariables, err := templateVariablesService.FromPathsAndPairs(update.Options.VariablesFiles, update.Options.Variables)
-	if err != nil {
-		return fmt.Errorf("failed to parse template variables: %s", err)
-	}
-	interpolator := builder.NewInterpolator()
-	interpolatedMetadata, err := interpolator.Interpolate(builder.InterpolateInput{
-		Variables: templateVariables,
-	}, kilnfileLockYAML)
+	interpolatedMetadata, err := interpolator.InterpolateKilnfile(templateVariables, kilnfileLockYAML)
 	if err != nil {
 		return err
 	}
 
-	var KilnfileLock cargo.KilnfileLock
-	if err := yaml.Unmarshal(interpolatedMetadata, &KilnfileLock); err != nil {
+	KilnfileLock, err := cargo.ParseKilnfileLock(interpolatedMetadata)
+	if err != nil {
 		return fmt.Errorf("could not parse yaml in Kilnfile.lock: %s", err)
 	}
 
-	stemcellConstraint, err := semver.NewConstraint(kilnfile.Stemcell.Version)
+	previousKilnfileLock := KilnfileLock
+
+	if update.BOSHIOStemcellsVersionsService == nil {
+		update.BOSHIOStemcellsVersionsService = fetcher.NewBOSHIOStemcellVersions("")
+	}
+	if update.LocalStemcellsVersionsService == nil {
+		update.LocalStemcellsVersionsService = fetcher.NewLocalStemcellDirectory(builder.NewStemcellManifestReader(helper.NewFilesystem()))
+	}
+
+	KilnfileLock.Stemcell, err = update.updateStemcell(kilnfile.Stemcell, KilnfileLock.Stemcell, kilnfile.StemcellSources)
 	if err != nil {
-		return fmt.Errorf("stemcell_constraint version error: %s", err)
+		return err
 	}
 
-	var stemcellSlug string
-	switch kilnfile.Stemcell.OS {
-	case "windows":
-		stemcellSlug = stemcellSlugWindows
-	case "ubuntu-xenial":
-		stemcellSlug = stemcellSlugXenial
-	case "ubuntu-trusty":
-		stemcellSlug = stemcellSlugTrusty
-	default:
-		return fmt.Errorf("stemcell_constraint os not supported: %s", kilnfile.Stemcell.OS)
+	lockedStemcells := lockedStemcellsByKey(KilnfileLock.AdditionalStemcells)
+	additionalStemcells := make([]cargo.Stemcell, 0, len(kilnfile.AdditionalStemcells))
+	for i, key := range cargo.StemcellKeys(kilnfile.AdditionalStemcells) {
+		criteria := kilnfile.AdditionalStemcells[i]
+		stemcell, err := update.updateStemcell(criteria, lockedStemcells[key], kilnfile.StemcellSources)
+		if err != nil {
+			return err
+		}
+		additionalStemcells = append(additionalStemcells, stemcell)
 	}
+	KilnfileLock.AdditionalStemcells = additionalStemcells
 
-	stemcellVersionsStrings, err := update.StemcellsVersionsService.Versions(stemcellSlug)
+	if update.OutLogger == nil {
+		update.OutLogger = log.New(os.Stdout, "", 0)
+	}
+
+	diff := cargo.DiffKilnfileLocks(previousKilnfileLock, KilnfileLock)
+	update.OutLogger.Print(diff.String())
+
+	if !update.Options.DryRun {
+		updatedLockFileYAML, err := marshalKilnfileLock(KilnfileLock, interpolatedMetadata)
+		if err != nil {
+			return err
+		}
+
+		err = writeKilnfileLock(kilnfileLockPath, updatedLockFileYAML)
+		if err != nil {
+			return err
+		}
+	}
+
+	if update.Options.ExitCode && diff.HasChanges() {
+		return ErrKilnfileLockChanged
+	}
+
+	return nil
+}
+
+func (update Update) updateStemcell(criteria, locked cargo.Stemcell, sources []cargo.StemcellSourceConfig) (cargo.Stemcell, error) {
+	stemcellConstraint, err := semver.NewConstraint(criteria.Version)
 	if err != nil {
-		return fmt.Errorf("could not get stemcell versions: %s", err)
+		return cargo.Stemcell{}, fmt.Errorf("stemcell_constraint version error: %s", err)
 	}
+
+	stemcellVersionsStrings, err := update.stemcellVersions(criteria.OS, sources)
+	if err != nil {
+		return cargo.Stemcell{}, err
+	}
+
 	stemcellVersions := make([]*semver.Version, 0, len(stemcellVersionsStrings))
 	for _, str := range stemcellVersionsStrings {
 		ver, err := semver.NewVersion(str)
@@ -115,23 +174,70 @@ func (update Update) Execute(args []string) error {
 	sort.Sort(semver.Collection(stemcellVersions))
 
 	if len(stemcellVersions) > 0 {
-		KilnfileLock.Stemcell.Version = strings.TrimSuffix(stemcellVersions[len(stemcellVersions)-1].String(), ".0")
+		locked.Version = strings.TrimSuffix(stemcellVersions[len(stemcellVersions)-1].String(), ".0")
 	}
-	KilnfileLock.Stemcell.OS = kilnfile.Stemcell.OS
+	locked.OS = criteria.OS
+	locked.Alias = criteria.Alias
 
-	os.Remove(kilnfileLockPath)
-	lockFile, err := os.Create(kilnfileLockPath)
+	return locked, nil
+}
+
+func (update Update) stemcellVersions(stemcellOS string, sources []cargo.StemcellSourceConfig) ([]string, error) {
+	source, err := stemcellSourceFor(stemcellOS, sources)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	updatedLockFileYAML, err := yaml.Marshal(KilnfileLock)
+	var versions []string
+	switch source.Type {
+	case cargo.StemcellSourceTypePivnet:
+		versions, err = update.StemcellsVersionsService.Versions(source.Slug)
+	case cargo.StemcellSourceTypeBOSHIO:
+		versions, err = update.BOSHIOStemcellsVersionsService.Versions(source.Name)
+	case cargo.StemcellSourceTypeDirectory:
+		versions, err = update.LocalStemcellsVersionsService.Versions(source.Path, stemcellOS)
+	default:
+		return nil, fmt.Errorf("stemcell source type not supported for os %s: %q", stemcellOS, source.Type)
+	}
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("could not get stemcell versions: %s", err)
 	}
-	lockFile.Write([]byte(lockFileYAMLHeader))
-	lockFile.Write(updatedLockFileYAML)
-	return nil
+
+	return versions, nil
+}
+
+// stemcellSourceFor returns the stemcell_sources entry for the os. When the
+// Kilnfile does not declare one, the well known Pivnet slugs are used.
+func stemcellSourceFor(stemcellOS string, sources []cargo.StemcellSourceConfig) (cargo.StemcellSourceConfig, error) {
+	for _, source := range sources {
+		if source.OS == stemcellOS {
+			if source.Type == "" {
+				source.Type = cargo.StemcellSourceTypePivnet
+			}
+			return source, nil
+		}
+	}
+
+	switch stemcellOS {
+	case "windows":
+		return cargo.StemcellSourceConfig{OS: stemcellOS, Type: cargo.StemcellSourceTypePivnet, Slug: stemcellSlugWindows}, nil
+	case "ubuntu-xenial":
+		return cargo.StemcellSourceConfig{OS: stemcellOS, Type: cargo.StemcellSourceTypePivnet, Slug: stemcellSlugXenial}, nil
+	case "ubuntu-trusty":
+		return cargo.StemcellSourceConfig{OS: stemcellOS, Type: cargo.StemcellSourceTypePivnet, Slug: stemcellSlugTrusty}, nil
+	}
+
+	return cargo.StemcellSourceConfig{}, fmt.Errorf("stemcell_constraint os not supported: %s (add an entry for it under stemcell_sources)", stemcellOS)
+}
+
+// lockedStemcellsByKey indexes the locked stemcells by os and alias, so each
+// criteria is updated from the locked stemcell it produced last time.
+func lockedStemcellsByKey(stemcells []cargo.Stemcell) map[string]cargo.Stemcell {
+	byKey := map[string]cargo.Stemcell{}
+	for i, key := range cargo.StemcellKeys(stemcells) {
+		byKey[key] = stemcells[i]
+	}
+	return byKey
 }
 
 // Usage implements the Usage part of the jhanda.Command interface
@@ -143,6 +249,83 @@ func (update Update) Usage() jhanda.Usage {
 	}
 }
 
+// marshalKilnfileLock renders the lock with the machine generated header.
+// Keys in the previous lock file that kiln does not manage are kept after
+// the ones it does.
+func marshalKilnfileLock(lock cargo.KilnfileLock, previousLockYAML []byte) ([]byte, error) {
+	managedYAML, err := yaml.Marshal(lock)
+	if err != nil {
+		return nil, err
+	}
+
+	var lockFields yaml.MapSlice
+	if err := yaml.Unmarshal(managedYAML, &lockFields); err != nil {
+		return nil, err // should never happen
+	}
+
+	var previousLockFields yaml.MapSlice
+	if err := yaml.Unmarshal(previousLockYAML, &previousLockFields); err != nil {
+		return nil, fmt.Errorf("could not parse yaml in Kilnfile.lock: %s", err)
+	}
+
+	for _, field := range previousLockFields {
+		if key, ok := field.Key.(string); ok && managedKilnfileLockKeys[key] {
+			continue
+		}
+		lockFields = append(lockFields, field)
+	}
+
+	lockYAML, err := yaml.Marshal(lockFields)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(lockFileYAMLHeader), lockYAML...), nil
+}
+
+// writeKilnfileLock writes to a temporary file next to path and renames it
+// into place so a failure never leaves a missing or partially written lock.
+func writeKilnfileLock(path string, contents []byte) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("could not create temporary lock file: %s", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(contents)
+	if err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("could not write lock file: %s", err)
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return fmt.Errorf("could not write lock file: %s", err)
+	}
+
+	err = os.Chmod(tmpFile.Name(), 0644)
+	if err != nil {
+		return fmt.Errorf("could not write lock file: %s", err)
+	}
+
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return fmt.Errorf("could not replace %s: %s", path, err)
+	}
+
+	return nil
+}
+
+var (
+	ErrKilnfileLockChanged = errors.New("Kilnfile.lock has changes")
+
+	managedKilnfileLockKeys = map[string]bool{
+		"releases":                      true,
+		"stemcell_criteria":             true,
+		"additional_stemcells_criteria": true,
+	}
+)
+
 const (
 	lockFileYAMLHeader = "########### DO NOT EDIT! ############\n" +
 		"# This is a machine generated file, #\n" +
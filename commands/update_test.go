@@ -3,12 +3,14 @@ package commands_test
 import (
 	"errors"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
 	"github.com/pivotal-cf/jhanda"
 	"github.com/pivotal-cf/kiln/commands"
 	"github.com/pivotal-cf/kiln/commands/fakes"
@@ -21,6 +23,9 @@ var _ = Describe("Update", func() {
 			update                                        *commands.Update
 			tmpDir, someKilnfilePath, someKilfileLockPath string
 			stemcellsVersionsService                      fakes.VersionsService
+			boshioStemcellsVersionsService                fakes.VersionsService
+			localStemcellsVersionsService                 fakes.LocalStemcellsVersionsService
+			outBuffer                                     *gbytes.Buffer
 		)
 		BeforeEach(func() {
 			var err error
@@ -42,8 +47,14 @@ var _ = Describe("Update", func() {
 				"3588.0",
 				"3587.1",
 			}
+			boshioStemcellsVersionsService = fakes.VersionsService{}
+			localStemcellsVersionsService = fakes.LocalStemcellsVersionsService{}
+			outBuffer = gbytes.NewBuffer()
 			update = &commands.Update{
-				StemcellsVersionsService: &stemcellsVersionsService,
+				OutLogger:                      log.New(outBuffer, "", 0),
+				StemcellsVersionsService:       &stemcellsVersionsService,
+				BOSHIOStemcellsVersionsService: &boshioStemcellsVersionsService,
+				LocalStemcellsVersionsService:  &localStemcellsVersionsService,
 			}
 		})
 		AfterEach(func() {
@@ -89,6 +100,90 @@ var _ = Describe("Update", func() {
 			})
 		})
 
+		When("--exit-code is passed", func() {
+			var exitCodeErr error
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(someKilfileLockPath, []byte(initallKilnfileLockFileContents), 0644)).To(Succeed())
+			})
+			JustBeforeEach(func() {
+				exitCodeErr = update.Execute([]string{
+					"--kilnfile", someKilnfilePath,
+					"--exit-code",
+				})
+			})
+			It("returns an error when Kilnfile.lock changed", func() {
+				Expect(exitCodeErr).To(Equal(commands.ErrKilnfileLockChanged))
+			})
+			When("Kilnfile.lock is already up to date", func() {
+				BeforeEach(func() {
+					stemcellsVersionsService.VersionsCall.Returns.Versions = []string{"3586.1"}
+				})
+				It("does not return an error", func() {
+					Expect(exitCodeErr).NotTo(HaveOccurred())
+					Expect(outBuffer).To(gbytes.Say("no changes"))
+				})
+			})
+		})
+
+		When("the Kilnfile uses variables and secrets", func() {
+			BeforeEach(func() {
+				secretsDirectory := filepath.Join(tmpDir, "secrets")
+				Expect(os.Mkdir(secretsDirectory, 0700)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(secretsDirectory, "stemcells_path"), []byte("stemcells/windows\n"), 0600)).To(Succeed())
+				Expect(ioutil.WriteFile(someKilnfilePath, []byte(`---
+stemcell_criteria:
+  os: $( variable "stemcell_os" )
+  version: "~2019"
+stemcell_sources:
+- os: windows2019
+  type: directory
+  path: $( secret "stemcells_path" )
+`), 0644)).To(Succeed())
+				localStemcellsVersionsService.VersionsCall.Returns.Versions = []string{"2019.7", "2019.12"}
+			})
+
+			It("interpolates the Kilnfile", func() {
+				err := update.Execute([]string{
+					"--kilnfile", someKilnfilePath,
+					"--variable", "stemcell_os=windows2019",
+					"--secrets-directory", filepath.Join(tmpDir, "secrets"),
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(localStemcellsVersionsService.VersionsCall.Receives.Directory).To(Equal("stemcells/windows"))
+				Expect(localStemcellsVersionsService.VersionsCall.Receives.StemcellOS).To(Equal("windows2019"))
+
+				kilnfileLock, err := ioutil.ReadFile(someKilfileLockPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(kilnfileLock)).To(ContainSubstring("stemcell_criteria:\n  os: windows2019\n  version: \"2019.12\"\n"))
+			})
+		})
+
+		When("a variable is given as YAML", func() {
+			BeforeEach(func() {
+				Expect(ioutil.WriteFile(someKilnfilePath, []byte(`---
+stemcell_criteria: $( variable "stemcell_criteria" )
+stemcell_sources:
+- os: windows2019
+  type: directory
+  path: stemcells/windows
+`), 0644)).To(Succeed())
+				localStemcellsVersionsService.VersionsCall.Returns.Versions = []string{"2019.7", "2019.12"}
+			})
+
+			It("interpolates its value", func() {
+				err := update.Execute([]string{
+					"--kilnfile", someKilnfilePath,
+					"--variable-yaml", `stemcell_criteria={os: windows2019, version: "~2019"}`,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(localStemcellsVersionsService.VersionsCall.Receives.StemcellOS).To(Equal("windows2019"))
+
+				kilnfileLock, err := ioutil.ReadFile(someKilfileLockPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(kilnfileLock)).To(ContainSubstring("stemcell_criteria:\n  os: windows2019\n  version: \"2019.12\"\n"))
+			})
+		})
+
 		When("given a Kilnfile", func() {
 			var (
 				updateErr error
@@ -148,8 +243,33 @@ var _ = Describe("Update", func() {
 								"  version: \"3586.7\"\n",
 						))
 					})
+					It("prints the stemcell changes", func() {
+						Expect(outBuffer).To(gbytes.Say(`Stemcells:\n  ~ ubuntu-trusty 3586.1 -> 3586.7`))
+					})
+					It("does not leave temporary files behind", func() {
+						files, err := ioutil.ReadDir(tmpDir)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(files).To(HaveLen(2))
+					})
 					// happy paths ^^^
 
+					When("the Kilnfile.lock has keys kiln does not manage", func() {
+						BeforeEach(func() {
+							Expect(ioutil.WriteFile(someKilfileLockPath, []byte(initallKilnfileLockFileContents+"some_team_notes: keep me\n"), 0644)).To(Succeed())
+						})
+						It("keeps them", func() {
+							kilnfileLock, readErr := ioutil.ReadFile(someKilfileLockPath)
+							Expect(readErr).NotTo(HaveOccurred())
+							Expect(string(kilnfileLock)).To(HaveSuffix(
+								"releases: []\n" +
+									"stemcell_criteria:\n" +
+									"  os: ubuntu-trusty\n" +
+									"  version: \"3586.7\"\n" +
+									"some_team_notes: keep me\n",
+							))
+						})
+					})
+
 					When("a Kilnfile has invalid yaml", func() {
 						BeforeEach(func() {
 							kilnfile, err := os.OpenFile(someKilnfilePath, os.O_RDWR, 0644)
@@ -163,6 +283,14 @@ var _ = Describe("Update", func() {
 							Expect(updateErr.Error()).To(ContainSubstring("could not parse yaml in kilnfile"))
 						})
 					})
+					When("a Kilnfile has an unknown key", func() {
+						BeforeEach(func() {
+							Expect(ioutil.WriteFile(someKilnfilePath, []byte(initallKilnfileYAMLFileContents+"stemcell_sauces: []\n"), 0644)).To(Succeed())
+						})
+						It("returns a descriptive error", func() {
+							Expect(updateErr).To(MatchError(ContainSubstring("field stemcell_sauces not found in type cargo.Kilnfile")))
+						})
+					})
 					When("an Kilnfile.lock has invalid yaml", func() {
 						BeforeEach(func() {
 							kilnfileLockFile, err := os.OpenFile(someKilfileLockPath, os.O_RDWR, 0644)
@@ -178,6 +306,25 @@ var _ = Describe("Update", func() {
 					})
 				})
 			})
+			When("--dry-run is passed", func() {
+				JustBeforeEach(func() {
+					Expect(ioutil.WriteFile(someKilfileLockPath, []byte(initallKilnfileLockFileContents), 0644)).To(Succeed())
+					updateErr = update.Execute([]string{
+						"--kilnfile", someKilnfilePath,
+						"--dry-run",
+					})
+				})
+				It("prints the changes", func() {
+					Expect(updateErr).NotTo(HaveOccurred())
+					Expect(outBuffer).To(gbytes.Say(`Stemcells:\n  ~ ubuntu-trusty 3586.1 -> 3586.7`))
+				})
+				It("does not write Kilnfile.lock", func() {
+					kilnfileLock, err := ioutil.ReadFile(someKilfileLockPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(kilnfileLock)).To(Equal(initallKilnfileLockFileContents))
+				})
+			})
+
 			When("Kilnfile is missing", func() {
 				BeforeEach(func() {
 					Expect(os.Remove(someKilnfilePath)).NotTo(HaveOccurred())
@@ -197,6 +344,93 @@ var _ = Describe("Update", func() {
 					Expect(updateErr).To(MatchError(`stemcell OS ("") and/or version constraint ("") are not set`))
 				})
 			})
+			When("the Kilnfile declares stemcell sources", func() {
+				BeforeEach(func() {
+					Expect(ioutil.WriteFile(someKilnfilePath, []byte(kilnfileWithStemcellSourcesContents), 0644)).To(Succeed())
+					stemcellsVersionsService.VersionsCall.Returns.Versions = []string{"1.9", "1.12", "2.0"}
+					boshioStemcellsVersionsService.VersionsCall.Returns.Versions = []string{"621.71", "621.74", "456.1"}
+					localStemcellsVersionsService.VersionsCall.Returns.Versions = []string{"2019.7", "2019.12"}
+				})
+
+				It("gets versions from each source", func() {
+					Expect(updateErr).NotTo(HaveOccurred())
+					Expect(stemcellsVersionsService.VersionsCall.Receives.StemcellOS).To(Equal("stemcells-ubuntu-jammy"))
+					Expect(boshioStemcellsVersionsService.VersionsCall.Receives.StemcellOS).To(Equal("bosh-vsphere-esxi-ubuntu-xenial-go_agent"))
+					Expect(localStemcellsVersionsService.VersionsCall.Receives.Directory).To(Equal("stemcells/windows"))
+					Expect(localStemcellsVersionsService.VersionsCall.Receives.StemcellOS).To(Equal("windows2019"))
+				})
+
+				It("writes the additional stemcells to Kilnfile.lock", func() {
+					kilnfileLock, err := ioutil.ReadFile(someKilfileLockPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(kilnfileLock)).To(HaveSuffix(
+						"releases: []\n" +
+							"stemcell_criteria:\n" +
+							"  os: ubuntu-jammy\n" +
+							"  version: \"1.12\"\n" +
+							"additional_stemcells_criteria:\n" +
+							"- os: ubuntu-xenial\n" +
+							"  version: \"621.74\"\n" +
+							"- os: windows2019\n" +
+							"  version: \"2019.12\"\n",
+					))
+				})
+			})
+
+			When("the Kilnfile has two criteria for the same os", func() {
+				BeforeEach(func() {
+					Expect(ioutil.WriteFile(someKilnfilePath, []byte(kilnfileWithTwoXenialStemcellsContents), 0644)).To(Succeed())
+					Expect(ioutil.WriteFile(someKilfileLockPath, []byte(`---
+stemcell_criteria:
+  os: ubuntu-jammy
+  version: "1.9"
+additional_stemcells_criteria:
+- os: ubuntu-xenial
+  version: "621.71"
+- os: ubuntu-xenial
+  version: "456.1"
+`), 0644)).To(Succeed())
+					stemcellsVersionsService.VersionsCall.Returns.Versions = []string{"1.9"}
+					boshioStemcellsVersionsService.VersionsCall.Returns.Versions = []string{"621.71", "621.74"}
+				})
+
+				It("updates each locked stemcell from its own criteria", func() {
+					Expect(updateErr).NotTo(HaveOccurred())
+
+					kilnfileLock, err := ioutil.ReadFile(someKilfileLockPath)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(kilnfileLock)).To(HaveSuffix(
+						"additional_stemcells_criteria:\n" +
+							"- os: ubuntu-xenial\n" +
+							"  version: \"621.74\"\n" +
+							"- os: ubuntu-xenial\n" +
+							"  version: \"456.1\"\n",
+					))
+				})
+			})
+
+			When("the stemcell os has no source", func() {
+				BeforeEach(func() {
+					contents := strings.ReplaceAll(initallKilnfileYAMLFileContents, "ubuntu-trusty", "ubuntu-jammy")
+					Expect(ioutil.WriteFile(someKilnfilePath, []byte(contents), 0644)).To(Succeed())
+				})
+
+				It("returns a descriptive error", func() {
+					Expect(updateErr).To(MatchError(ContainSubstring("stemcell_constraint os not supported: ubuntu-jammy")))
+				})
+			})
+
+			When("a stemcell source has an unknown type", func() {
+				BeforeEach(func() {
+					contents := initallKilnfileYAMLFileContents + "stemcell_sources:\n- os: ubuntu-trusty\n  type: ftp\n"
+					Expect(ioutil.WriteFile(someKilnfilePath, []byte(contents), 0644)).To(Succeed())
+				})
+
+				It("returns a descriptive error", func() {
+					Expect(updateErr).To(MatchError(ContainSubstring(`stemcell source type not supported for os ubuntu-trusty: "ftp"`)))
+				})
+			})
+
 			When("the StemcellVersionsService returns an error", func() {
 				BeforeEach(func() {
 					stemcellsVersionsService.VersionsCall.Returns.Err = errors.New("some-err")
@@ -226,6 +460,41 @@ const (
 stemcell_criteria:
   os: ubuntu-trusty
   version: "3586.*"
+`
+	kilnfileWithStemcellSourcesContents = `---
+stemcell_criteria:
+  os: ubuntu-jammy
+  version: "~1"
+additional_stemcells_criteria:
+- os: ubuntu-xenial
+  version: "621.*"
+- os: windows2019
+  version: "2019.*"
+stemcell_sources:
+- os: ubuntu-jammy
+  slug: stemcells-ubuntu-jammy
+- os: ubuntu-xenial
+  type: bosh.io
+  name: bosh-vsphere-esxi-ubuntu-xenial-go_agent
+- os: windows2019
+  type: directory
+  path: stemcells/windows
+`
+	kilnfileWithTwoXenialStemcellsContents = `---
+stemcell_criteria:
+  os: ubuntu-jammy
+  version: "~1"
+additional_stemcells_criteria:
+- os: ubuntu-xenial
+  version: "621.*"
+- os: ubuntu-xenial
+  version: "456.*"
+stemcell_sources:
+- os: ubuntu-jammy
+  slug: stemcells-ubuntu-jammy
+- os: ubuntu-xenial
+  type: bosh.io
+  name: bosh-vsphere-esxi-ubuntu-xenial-go_agent
 `
 	initallKilnfileYAMLWithoutStemcellCriteraFileContents = `---
 `
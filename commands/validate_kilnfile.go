@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/pivotal-cf/jhanda"
+	"github.com/pivotal-cf/kiln/builder"
+	"github.com/pivotal-cf/kiln/internal/baking"
+	"github.com/pivotal-cf/kiln/internal/cargo"
+	"gopkg.in/src-d/go-billy.v4/osfs"
+)
+
+// ValidateKilnfile reports unknown keys and incomplete source configuration
+// in a Kilnfile and its Kilnfile.lock before fetch or update trip over them.
+type ValidateKilnfile struct {
+	logger *log.Logger
+
+	Options struct {
+		Kilnfile         string   `short:"kf" long:"kilnfile" default:"Kilnfile" description:"path to Kilnfile"`
+		VariablesFiles   []string `short:"vf" long:"variables-file" description:"path to variables file"`
+		Variables        []string `short:"vr" long:"variable" description:"variable in key=value format"`
+		VariablesYAML    []string `short:"vy" long:"variable-yaml" description:"variable in key=value format, with the value parsed as YAML"`
+		SecretsDirectory string   `short:"sc" long:"secrets-directory" description:"path to a directory with a file per secret for the secret helper"`
+		SecretsHelper    string   `short:"sh" long:"secrets-helper" description:"command of a credential helper for the secret helper"`
+		Schema           bool     `long:"schema" description:"prints the Kilnfile JSON schema instead of validating"`
+	}
+}
+
+func NewValidateKilnfile(logger *log.Logger) ValidateKilnfile {
+	return ValidateKilnfile{
+		logger: logger,
+	}
+}
+
+func (v ValidateKilnfile) Execute(args []string) error {
+	_, err := jhanda.Parse(&v.Options, args)
+	if err != nil {
+		return err
+	}
+
+	if v.Options.Schema {
+		schema, err := cargo.KilnfileJSONSchema()
+		if err != nil {
+			return err
+		}
+		v.logger.Printf("%s", schema)
+		return nil
+	}
+
+	templateVariablesService := baking.NewTemplateVariablesService()
+	templateVariables, _, err := templateVariablesService.Read(v.Options.VariablesFiles, v.Options.Variables, v.Options.VariablesYAML)
+	if err != nil {
+		return fmt.Errorf("failed to parse template variables: %s", err)
+	}
+
+	secretProviders := builder.NewSecretProviders(v.Options.SecretsDirectory, v.Options.SecretsHelper)
+	kilnfileLoader := cargo.NewKilnfileLoader(osfs.New(""), builder.NewKilnfileInterpolator(secretProviders))
+	kilnfile, err := kilnfileLoader.Load(v.Options.Kilnfile, templateVariables)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return err
+		}
+		return ConfigFileError{err: err, HumanReadableConfigFileName: "Kilnfile specification " + v.Options.Kilnfile}
+	}
+
+	err = kilnfile.Validate()
+	if err != nil {
+		return ConfigFileError{err: err, HumanReadableConfigFileName: "Kilnfile specification " + v.Options.Kilnfile}
+	}
+
+	v.logger.Printf("%s is valid", v.Options.Kilnfile)
+
+	lockFileName := fmt.Sprintf("%s.lock", v.Options.Kilnfile)
+	kilnfileLock, err := kilnfileLoader.LoadLock(v.Options.Kilnfile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return ConfigFileError{err: err, HumanReadableConfigFileName: "Kilnfile.lock " + lockFileName}
+	}
+
+	err = kilnfileLock.Validate()
+	if err != nil {
+		return ConfigFileError{err: err, HumanReadableConfigFileName: "Kilnfile.lock " + lockFileName}
+	}
+
+	v.logger.Printf("%s is valid", lockFileName)
+
+	return nil
+}
+
+func (v ValidateKilnfile) Usage() jhanda.Usage {
+	return jhanda.Usage{
+		Description:      "Checks a Kilnfile and its Kilnfile.lock for unknown keys and incomplete release or stemcell sources. Pass --schema to print a JSON schema editors can use to check a Kilnfile.",
+		ShortDescription: "validates a Kilnfile and Kilnfile.lock",
+		Flags:            v.Options,
+	}
+}
@@ -0,0 +1,111 @@
+package commands_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+
+	"github.com/pivotal-cf/jhanda"
+	"github.com/pivotal-cf/kiln/commands"
+)
+
+var _ = Describe("ValidateKilnfile", func() {
+	var _ jhanda.Command = commands.ValidateKilnfile{}
+
+	var (
+		tmpDir           string
+		kilnfilePath     string
+		output           *gbytes.Buffer
+		validateKilnfile commands.ValidateKilnfile
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "validate-kilnfile-test")
+		Expect(err).NotTo(HaveOccurred())
+
+		kilnfilePath = filepath.Join(tmpDir, "Kilnfile")
+		Expect(ioutil.WriteFile(kilnfilePath, []byte(`---
+release_sources:
+- type: s3
+  compiled: true
+  bucket: $( variable "bucket" )
+  region: us-west-1
+  access_key_id: some-key
+  secret_access_key: some-secret
+  regex: ^(?P<release_name>[a-z-]+)-(?P<release_version>[0-9.]+)\.tgz$
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.*"
+`), 0644)).To(Succeed())
+
+		output = gbytes.NewBuffer()
+		validateKilnfile = commands.NewValidateKilnfile(log.New(output, "", 0))
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("reports that the Kilnfile is valid", func() {
+		Expect(validateKilnfile.Execute([]string{"--kilnfile", kilnfilePath, "--variable", "bucket=some-bucket"})).To(Succeed())
+		Expect(output).To(gbytes.Say(kilnfilePath + " is valid"))
+	})
+
+	When("a variable is given as YAML", func() {
+		It("interpolates its value", func() {
+			Expect(ioutil.WriteFile(kilnfilePath, []byte("pre_ga_user_groups: $( variable \"groups\" )\n"), 0644)).To(Succeed())
+
+			Expect(validateKilnfile.Execute([]string{"--kilnfile", kilnfilePath, "--variable-yaml", "groups=[some-group, other-group]"})).To(Succeed())
+			Expect(output).To(gbytes.Say(kilnfilePath + " is valid"))
+
+			err := validateKilnfile.Execute([]string{"--kilnfile", kilnfilePath, "--variable", "groups=[some-group, other-group]"})
+			Expect(err).To(MatchError(ContainSubstring("cannot unmarshal !!str")))
+		})
+	})
+
+	When("the Kilnfile.lock exists", func() {
+		It("validates it too", func() {
+			Expect(ioutil.WriteFile(kilnfilePath+".lock", []byte("releases:\n- name: bpm\n  version: 1.1.0\n- name: bpm\n  version: 1.1.1\n"), 0644)).To(Succeed())
+
+			err := validateKilnfile.Execute([]string{"--kilnfile", kilnfilePath, "--variable", "bucket=some-bucket"})
+			Expect(err).To(MatchError(ContainSubstring(`releases[1]: release "bpm" is listed more than once`)))
+		})
+	})
+
+	When("the Kilnfile has an unknown key", func() {
+		It("returns an error", func() {
+			Expect(ioutil.WriteFile(kilnfilePath, []byte("release_sources:\n- type: s3\n  acess_key_id: some-key\n"), 0644)).To(Succeed())
+
+			err := validateKilnfile.Execute([]string{"--kilnfile", kilnfilePath})
+			Expect(err).To(MatchError(ContainSubstring("field acess_key_id not found")))
+		})
+	})
+
+	When("a release source is incomplete", func() {
+		It("lists the missing keys", func() {
+			Expect(ioutil.WriteFile(kilnfilePath, []byte("release_sources:\n- type: s3\n  bucket: some-bucket\n"), 0644)).To(Succeed())
+
+			err := validateKilnfile.Execute([]string{"--kilnfile", kilnfilePath})
+			Expect(err).To(BeAssignableToTypeOf(commands.ConfigFileError{}))
+			Expect(err).To(MatchError(ContainSubstring("- release_sources[0]: region is required for s3 release sources")))
+			Expect(err).To(MatchError(ContainSubstring("- release_sources[0]: regex is required for s3 release sources")))
+		})
+	})
+
+	When("--schema is passed", func() {
+		It("prints the Kilnfile JSON schema", func() {
+			Expect(validateKilnfile.Execute([]string{"--schema"})).To(Succeed())
+
+			var schema map[string]interface{}
+			Expect(json.Unmarshal(output.Contents(), &schema)).To(Succeed())
+			Expect(schema).To(HaveKeyWithValue("title", "Kilnfile"))
+		})
+	})
+})
@@ -0,0 +1,64 @@
+package fetcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	ErrStemcellNameMustNotBeEmpty = stringError("stemcell name must not be empty")
+)
+
+// BOSHIOStemcellVersions lists the versions of a stemcell published on bosh.io.
+type BOSHIOStemcellVersions struct {
+	serverURI string
+}
+
+func NewBOSHIOStemcellVersions(customServerURI string) BOSHIOStemcellVersions {
+	if customServerURI == "" {
+		customServerURI = "https://bosh.io"
+	}
+
+	return BOSHIOStemcellVersions{
+		serverURI: customServerURI,
+	}
+}
+
+// Versions expects the full bosh.io stemcell name
+// (for example "bosh-vsphere-esxi-ubuntu-xenial-go_agent").
+func (source BOSHIOStemcellVersions) Versions(name string) ([]string, error) {
+	if name == "" {
+		return nil, ErrStemcellNameMustNotBeEmpty
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/v1/stemcells/%s", source.serverURI, name))
+	if err != nil {
+		return nil, fmt.Errorf("Bosh.io API is down with error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, (*ResponseStatusCodeError)(resp)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var stemcells []struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &stemcells); err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, stemcell := range stemcells {
+		versions = append(versions, stemcell.Version)
+	}
+
+	return versions, nil
+}
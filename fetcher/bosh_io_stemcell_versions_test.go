@@ -0,0 +1,85 @@
+package fetcher_test
+
+import (
+	"net/http"
+
+	"github.com/onsi/gomega/ghttp"
+
+	"github.com/pivotal-cf/kiln/fetcher"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("BOSHIOStemcellVersions", func() {
+	var (
+		testServer *ghttp.Server
+		source     fetcher.BOSHIOStemcellVersions
+
+		stemcellName string
+		gotVersions  []string
+		gotErr       error
+	)
+
+	BeforeEach(func() {
+		testServer = ghttp.NewServer()
+		source = fetcher.NewBOSHIOStemcellVersions(testServer.URL())
+		stemcellName = "bosh-vsphere-esxi-ubuntu-xenial-go_agent"
+	})
+
+	AfterEach(func() {
+		testServer.Close()
+	})
+
+	JustBeforeEach(func() {
+		gotVersions, gotErr = source.Versions(stemcellName)
+	})
+
+	When("the stemcell exists on bosh.io", func() {
+		BeforeEach(func() {
+			testServer.RouteToHandler("GET", "/api/v1/stemcells/bosh-vsphere-esxi-ubuntu-xenial-go_agent",
+				ghttp.RespondWith(http.StatusOK, `[{"name": "bosh-vsphere-esxi-ubuntu-xenial-go_agent", "version": "621.74"}, {"name": "bosh-vsphere-esxi-ubuntu-xenial-go_agent", "version": "621.71"}]`),
+			)
+		})
+
+		It("returns the versions", func() {
+			Expect(gotErr).NotTo(HaveOccurred())
+			Expect(gotVersions).To(Equal([]string{"621.74", "621.71"}))
+		})
+	})
+
+	When("the stemcell name is empty", func() {
+		BeforeEach(func() {
+			stemcellName = ""
+		})
+
+		It("returns an error", func() {
+			Expect(gotErr).To(Equal(fetcher.ErrStemcellNameMustNotBeEmpty))
+			Expect(gotVersions).To(BeNil())
+		})
+	})
+
+	When("bosh.io responds with an error status", func() {
+		BeforeEach(func() {
+			testServer.RouteToHandler("GET", "/api/v1/stemcells/bosh-vsphere-esxi-ubuntu-xenial-go_agent",
+				ghttp.RespondWith(http.StatusInternalServerError, ``),
+			)
+		})
+
+		It("returns an error", func() {
+			Expect(gotErr).To(MatchError(ContainSubstring("got status 500")))
+		})
+	})
+
+	When("the response is not valid json", func() {
+		BeforeEach(func() {
+			testServer.RouteToHandler("GET", "/api/v1/stemcells/bosh-vsphere-esxi-ubuntu-xenial-go_agent",
+				ghttp.RespondWith(http.StatusOK, `[`),
+			)
+		})
+
+		It("returns an error", func() {
+			Expect(gotErr).To(MatchError(ContainSubstring("unexpected end of JSON input")))
+		})
+	})
+})
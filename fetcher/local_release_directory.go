@@ -117,9 +117,9 @@ func (l LocalReleaseDirectory) deleteReleases(releasesDir string, releasesToDele
 func ConvertToLocalBasename(release ReleaseInfoDownloader) (string, error) {
 	switch rel := release.(type) {
 	case CompiledRelease:
-		return fmt.Sprintf("%s-%s-%s-%s.tgz", rel.ID.Name, rel.ID.Version, rel.StemcellOS, rel.StemcellVersion), nil
+		return cargo.ReleaseTarballName(rel.ID.Name, rel.ID.Version, rel.StemcellOS, rel.StemcellVersion), nil
 	case BuiltRelease:
-		return fmt.Sprintf("%s-%s.tgz", rel.ID.Name, rel.ID.Version), nil
+		return cargo.ReleaseTarballName(rel.ID.Name, rel.ID.Version, "", ""), nil
 	default:
 		return "", ErrReleaseTypeNotSupported
 	}
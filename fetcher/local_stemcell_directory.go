@@ -0,0 +1,72 @@
+package fetcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/pivotal-cf/kiln/builder"
+)
+
+//go:generate counterfeiter -o ./fakes/stemcell_manifest_reader.go --fake-name StemcellManifestReader . stemcellManifestReader
+type stemcellManifestReader interface {
+	Read(path string) (builder.Part, error)
+}
+
+// LocalStemcellDirectory lists the versions of stemcell tarballs found in a directory.
+type LocalStemcellDirectory struct {
+	reader stemcellManifestReader
+}
+
+func NewLocalStemcellDirectory(reader stemcellManifestReader) LocalStemcellDirectory {
+	return LocalStemcellDirectory{
+		reader: reader,
+	}
+}
+
+// Versions returns the versions of the tarballs in directory built for the given operating system.
+func (l LocalStemcellDirectory) Versions(directory, operatingSystem string) ([]string, error) {
+	var tarballs []string
+	err := filepath.Walk(directory, l.collectTarballs(&tarballs))
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for _, tarball := range tarballs {
+		part, err := l.reader.Read(tarball)
+		if err != nil {
+			return nil, fmt.Errorf("could not read stemcell %q: %s", tarball, err)
+		}
+
+		manifest, ok := part.Metadata.(builder.StemcellManifest)
+		if !ok {
+			return nil, fmt.Errorf("could not read stemcell manifest from %q", tarball) // should never happen
+		}
+
+		if manifest.OperatingSystem == operatingSystem {
+			versions = append(versions, manifest.Version)
+		}
+	}
+
+	return versions, nil
+}
+
+func (l LocalStemcellDirectory) collectTarballs(tarballs *[]string) filepath.WalkFunc {
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if match, _ := regexp.MatchString("tgz$|tar.gz$", path); match {
+			*tarballs = append(*tarballs, path)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,79 @@
+package fetcher_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/kiln/builder"
+	"github.com/pivotal-cf/kiln/fetcher"
+	"github.com/pivotal-cf/kiln/fetcher/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LocalStemcellDirectory", func() {
+	var (
+		tmpDir      string
+		reader      *fakes.StemcellManifestReader
+		stemcells   fetcher.LocalStemcellDirectory
+		manifests   map[string]builder.StemcellManifest
+		gotErr      error
+		gotVersions []string
+	)
+
+	BeforeEach(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "local-stemcell-directory")
+		Expect(err).NotTo(HaveOccurred())
+
+		manifests = map[string]builder.StemcellManifest{
+			"light-bosh-stemcell-621.74-ubuntu-xenial.tgz": {OperatingSystem: "ubuntu-xenial", Version: "621.74"},
+			"light-bosh-stemcell-621.71-ubuntu-xenial.tgz": {OperatingSystem: "ubuntu-xenial", Version: "621.71"},
+			"light-bosh-stemcell-2019.7-windows2019.tgz":   {OperatingSystem: "windows2019", Version: "2019.7"},
+		}
+		for name := range manifests {
+			Expect(ioutil.WriteFile(filepath.Join(tmpDir, name), nil, 0644)).To(Succeed())
+		}
+		Expect(ioutil.WriteFile(filepath.Join(tmpDir, "README.md"), nil, 0644)).To(Succeed())
+
+		reader = &fakes.StemcellManifestReader{}
+		reader.ReadStub = func(path string) (builder.Part, error) {
+			return builder.Part{Metadata: manifests[filepath.Base(path)]}, nil
+		}
+
+		stemcells = fetcher.NewLocalStemcellDirectory(reader)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tmpDir)).To(Succeed())
+	})
+
+	It("returns the versions of the tarballs for the operating system", func() {
+		gotVersions, gotErr = stemcells.Versions(tmpDir, "ubuntu-xenial")
+		Expect(gotErr).NotTo(HaveOccurred())
+		Expect(gotVersions).To(ConsistOf("621.74", "621.71"))
+		Expect(reader.ReadCallCount()).To(Equal(3))
+	})
+
+	When("the directory does not exist", func() {
+		It("returns an error", func() {
+			_, gotErr = stemcells.Versions(filepath.Join(tmpDir, "missing"), "ubuntu-xenial")
+			Expect(gotErr).To(HaveOccurred())
+		})
+	})
+
+	When("a tarball can not be read", func() {
+		BeforeEach(func() {
+			reader.ReadStub = nil
+			reader.ReadReturns(builder.Part{}, errors.New("some-error"))
+		})
+
+		It("returns an error", func() {
+			_, gotErr = stemcells.Versions(tmpDir, "ubuntu-xenial")
+			Expect(gotErr).To(MatchError(ContainSubstring("some-error")))
+		})
+	})
+})
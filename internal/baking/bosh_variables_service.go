@@ -18,6 +18,7 @@ func (s BOSHVariablesService) FromDirectories(directories []string) (map[string]
 	}
 	boshVariables := map[string]interface{}{}
 
+	index := 0
 	for _, directory := range directories {
 		directoryVariables, err := s.reader.Read(directory)
 		if err != nil {
@@ -25,7 +26,8 @@ func (s BOSHVariablesService) FromDirectories(directories []string) (map[string]
 		}
 
 		for _, boshVariable := range directoryVariables {
-			boshVariables[boshVariable.Name] = boshVariable.Metadata
+			boshVariables[boshVariable.Name] = orderedValue(boshVariable, index)
+			index++
 		}
 	}
 
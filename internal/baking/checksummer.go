@@ -1,11 +1,8 @@
 package baking
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"os"
 )
 
 type Checksummer struct {
@@ -16,28 +13,19 @@ func NewChecksummer(logger logger) Checksummer {
 	return Checksummer{logger: logger}
 }
 
-func (c Checksummer) Sum(path string) error {
+// Record reports the SHA256 checksum of the tile at path, calculated while
+// the tile was written, and saves it next to the tile. When the tile was
+// streamed to standard output (path "-") the checksum is only reported.
+func (c Checksummer) Record(path string, checksum []byte) error {
 	c.logger.Println(fmt.Sprintf("Calculating SHA256 checksum of %s...", path))
 
-	hash := sha256.New()
+	hexsum := fmt.Sprintf("%x", checksum)
 
-	file, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-
-	defer file.Close()
-
-	_, err = io.Copy(hash, file)
-	if err != nil {
-		return err
-	}
-
-	hexsum := fmt.Sprintf("%x", hash.Sum(nil))
-
-	err = ioutil.WriteFile(fmt.Sprintf("%s.sha256", path), []byte(hexsum), 0644)
-	if err != nil {
-		return err
+	if path != "-" {
+		err := ioutil.WriteFile(fmt.Sprintf("%s.sha256", path), []byte(hexsum), 0644)
+		if err != nil {
+			return err
+		}
 	}
 
 	c.logger.Println(fmt.Sprintf("SHA256 checksum: %s", hexsum))
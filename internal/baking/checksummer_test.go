@@ -1,8 +1,8 @@
 package baking_test
 
 import (
+	"crypto/sha256"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -19,6 +19,7 @@ var _ = Describe("Checksummer", func() {
 		logger      *fakes.Logger
 		checksummer baking.Checksummer
 		tmpdir      string
+		checksum    []byte
 	)
 
 	BeforeEach(func() {
@@ -29,17 +30,11 @@ var _ = Describe("Checksummer", func() {
 		tmpdir, err = ioutil.TempDir("", "")
 		Expect(err).NotTo(HaveOccurred())
 
-		file, err := os.Create(filepath.Join(tmpdir, "fixture"))
+		fixture, err := ioutil.ReadFile("fixtures/file.txt")
 		Expect(err).NotTo(HaveOccurred())
 
-		fixture, err := os.Open("fixtures/file.txt")
-		Expect(err).NotTo(HaveOccurred())
-
-		_, err = io.Copy(file, fixture)
-		Expect(err).NotTo(HaveOccurred())
-
-		Expect(file.Close()).To(Succeed())
-		Expect(fixture.Close()).To(Succeed())
+		sum := sha256.Sum256(fixture)
+		checksum = sum[:]
 	})
 
 	AfterEach(func() {
@@ -50,7 +45,7 @@ var _ = Describe("Checksummer", func() {
 
 	It("prints the sha256 checksum of the file at the given path", func() {
 		path := filepath.Join(tmpdir, "fixture")
-		err := checksummer.Sum(path)
+		err := checksummer.Record(path, checksum)
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(logger.PrintlnCallCount()).To(Equal(2))
@@ -64,7 +59,7 @@ var _ = Describe("Checksummer", func() {
 
 	It("writes the checksum to a .sha256 file in the same directory as the output file", func() {
 		path := filepath.Join(tmpdir, "fixture")
-		err := checksummer.Sum(path)
+		err := checksummer.Record(path, checksum)
 		Expect(err).NotTo(HaveOccurred())
 
 		contents, err := ioutil.ReadFile(fmt.Sprintf("%s.sha256", path))
@@ -72,21 +67,25 @@ var _ = Describe("Checksummer", func() {
 		Expect(string(contents)).To(Equal("2a89f69f18679fef3a1f833d1c5e561cc24ea02ce85b3fb7fae21dd971c9c9cd"))
 	})
 
+	Context("when the tile was written to standard output", func() {
+		It("only prints the checksum", func() {
+			err := checksummer.Record("-", checksum)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(logger.PrintlnArgsForCall(1)[0]).To(Equal("SHA256 checksum: 2a89f69f18679fef3a1f833d1c5e561cc24ea02ce85b3fb7fae21dd971c9c9cd"))
+			_, err = os.Stat("-.sha256")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
 	Context("when the directory does not have write permissions", func() {
 		It("returns an error", func() {
 			err := os.Chmod(tmpdir, 0544)
 			Expect(err).NotTo(HaveOccurred())
 
 			path := filepath.Join(tmpdir, "fixture")
-			err = checksummer.Sum(path)
+			err = checksummer.Record(path, checksum)
 			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("open %s.sha256: permission denied", path))))
 		})
 	})
-
-	Context("when the file does not exist", func() {
-		It("returns an error", func() {
-			err := checksummer.Sum("fixtures/does-not-exist.txt")
-			Expect(err).To(MatchError(ContainSubstring("open fixtures/does-not-exist.txt: no such file or directory")))
-		})
-	})
 })
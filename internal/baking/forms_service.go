@@ -20,6 +20,7 @@ func (fs FormsService) FromDirectories(directories []string) (map[string]interfa
 	fs.logger.Println("Reading form files...")
 
 	forms := map[string]interface{}{}
+	index := 0
 	for _, directory := range directories {
 		directoryForms, err := fs.reader.Read(directory)
 		if err != nil {
@@ -27,7 +28,8 @@ func (fs FormsService) FromDirectories(directories []string) (map[string]interfa
 		}
 
 		for _, directoryForm := range directoryForms {
-			forms[directoryForm.Name] = directoryForm.Metadata
+			forms[directoryForm.Name] = orderedValue(directoryForm, index)
+			index++
 		}
 	}
 
@@ -53,6 +53,25 @@ var _ = Describe("FormsService", func() {
 			Expect(reader.ReadArgsForCall(1)).To(Equal("other-forms"))
 		})
 
+		It("records the order the forms were read in", func() {
+			reader.ReadReturnsOnCall(0, []builder.Part{
+				{Name: "some-form", Source: &builder.Source{Path: "some-forms/some-form.yml"}},
+			}, nil)
+			reader.ReadReturnsOnCall(1, []builder.Part{
+				{Name: "other-form", Source: &builder.Source{Path: "other-forms/other-form.yml"}},
+				{Name: "another-form", Source: &builder.Source{Path: "other-forms/another-form.yml"}},
+			}, nil)
+
+			forms, err := service.FromDirectories([]string{"some-forms", "other-forms"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(forms).To(Equal(map[string]interface{}{
+				"some-form":    builder.Source{Path: "some-forms/some-form.yml", Index: 0},
+				"other-form":   builder.Source{Path: "other-forms/other-form.yml", Index: 1},
+				"another-form": builder.Source{Path: "other-forms/another-form.yml", Index: 2},
+			}))
+		})
+
 		Context("when there are no directories to parse", func() {
 			It("returns nothing", func() {
 				forms, err := service.FromDirectories([]string{})
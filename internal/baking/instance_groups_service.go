@@ -20,6 +20,7 @@ func (igs InstanceGroupsService) FromDirectories(directories []string) (map[stri
 	igs.logger.Println("Reading instance group files...")
 
 	instanceGroups := map[string]interface{}{}
+	index := 0
 	for _, directory := range directories {
 		directoryInstanceGroups, err := igs.reader.Read(directory)
 		if err != nil {
@@ -27,7 +28,8 @@ func (igs InstanceGroupsService) FromDirectories(directories []string) (map[stri
 		}
 
 		for _, instanceGroup := range directoryInstanceGroups {
-			instanceGroups[instanceGroup.Name] = instanceGroup.Metadata
+			instanceGroups[instanceGroup.Name] = orderedValue(instanceGroup, index)
+			index++
 		}
 	}
 
@@ -12,6 +12,13 @@ type partReader interface {
 	Read(path string) (builder.Part, error)
 }
 
+// partCache is implemented by part readers that cache what they read, such as
+// builder.ReleaseManifestCache. The cache is saved once every tarball has
+// been read.
+type partCache interface {
+	Save()
+}
+
 //go:generate counterfeiter -o ./fakes/directory_reader.go --fake-name DirectoryReader . directoryReader
 type directoryReader interface {
 	Read(path string) ([]builder.Part, error)
@@ -20,6 +20,7 @@ func (js JobsService) FromDirectories(directories []string) (map[string]interfac
 	js.logger.Println("Reading jobs files...")
 
 	jobs := map[string]interface{}{}
+	index := 0
 	for _, directory := range directories {
 		directoryJobs, err := js.reader.Read(directory)
 		if err != nil {
@@ -27,7 +28,8 @@ func (js JobsService) FromDirectories(directories []string) (map[string]interfac
 		}
 
 		for _, job := range directoryJobs {
-			jobs[job.Name] = job.Metadata
+			jobs[job.Name] = orderedValue(job, index)
+			index++
 		}
 	}
 
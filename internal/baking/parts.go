@@ -0,0 +1,17 @@
+package baking
+
+import "github.com/pivotal-cf/kiln/builder"
+
+// orderedValue returns the value of a part read from a directory, recording
+// its position among the parts read so far so the all_* template helpers can
+// expand parts in the order they were read.
+func orderedValue(part builder.Part, index int) interface{} {
+	if part.Source == nil {
+		return part.Metadata
+	}
+
+	source := *part.Source
+	source.Index = index
+
+	return source
+}
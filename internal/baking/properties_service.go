@@ -20,6 +20,7 @@ func (ps PropertiesService) FromDirectories(directories []string) (map[string]in
 	ps.logger.Println("Reading property blueprint files...")
 
 	properties := map[string]interface{}{}
+	index := 0
 	for _, directory := range directories {
 		directoryProperties, err := ps.reader.Read(directory)
 		if err != nil {
@@ -27,7 +28,8 @@ func (ps PropertiesService) FromDirectories(directories []string) (map[string]in
 		}
 
 		for _, property := range directoryProperties {
-			properties[property.Name] = property.Metadata
+			properties[property.Name] = orderedValue(property, index)
+			index++
 		}
 	}
 
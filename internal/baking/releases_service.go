@@ -1,20 +1,31 @@
 package baking
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pivotal-cf/kiln/builder"
+	"github.com/pivotal-cf/kiln/internal/cargo"
+	"gopkg.in/src-d/go-billy.v4/osfs"
 )
 
 type ReleasesService struct {
-	logger logger
-	reader partReader
+	logger      logger
+	reader      partReader
+	concurrency int
 }
 
 func NewReleasesService(logger logger, reader partReader) ReleasesService {
 	return ReleasesService{
-		logger: logger,
-		reader: reader,
+		logger:      logger,
+		reader:      reader,
+		concurrency: runtime.NumCPU(),
 	}
 }
 
@@ -40,15 +51,158 @@ func (s ReleasesService) FromDirectories(directories []string) (map[string]inter
 		}
 	}
 
+	parts, err := s.readAll(tarballs)
+	if cache, ok := s.reader.(partCache); ok {
+		cache.Save()
+	}
+	if err != nil {
+		return nil, err
+	}
+
 	manifests := map[string]interface{}{}
-	for _, tarball := range tarballs {
-		manifest, err := s.reader.Read(tarball)
+	found := map[string]string{}
+	for i, part := range parts {
+		if tarball, ok := found[part.Name]; ok {
+			return nil, fmt.Errorf("more than one tarball was found for release '%s' when parsing releases: %s, %s", part.Name, tarball, tarballs[i])
+		}
+		found[part.Name] = tarballs[i]
+
+		manifests[part.Name] = part.Metadata
+	}
+
+	return manifests, nil
+}
+
+// readAll reads up to concurrency tarballs at a time. The parts are returned
+// in the order of tarballs and the error is the one for the first tarball
+// that failed, so the result does not depend on which read finishes first.
+func (s ReleasesService) readAll(tarballs []string) ([]builder.Part, error) {
+	parts := make([]builder.Part, len(tarballs))
+	errs := make([]error, len(tarballs))
+
+	workers := s.concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers && worker < len(tarballs); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				parts[i], errs[i] = s.reader.Read(tarballs[i])
+			}
+		}()
+	}
+
+	for i := range tarballs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, err := range errs {
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		manifests[manifest.Name] = manifest.Metadata
+	return parts, nil
+}
+
+// FromKilnfile returns a release manifest for every release in the
+// Kilnfile.lock next to kilnfilePath, named like the tarball kiln fetch
+// downloads for a release that is not compiled, since Kilnfile.lock does not
+// record how a release was compiled. It is used to stub releases without
+// their tarballs.
+func (s ReleasesService) FromKilnfile(kilnfilePath string) (map[string]interface{}, error) {
+	s.logger.Println(fmt.Sprintf("Reading releases from %s.lock...", filepath.Base(kilnfilePath)))
+
+	kilnfileLock, err := cargo.NewKilnfileLoader(osfs.New(""), cargo.NoInterpolation{}).LoadLock(kilnfilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := map[string]interface{}{}
+	for _, release := range kilnfileLock.Releases {
+		manifests[release.Name] = builder.ReleaseManifest{
+			Name:    release.Name,
+			Version: release.Version,
+			File:    cargo.ReleaseTarballName(release.Name, release.Version, "", ""),
+			SHA1:    release.SHA1,
+		}
 	}
 
 	return manifests, nil
 }
+
+// VerifyKilnfileLock checks the releases read by FromDirectories against the
+// Kilnfile.lock next to kilnfilePath. Every release must be locked with the
+// same version and sha1, compiled releases must be compiled against a locked
+// stemcell, and every locked release must have a tarball. All mismatches are
+// reported in one error.
+func (s ReleasesService) VerifyKilnfileLock(kilnfilePath string, releases map[string]interface{}) error {
+	kilnfileLock, err := cargo.NewKilnfileLoader(osfs.New(""), cargo.NoInterpolation{}).LoadLock(kilnfilePath)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Println(fmt.Sprintf("Verifying releases against %s.lock...", filepath.Base(kilnfilePath)))
+
+	lockedStemcells := map[cargo.Stemcell]bool{}
+	for _, stemcell := range append([]cargo.Stemcell{kilnfileLock.Stemcell}, kilnfileLock.AdditionalStemcells...) {
+		lockedStemcells[cargo.Stemcell{OS: stemcell.OS, Version: stemcell.Version}] = true
+	}
+
+	var problems []string
+	locked := map[string]bool{}
+	for _, lockedRelease := range kilnfileLock.Releases {
+		locked[lockedRelease.Name] = true
+
+		metadata, ok := releases[lockedRelease.Name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("release %s %s is in Kilnfile.lock but no tarball was found", lockedRelease.Name, lockedRelease.Version))
+			continue
+		}
+
+		release, ok := metadata.(builder.ReleaseManifest)
+		if !ok {
+			continue
+		}
+
+		if release.Version != lockedRelease.Version {
+			problems = append(problems, fmt.Sprintf("release %s in %s has version %s but Kilnfile.lock has %s", release.Name, release.File, release.Version, lockedRelease.Version))
+		}
+
+		if release.SHA1 != lockedRelease.SHA1 {
+			problems = append(problems, fmt.Sprintf("release %s in %s has sha1 %s but Kilnfile.lock has %s", release.Name, release.File, release.SHA1, lockedRelease.SHA1))
+		}
+
+		if release.StemcellOS != "" {
+			if !lockedStemcells[cargo.Stemcell{OS: release.StemcellOS, Version: release.StemcellVersion}] {
+				problems = append(problems, fmt.Sprintf("release %s in %s is compiled against stemcell %s %s which is not in Kilnfile.lock", release.Name, release.File, release.StemcellOS, release.StemcellVersion))
+			}
+		}
+	}
+
+	for name, metadata := range releases {
+		if locked[name] {
+			continue
+		}
+
+		file := name
+		if release, ok := metadata.(builder.ReleaseManifest); ok {
+			file = release.File
+		}
+		problems = append(problems, fmt.Sprintf("release %s in %s is not in Kilnfile.lock", name, file))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("release tarballs do not match Kilnfile.lock:\n%s", strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package baking_test
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -56,17 +57,22 @@ var _ = Describe("ReleasesService", func() {
 		})
 
 		It("parses the releases passed in a set of directories", func() {
-			reader.ReadReturnsOnCall(0, builder.Part{
-				File:     "some-file",
-				Name:     "some-name",
-				Metadata: "some-metadata",
-			}, nil)
-
-			reader.ReadReturnsOnCall(1, builder.Part{
-				File:     "other-file",
-				Name:     "other-name",
-				Metadata: "other-metadata",
-			}, nil)
+			reader.ReadStub = func(path string) (builder.Part, error) {
+				switch filepath.Base(path) {
+				case "some-release.tar.gz":
+					return builder.Part{
+						File:     "some-file",
+						Name:     "some-name",
+						Metadata: "some-metadata",
+					}, nil
+				default:
+					return builder.Part{
+						File:     "other-file",
+						Name:     "other-name",
+						Metadata: "other-metadata",
+					}, nil
+				}
+			}
 
 			releases, err := service.FromDirectories([]string{tempDir})
 			Expect(err).NotTo(HaveOccurred())
@@ -79,8 +85,23 @@ var _ = Describe("ReleasesService", func() {
 			Expect(logger.PrintlnArgsForCall(0)).To(Equal([]interface{}{"Reading release manifests..."}))
 
 			Expect(reader.ReadCallCount()).To(Equal(2))
-			Expect(reader.ReadArgsForCall(0)).To(Equal(filepath.Join(tempDir, "other-release.tgz")))
-			Expect(reader.ReadArgsForCall(1)).To(Equal(filepath.Join(tempDir, "some-release.tar.gz")))
+			Expect([]string{reader.ReadArgsForCall(0), reader.ReadArgsForCall(1)}).To(ConsistOf(
+				filepath.Join(tempDir, "other-release.tgz"),
+				filepath.Join(tempDir, "some-release.tar.gz"),
+			))
+		})
+
+		Context("when the reader caches what it reads", func() {
+			It("saves the cache once every tarball has been read", func() {
+				reader.ReadReturnsOnCall(0, builder.Part{Name: "some-name"}, nil)
+				reader.ReadReturnsOnCall(1, builder.Part{Name: "other-name"}, nil)
+				cache := &savingPartReader{PartReader: reader}
+				service = baking.NewReleasesService(logger, cache)
+
+				_, err := service.FromDirectories([]string{tempDir})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cache.saves).To(Equal(1))
+			})
 		})
 
 		Context("failure cases", func() {
@@ -99,6 +120,212 @@ var _ = Describe("ReleasesService", func() {
 					Expect(err).To(MatchError("failed to read release manifest"))
 				})
 			})
+
+			Context("when two tarballs contain the same release", func() {
+				It("returns an error naming both tarballs", func() {
+					reader.ReadReturns(builder.Part{Name: "some-name", Metadata: "some-metadata"}, nil)
+
+					_, err := service.FromDirectories([]string{tempDir})
+					Expect(err).To(MatchError(fmt.Sprintf("more than one tarball was found for release 'some-name' when parsing releases: %s, %s",
+						filepath.Join(tempDir, "other-release.tgz"),
+						filepath.Join(tempDir, "some-release.tar.gz"),
+					)))
+				})
+			})
+
+			Context("when several release manifests cannot be read", func() {
+				It("returns the error for the first tarball", func() {
+					reader.ReadStub = func(path string) (builder.Part, error) {
+						return builder.Part{}, errors.New("failed to read " + filepath.Base(path))
+					}
+
+					_, err := service.FromDirectories([]string{tempDir})
+					Expect(err).To(MatchError("failed to read other-release.tgz"))
+				})
+			})
+		})
+	})
+
+	Describe("FromKilnfile", func() {
+		var (
+			tempDir string
+			logger  *fakes.Logger
+			service baking.ReleasesService
+		)
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			logger = &fakes.Logger{}
+			service = baking.NewReleasesService(logger, &fakes.PartReader{})
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("returns the releases locked in the Kilnfile.lock", func() {
+			kilnfilePath := filepath.Join(tempDir, "Kilnfile")
+			Expect(ioutil.WriteFile(kilnfilePath+".lock", []byte(`---
+releases:
+- name: some-release
+  version: 1.2.3
+  sha1: some-sha1
+stemcell_criteria:
+  os: some-os
+  version: "7.8"
+`), 0644)).To(Succeed())
+
+			releases, err := service.FromKilnfile(kilnfilePath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(releases).To(Equal(map[string]interface{}{
+				"some-release": builder.ReleaseManifest{
+					Name:    "some-release",
+					Version: "1.2.3",
+					File:    "some-release-1.2.3.tgz",
+					SHA1:    "some-sha1",
+				},
+			}))
+
+			Expect(logger.PrintlnArgsForCall(0)).To(Equal([]interface{}{"Reading releases from Kilnfile.lock..."}))
+		})
+
+		Context("when the Kilnfile.lock does not exist", func() {
+			It("returns an error", func() {
+				_, err := service.FromKilnfile(filepath.Join(tempDir, "missing-Kilnfile"))
+				Expect(err).To(MatchError(ContainSubstring("missing-Kilnfile.lock: no such file or directory")))
+			})
+		})
+	})
+
+	Describe("VerifyKilnfileLock", func() {
+		var (
+			tempDir      string
+			kilnfilePath string
+			logger       *fakes.Logger
+			service      baking.ReleasesService
+			releases     map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			kilnfilePath = filepath.Join(tempDir, "Kilnfile")
+			Expect(ioutil.WriteFile(kilnfilePath+".lock", []byte(`---
+releases:
+- name: some-release
+  version: 1.2.3
+  sha1: some-sha1
+- name: compiled-release
+  version: 4.5.6
+  sha1: compiled-sha1
+stemcell_criteria:
+  os: some-os
+  version: "7.8"
+additional_stemcells_criteria:
+- os: other-os
+  version: "9.1"
+`), 0644)).To(Succeed())
+
+			releases = map[string]interface{}{
+				"some-release": builder.ReleaseManifest{
+					Name:    "some-release",
+					Version: "1.2.3",
+					File:    "some-release-1.2.3.tgz",
+					SHA1:    "some-sha1",
+				},
+				"compiled-release": builder.ReleaseManifest{
+					Name:            "compiled-release",
+					Version:         "4.5.6",
+					File:            "compiled-release-4.5.6-other-os-9.1.tgz",
+					SHA1:            "compiled-sha1",
+					StemcellOS:      "other-os",
+					StemcellVersion: "9.1",
+				},
+			}
+
+			logger = &fakes.Logger{}
+			service = baking.NewReleasesService(logger, &fakes.PartReader{})
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("accepts releases that match the Kilnfile.lock", func() {
+			Expect(service.VerifyKilnfileLock(kilnfilePath, releases)).To(Succeed())
+
+			Expect(logger.PrintlnArgsForCall(0)).To(Equal([]interface{}{"Verifying releases against Kilnfile.lock..."}))
+		})
+
+		Context("when the Kilnfile.lock does not lock any releases", func() {
+			It("reports every release as not locked", func() {
+				Expect(ioutil.WriteFile(kilnfilePath+".lock", []byte("stemcell_criteria:\n  os: some-os\n  version: \"7.8\"\n"), 0644)).To(Succeed())
+
+				err := service.VerifyKilnfileLock(kilnfilePath, releases)
+				Expect(err).To(MatchError(`release tarballs do not match Kilnfile.lock:
+release compiled-release in compiled-release-4.5.6-other-os-9.1.tgz is not in Kilnfile.lock
+release some-release in some-release-1.2.3.tgz is not in Kilnfile.lock`))
+			})
+		})
+
+		Context("when the releases do not match the Kilnfile.lock", func() {
+			It("reports every mismatch", func() {
+				releases["some-release"] = builder.ReleaseManifest{
+					Name:    "some-release",
+					Version: "1.2.4",
+					File:    "some-release-1.2.4.tgz",
+					SHA1:    "other-sha1",
+				}
+				releases["compiled-release"] = builder.ReleaseManifest{
+					Name:            "compiled-release",
+					Version:         "4.5.6",
+					File:            "compiled-release-4.5.6-some-os-7.9.tgz",
+					SHA1:            "compiled-sha1",
+					StemcellOS:      "some-os",
+					StemcellVersion: "7.9",
+				}
+				releases["extra-release"] = builder.ReleaseManifest{
+					Name: "extra-release",
+					File: "extra-release-1.0.0.tgz",
+				}
+
+				err := service.VerifyKilnfileLock(kilnfilePath, releases)
+				Expect(err).To(MatchError(`release tarballs do not match Kilnfile.lock:
+release compiled-release in compiled-release-4.5.6-some-os-7.9.tgz is compiled against stemcell some-os 7.9 which is not in Kilnfile.lock
+release extra-release in extra-release-1.0.0.tgz is not in Kilnfile.lock
+release some-release in some-release-1.2.4.tgz has sha1 other-sha1 but Kilnfile.lock has some-sha1
+release some-release in some-release-1.2.4.tgz has version 1.2.4 but Kilnfile.lock has 1.2.3`))
+			})
+		})
+
+		Context("when a locked release has no tarball", func() {
+			It("returns an error", func() {
+				delete(releases, "compiled-release")
+
+				err := service.VerifyKilnfileLock(kilnfilePath, releases)
+				Expect(err).To(MatchError(ContainSubstring("release compiled-release 4.5.6 is in Kilnfile.lock but no tarball was found")))
+			})
+		})
+
+		Context("when the Kilnfile.lock does not exist", func() {
+			It("returns an error", func() {
+				err := service.VerifyKilnfileLock(filepath.Join(tempDir, "missing-Kilnfile"), releases)
+				Expect(err).To(MatchError(ContainSubstring("missing-Kilnfile.lock: no such file or directory")))
+			})
 		})
 	})
 })
+
+type savingPartReader struct {
+	*fakes.PartReader
+	saves int
+}
+
+func (r *savingPartReader) Save() {
+	r.saves++
+}
@@ -20,6 +20,7 @@ func (rcs RuntimeConfigsService) FromDirectories(directories []string) (map[stri
 	rcs.logger.Println("Reading runtime config files...")
 
 	runtimeConfigs := map[string]interface{}{}
+	index := 0
 	for _, directory := range directories {
 		directoryRuntimeConfigs, err := rcs.reader.Read(directory)
 		if err != nil {
@@ -27,7 +28,8 @@ func (rcs RuntimeConfigsService) FromDirectories(directories []string) (map[stri
 		}
 
 		for _, runtimeConfig := range directoryRuntimeConfigs {
-			runtimeConfigs[runtimeConfig.Name] = runtimeConfig.Metadata
+			runtimeConfigs[runtimeConfig.Name] = orderedValue(runtimeConfig, index)
+			index++
 		}
 	}
 
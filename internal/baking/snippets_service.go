@@ -0,0 +1,80 @@
+package baking
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pivotal-cf/kiln/builder"
+	yaml "gopkg.in/yaml.v3"
+)
+
+type SnippetsService struct {
+	logger logger
+}
+
+func NewSnippetsService(logger logger) SnippetsService {
+	return SnippetsService{
+		logger: logger,
+	}
+}
+
+// FromDirectories reads the snippets in the directories. A snippet is named
+// after its path in the directory without the .yml extension, so
+// "syslog/forwarding.yml" is included with $( include "syslog/forwarding" ).
+func (ss SnippetsService) FromDirectories(directories []string) (map[string]interface{}, error) {
+	if len(directories) == 0 {
+		return nil, nil
+	}
+
+	ss.logger.Println("Reading snippet files...")
+
+	snippets := map[string]interface{}{}
+	for _, directory := range directories {
+		err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() || filepath.Ext(path) != ".yml" {
+				return nil
+			}
+
+			relativePath, err := filepath.Rel(directory, path)
+			if err != nil {
+				return err // should never happen
+			}
+			name := filepath.ToSlash(strings.TrimSuffix(relativePath, ".yml"))
+
+			if existing, ok := snippets[name]; ok {
+				return fmt.Errorf("more than one snippet is named '%s': %s, %s", name, existing.(builder.Source).Path, path)
+			}
+
+			contents, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			var document yaml.Node
+			err = yaml.Unmarshal(contents, &document)
+			if err != nil {
+				return fmt.Errorf("cannot unmarshal '%s': %s", path, err)
+			}
+
+			if len(document.Content) == 0 {
+				return fmt.Errorf("snippet '%s' is empty", path)
+			}
+
+			snippets[name] = builder.Source{Path: path, Node: document.Content[0]}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return snippets, nil
+}
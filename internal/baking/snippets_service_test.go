@@ -0,0 +1,102 @@
+package baking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/kiln/builder"
+	"github.com/pivotal-cf/kiln/internal/baking"
+	"github.com/pivotal-cf/kiln/internal/baking/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SnippetsService", func() {
+	Describe("FromDirectories", func() {
+		var (
+			tempDir string
+			logger  *fakes.Logger
+			service baking.SnippetsService
+		)
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(os.MkdirAll(filepath.Join(tempDir, "some-snippets", "syslog"), 0755)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(tempDir, "other-snippets"), 0755)).To(Succeed())
+
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "some-snippets", "tls.yml"), []byte("ca: $( .ca )\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "some-snippets", "syslog", "forwarding.yml"), []byte("address: $( .address )\n"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "some-snippets", "README.md"), []byte("not a snippet"), 0644)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "other-snippets", "network.yml"), []byte("- name: $( .name )\n"), 0644)).To(Succeed())
+
+			logger = &fakes.Logger{}
+			service = baking.NewSnippetsService(logger)
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("reads the snippets in a set of directories", func() {
+			snippets, err := service.FromDirectories([]string{
+				filepath.Join(tempDir, "some-snippets"),
+				filepath.Join(tempDir, "other-snippets"),
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(snippets).To(HaveLen(3))
+			Expect(snippets["tls"].(builder.Source).Path).To(Equal(filepath.Join(tempDir, "some-snippets", "tls.yml")))
+			Expect(snippets["tls"].(builder.Source).Node.Content[0].Value).To(Equal("ca"))
+			Expect(snippets["syslog/forwarding"].(builder.Source).Path).To(Equal(filepath.Join(tempDir, "some-snippets", "syslog", "forwarding.yml")))
+			Expect(snippets["network"].(builder.Source).Path).To(Equal(filepath.Join(tempDir, "other-snippets", "network.yml")))
+
+			Expect(logger.PrintlnCallCount()).To(Equal(1))
+			Expect(logger.PrintlnArgsForCall(0)).To(Equal([]interface{}{"Reading snippet files..."}))
+		})
+
+		Context("when there are no directories to parse", func() {
+			It("returns nothing", func() {
+				snippets, err := service.FromDirectories([]string{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(snippets).To(BeNil())
+
+				Expect(logger.PrintlnCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("failure cases", func() {
+			Context("when two directories have a snippet with the same name", func() {
+				It("returns an error", func() {
+					Expect(ioutil.WriteFile(filepath.Join(tempDir, "other-snippets", "tls.yml"), []byte("ca: other\n"), 0644)).To(Succeed())
+
+					_, err := service.FromDirectories([]string{
+						filepath.Join(tempDir, "some-snippets"),
+						filepath.Join(tempDir, "other-snippets"),
+					})
+					Expect(err).To(MatchError(ContainSubstring("more than one snippet is named 'tls'")))
+				})
+			})
+
+			Context("when a snippet is not valid YAML", func() {
+				It("returns an error", func() {
+					Expect(ioutil.WriteFile(filepath.Join(tempDir, "some-snippets", "bad.yml"), []byte("{{"), 0644)).To(Succeed())
+
+					_, err := service.FromDirectories([]string{filepath.Join(tempDir, "some-snippets")})
+					Expect(err).To(MatchError(ContainSubstring("cannot unmarshal")))
+				})
+			})
+
+			Context("when the directory does not exist", func() {
+				It("returns an error", func() {
+					_, err := service.FromDirectories([]string{filepath.Join(tempDir, "missing")})
+					Expect(err).To(HaveOccurred())
+				})
+			})
+		})
+	})
+})
@@ -1,16 +1,15 @@
 package baking
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 
 	"github.com/pivotal-cf/kiln/builder"
-	"gopkg.in/yaml.v2"
+	"github.com/pivotal-cf/kiln/internal/cargo"
+	"gopkg.in/src-d/go-billy.v4/osfs"
 )
 
 type StemcellService struct {
@@ -47,23 +46,42 @@ func (ss StemcellService) FromDirectories(directories []string) (stemcell map[st
 		}
 	}
 
-	manifests := map[string]interface{}{}
+	var stemcells []builder.StemcellManifest
 	for _, tarball := range tarballs {
 		manifest, err := ss.tarballReader.Read(tarball)
 		if err != nil {
 			return nil, err
 		}
-		stemcell := manifest.Metadata.(builder.StemcellManifest)
-		_, ok := manifests[stemcell.OperatingSystem]
-		if ok {
-			errorMsg := fmt.Sprintf("more than one OS version was found for OS '%s' when parsing stemcells", stemcell.OperatingSystem)
-			return nil, errors.New(errorMsg)
+		stemcells = append(stemcells, manifest.Metadata.(builder.StemcellManifest))
+	}
+
+	return stemcellManifestsByKey(stemcells), nil
+}
+
+// stemcellManifestsByKey keys each stemcell by its OS. When there are
+// several versions of an OS, each of them is keyed by "<os>/<version>"
+// instead, so the stemcell template helper has to be given a version.
+// Stemcells with the same OS and version have the same criteria and are only
+// kept once.
+func stemcellManifestsByKey(stemcells []builder.StemcellManifest) map[string]interface{} {
+	versions := map[string]map[string]bool{}
+	for _, stemcell := range stemcells {
+		if versions[stemcell.OperatingSystem] == nil {
+			versions[stemcell.OperatingSystem] = map[string]bool{}
 		}
+		versions[stemcell.OperatingSystem][stemcell.Version] = true
+	}
 
-		manifests[stemcell.OperatingSystem] = manifest.Metadata
+	manifests := map[string]interface{}{}
+	for _, stemcell := range stemcells {
+		key := stemcell.OperatingSystem
+		if len(versions[stemcell.OperatingSystem]) > 1 {
+			key = stemcell.OperatingSystem + "/" + stemcell.Version
+		}
+		manifests[key] = stemcell
 	}
 
-	return manifests, nil
+	return manifests
 }
 
 func (ss StemcellService) FromTarball(path string) (interface{}, error) {
@@ -85,35 +103,21 @@ func (ss StemcellService) FromKilnfile(kilnfilePath string) (map[string]interfac
 	kilnfileLockPath := fmt.Sprintf("%s.lock", kilnfilePath)
 	kilnfileLockBasename := path.Base(kilnfileLockPath)
 	ss.logger.Println(fmt.Sprintf("Reading stemcell criteria from %s", kilnfileLockBasename))
-	kilnfileLock, err := os.Open(kilnfileLockPath)
-	if err != nil {
-		return nil, err
-	}
-
-	type stemcellMetadata struct {
-		Version         string `yaml:"version"`
-		OperatingSystem string `yaml:"os"`
-	}
-
-	stemcellCriteria := struct {
-		Metadata stemcellMetadata `yaml:"stemcell_criteria"`
-	}{}
-
-	lockFileContent, err := ioutil.ReadAll(kilnfileLock)
+	kilnfileLock, err := cargo.NewKilnfileLoader(osfs.New(""), cargo.NoInterpolation{}).LoadLock(kilnfilePath)
 	if err != nil {
 		return nil, err
 	}
 
-	err = yaml.Unmarshal(lockFileContent, &stemcellCriteria)
-	if err != nil {
-		return nil, err
-	}
-
-	stemcell := stemcellCriteria.Metadata
-
-	stemcellManifest := map[string]interface{}{
-		stemcell.OperatingSystem: stemcellCriteria.Metadata,
+	stemcells := []builder.StemcellManifest{{
+		Version:         kilnfileLock.Stemcell.Version,
+		OperatingSystem: kilnfileLock.Stemcell.OS,
+	}}
+	for _, stemcell := range kilnfileLock.AdditionalStemcells {
+		stemcells = append(stemcells, builder.StemcellManifest{
+			Version:         stemcell.Version,
+			OperatingSystem: stemcell.OS,
+		})
 	}
 
-	return stemcellManifest, err
+	return stemcellManifestsByKey(stemcells), nil
 }
@@ -79,7 +79,7 @@ var _ = Describe("StemcellService", func() {
 			}))
 		})
 
-		It("warns if multiple OS versions are found", func() {
+		It("keys stemcells by OS and version when an OS has more than one version", func() {
 			reader.ReadReturnsOnCall(0, builder.Part{
 				Metadata: builder.StemcellManifest{
 					Version:         "version1",
@@ -94,8 +94,71 @@ var _ = Describe("StemcellService", func() {
 				},
 			}, nil)
 
-			_, err := service.FromDirectories([]string{tempDir})
-			Expect(err).To(MatchError("more than one OS version was found for OS 'some-os' when parsing stemcells"))
+			stemcell, err := service.FromDirectories([]string{tempDir})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stemcell).To(Equal(map[string]interface{}{
+				"some-os/version1": builder.StemcellManifest{
+					Version:         "version1",
+					OperatingSystem: "some-os",
+				},
+				"some-os/version2": builder.StemcellManifest{
+					Version:         "version2",
+					OperatingSystem: "some-os",
+				},
+			}))
+		})
+	})
+
+	Describe("FromKilnfile", func() {
+		var (
+			tempDir string
+			logger  *fakes.Logger
+			service baking.StemcellService
+		)
+
+		BeforeEach(func() {
+			var err error
+			tempDir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(filepath.Join(tempDir, "Kilnfile.lock"), []byte(`---
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.0"
+additional_stemcells_criteria:
+- os: ubuntu-xenial
+  version: "456.30"
+- os: windows2019
+  version: "2019.4"
+`), 0644)).To(Succeed())
+
+			logger = &fakes.Logger{}
+			service = baking.NewStemcellService(logger, &fakes.PartReader{})
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("returns the stemcell criteria and additional stemcell criteria from the Kilnfile.lock", func() {
+			stemcell, err := service.FromKilnfile(filepath.Join(tempDir, "Kilnfile"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stemcell).To(Equal(map[string]interface{}{
+				"ubuntu-xenial/621.0": builder.StemcellManifest{
+					Version:         "621.0",
+					OperatingSystem: "ubuntu-xenial",
+				},
+				"ubuntu-xenial/456.30": builder.StemcellManifest{
+					Version:         "456.30",
+					OperatingSystem: "ubuntu-xenial",
+				},
+				"windows2019": builder.StemcellManifest{
+					Version:         "2019.4",
+					OperatingSystem: "windows2019",
+				},
+			}))
+
+			Expect(logger.PrintlnArgsForCall(0)).To(Equal([]interface{}{"Reading stemcell criteria from Kilnfile.lock"}))
 		})
 	})
 
@@ -3,6 +3,7 @@ package baking
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
 
 	yaml "gopkg.in/yaml.v2"
@@ -15,29 +16,173 @@ func NewTemplateVariablesService() TemplateVariablesService {
 }
 
 func (s TemplateVariablesService) FromPathsAndPairs(paths []string, pairs []string) (map[string]interface{}, error) {
-	variables := map[string]interface{}{}
+	variables, _, err := s.Read(paths, pairs, nil)
+	return variables, err
+}
+
+// Read merges the variables files in order, then applies the key=value pairs
+// as strings and the key=<yaml> pairs as YAML. Maps are merged key by key, so
+// a later file only replaces the values it sets, and a dotted key such as
+// iaas.aws.region sets a nested value, whether it is given in a file or as a
+// pair. Alongside the variables it returns
+// where each one, by dotted key, was last set.
+func (s TemplateVariablesService) Read(paths, pairs, yamlPairs []string) (map[string]interface{}, map[string]string, error) {
+	variables := map[interface{}]interface{}{}
+	sources := map[string]string{}
 
 	for _, path := range paths {
 		content, err := ioutil.ReadFile(path)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
-		err = yaml.Unmarshal(content, &variables)
+		var fileVariables map[string]interface{}
+		err = yaml.Unmarshal(content, &fileVariables)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+
+		for _, key := range dottedKeysLast(fileVariables) {
+			setVariable(variables, key, expandDottedKeys(fileVariables[key]), path, sources)
 		}
 	}
 
 	for _, pair := range pairs {
-		parts := strings.Split(pair, "=")
+		key, value, err := splitVariablePair(pair, "key=value")
+		if err != nil {
+			return nil, nil, err
+		}
+
+		setVariable(variables, key, value, "--variable "+key, sources)
+	}
 
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("could not parse variable %q: expected variable in \"key=value\" form", pair)
+	for _, pair := range yamlPairs {
+		key, rawValue, err := splitVariablePair(pair, "key=<yaml>")
+		if err != nil {
+			return nil, nil, err
 		}
 
-		variables[parts[0]] = parts[1]
+		var value interface{}
+		err = yaml.Unmarshal([]byte(rawValue), &value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not parse variable %q: %s", pair, err)
+		}
+
+		setVariable(variables, key, value, "--variable-yaml "+key, sources)
+	}
+
+	result := map[string]interface{}{}
+	for key, value := range variables {
+		result[fmt.Sprint(key)] = value
+	}
+
+	return result, sources, nil
+}
+
+// splitVariablePair splits on the first =, so the value may contain = too.
+func splitVariablePair(pair, form string) (string, string, error) {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("could not parse variable %q: expected variable in %q form", pair, form)
+	}
+	return parts[0], parts[1], nil
+}
+
+// setVariable sets the value at the dotted key, creating the maps on the way
+// and replacing values on the way that are not maps.
+func setVariable(variables map[interface{}]interface{}, dottedKey string, value interface{}, source string, sources map[string]string) {
+	keys := strings.Split(dottedKey, ".")
+
+	parent := variables
+	for i, key := range keys[:len(keys)-1] {
+		child, ok := parent[key].(map[interface{}]interface{})
+		if !ok {
+			child = map[interface{}]interface{}{}
+			parent[key] = child
+			forgetSources(strings.Join(keys[:i+1], "."), sources)
+		}
+		parent = child
+	}
+
+	mergeVariable(parent, keys[len(keys)-1], dottedKey, value, source, sources)
+}
+
+// expandDottedKeys turns the dotted keys of the maps in value into nested
+// maps, the way setVariable does with the dotted keys of pairs.
+func expandDottedKeys(value interface{}) interface{} {
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return value
 	}
 
-	return variables, nil
+	keys := map[string]interface{}{}
+	expanded := map[interface{}]interface{}{}
+	for key, child := range m {
+		if name, isString := key.(string); isString {
+			keys[name] = child
+			continue
+		}
+		expanded[key] = expandDottedKeys(child)
+	}
+
+	for _, key := range dottedKeysLast(keys) {
+		setVariable(expanded, key, expandDottedKeys(keys[key]), "", map[string]string{})
+	}
+
+	return expanded
+}
+
+// dottedKeysLast sorts the keys so that dotted keys are applied after the
+// maps they set values in.
+func dottedKeysLast(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		iDots, jDots := strings.Count(keys[i], "."), strings.Count(keys[j], ".")
+		if iDots != jDots {
+			return iDots < jDots
+		}
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
+
+// mergeVariable merges a map into the map already at key and replaces any
+// other value, recording the source of every key it sets.
+func mergeVariable(parent map[interface{}]interface{}, key interface{}, path string, value interface{}, source string, sources map[string]string) {
+	existing, existingIsMap := parent[key].(map[interface{}]interface{})
+	incoming, incomingIsMap := value.(map[interface{}]interface{})
+
+	if existingIsMap && incomingIsMap {
+		for childKey, childValue := range incoming {
+			mergeVariable(existing, childKey, fmt.Sprintf("%s.%v", path, childKey), childValue, source, sources)
+		}
+		return
+	}
+
+	forgetSources(path, sources)
+	parent[key] = value
+	recordSources(path, value, source, sources)
+}
+
+func recordSources(path string, value interface{}, source string, sources map[string]string) {
+	sources[path] = source
+
+	if m, ok := value.(map[interface{}]interface{}); ok {
+		for key, child := range m {
+			recordSources(fmt.Sprintf("%s.%v", path, key), child, source, sources)
+		}
+	}
+}
+
+func forgetSources(path string, sources map[string]string) {
+	for key := range sources {
+		if key == path || strings.HasPrefix(key, path+".") {
+			delete(sources, key)
+		}
+	}
 }
@@ -3,6 +3,7 @@ package baking_test
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/pivotal-cf/kiln/internal/baking"
 
@@ -71,6 +72,12 @@ key-3: value-3
 			}))
 		})
 
+		It("keeps everything after the first = in a command-line value", func() {
+			variables, err := service.FromPathsAndPairs(nil, []string{"key-1=a=b"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(variables).To(Equal(map[string]interface{}{"key-1": "a=b"}))
+		})
+
 		Context("failure cases", func() {
 			Context("when the variable file cannot be read", func() {
 				It("returns an error", func() {
@@ -97,4 +104,142 @@ key-3: value-3
 			})
 		})
 	})
+
+	Describe("Read", func() {
+		var (
+			service  baking.TemplateVariablesService
+			tempDir  string
+			basePath string
+			awsPath  string
+		)
+
+		BeforeEach(func() {
+			service = baking.NewTemplateVariablesService()
+
+			var err error
+			tempDir, err = ioutil.TempDir("", "variables")
+			Expect(err).NotTo(HaveOccurred())
+
+			basePath = filepath.Join(tempDir, "base.yml")
+			Expect(ioutil.WriteFile(basePath, []byte(`---
+edition: community
+iaas:
+  name: vsphere
+  aws:
+    region: us-east-1
+    zones: [a, b]
+`), 0644)).To(Succeed())
+
+			awsPath = filepath.Join(tempDir, "aws.yml")
+			Expect(ioutil.WriteFile(awsPath, []byte(`---
+iaas:
+  name: aws
+  aws:
+    region: us-west-2
+`), 0644)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(tempDir)).To(Succeed())
+		})
+
+		It("merges maps from later files into earlier ones", func() {
+			variables, sources, err := service.Read([]string{basePath, awsPath}, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(variables).To(Equal(map[string]interface{}{
+				"edition": "community",
+				"iaas": map[interface{}]interface{}{
+					"name": "aws",
+					"aws": map[interface{}]interface{}{
+						"region": "us-west-2",
+						"zones":  []interface{}{"a", "b"},
+					},
+				},
+			}))
+			Expect(sources).To(Equal(map[string]string{
+				"edition":         basePath,
+				"iaas":            basePath,
+				"iaas.name":       awsPath,
+				"iaas.aws":        basePath,
+				"iaas.aws.region": awsPath,
+				"iaas.aws.zones":  basePath,
+			}))
+		})
+
+		It("sets nested values from dotted keys and parses YAML values", func() {
+			variables, sources, err := service.Read([]string{basePath}, []string{
+				"iaas.aws.region=eu-central-1",
+				"password=a=b",
+			}, []string{
+				"iaas.aws.zones=[c]",
+				"instances=3",
+				"edition={name: enterprise}",
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(variables).To(Equal(map[string]interface{}{
+				"edition": map[interface{}]interface{}{
+					"name": "enterprise",
+				},
+				"iaas": map[interface{}]interface{}{
+					"name": "vsphere",
+					"aws": map[interface{}]interface{}{
+						"region": "eu-central-1",
+						"zones":  []interface{}{"c"},
+					},
+				},
+				"instances": 3,
+				"password":  "a=b",
+			}))
+			Expect(sources).To(HaveKeyWithValue("iaas.aws.region", "--variable iaas.aws.region"))
+			Expect(sources).To(HaveKeyWithValue("iaas.aws.zones", "--variable-yaml iaas.aws.zones"))
+			Expect(sources).To(HaveKeyWithValue("edition.name", "--variable-yaml edition"))
+			Expect(sources).To(HaveKeyWithValue("iaas.name", basePath))
+		})
+
+		It("sets nested values from dotted keys in files like from pairs", func() {
+			dottedPath := filepath.Join(tempDir, "dotted.yml")
+			Expect(ioutil.WriteFile(dottedPath, []byte(`---
+iaas.aws.region: eu-west-1
+iaas:
+  aws.zones: [d]
+`), 0644)).To(Succeed())
+
+			fromFile, sources, err := service.Read([]string{basePath, dottedPath}, nil, nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			fromPairs, _, err := service.Read([]string{basePath}, []string{"iaas.aws.region=eu-west-1"}, []string{"iaas.aws.zones=[d]"})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fromFile).To(Equal(fromPairs))
+			Expect(fromFile).To(Equal(map[string]interface{}{
+				"edition": "community",
+				"iaas": map[interface{}]interface{}{
+					"name": "vsphere",
+					"aws": map[interface{}]interface{}{
+						"region": "eu-west-1",
+						"zones":  []interface{}{"d"},
+					},
+				},
+			}))
+			Expect(sources).To(HaveKeyWithValue("iaas.aws.region", dottedPath))
+			Expect(sources).To(HaveKeyWithValue("iaas.aws.zones", dottedPath))
+			Expect(sources).To(HaveKeyWithValue("iaas.name", basePath))
+		})
+
+		Context("failure cases", func() {
+			Context("when a YAML variable is not valid YAML", func() {
+				It("returns an error", func() {
+					_, _, err := service.Read(nil, nil, []string{"key={"})
+					Expect(err).To(MatchError(ContainSubstring(`could not parse variable "key={": yaml:`)))
+				})
+			})
+
+			Context("when a YAML variable is malformed", func() {
+				It("returns an error", func() {
+					_, _, err := service.Read(nil, nil, []string{"garbage"})
+					Expect(err).To(MatchError("could not parse variable \"garbage\": expected variable in \"key=<yaml>\" form"))
+				})
+			})
+		})
+	})
 })
@@ -0,0 +1,366 @@
+package baking
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pivotal-cf/kiln/builder"
+	"gopkg.in/yaml.v2"
+)
+
+// VariableDeclaration declares a template variable. The name may be a dotted
+// key such as iaas.aws.region for a value nested in a map. An empty type
+// accepts any value. The values of sensitive variables must not appear in
+// the baked tile.
+type VariableDeclaration struct {
+	Name        string      `yaml:"name"`
+	Type        string      `yaml:"type"`
+	Required    bool        `yaml:"required"`
+	Sensitive   bool        `yaml:"sensitive"`
+	Default     interface{} `yaml:"default"`
+	Description string      `yaml:"description"`
+}
+
+// VariablesSchema declares the variables a tile's metadata uses.
+type VariablesSchema []VariableDeclaration
+
+var variableTypes = []string{"string", "boolean", "integer", "number", "list", "map"}
+
+type VariablesSchemaService struct{}
+
+func NewVariablesSchemaService() VariablesSchemaService {
+	return VariablesSchemaService{}
+}
+
+// FromPath reads a variables schema, a YAML list of variable declarations,
+// and checks that the declarations themselves are valid.
+func (s VariablesSchemaService) FromPath(path string) (VariablesSchema, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema VariablesSchema
+	err = yaml.Unmarshal(contents, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal '%s': %s", path, err)
+	}
+
+	names := map[string]bool{}
+	for _, declaration := range schema {
+		if declaration.Name == "" {
+			return nil, fmt.Errorf("%s: a variable is missing a name", path)
+		}
+
+		if names[declaration.Name] {
+			return nil, fmt.Errorf("%s: variable %q is declared more than once", path, declaration.Name)
+		}
+		names[declaration.Name] = true
+
+		if declaration.Type != "" && !containsString(variableTypes, declaration.Type) {
+			return nil, fmt.Errorf("%s: variable %q has unknown type %q (expected one of %s)", path, declaration.Name, declaration.Type, strings.Join(variableTypes, ", "))
+		}
+
+		if declaration.Default != nil && !hasVariableType(declaration.Default, declaration.Type) {
+			return nil, fmt.Errorf("%s: the default of variable %q is %s, expected %s", path, declaration.Name, variableTypeOf(declaration.Default), article(declaration.Type))
+		}
+	}
+
+	return schema, nil
+}
+
+// Check compares the variables with the schema and returns them with the
+// defaults of the variables that were not set filled in. Every missing,
+// mistyped and undeclared variable is reported in one error, naming the file
+// or flag each value came from.
+func (schema VariablesSchema) Check(variables map[string]interface{}, sources map[string]string) (map[string]interface{}, error) {
+	var problems []string
+
+	declared := map[string]bool{}
+	for _, declaration := range schema {
+		declared[declaration.Name] = true
+
+		value, ok := findVariable(variables, declaration.Name)
+		switch {
+		case !ok && declaration.Default != nil:
+			variables = setVariableDefault(variables, declaration.Name, declaration.Default)
+		case !ok && declaration.Required:
+			problem := fmt.Sprintf("missing required variable %q", declaration.Name)
+			if declaration.Description != "" {
+				problem += ": " + declaration.Description
+			}
+			problems = append(problems, problem)
+		case ok && !hasVariableType(value, declaration.Type):
+			problem := fmt.Sprintf("variable %q%s is %s, expected %s", declaration.Name, variableSource(sources, declaration.Name), variableTypeOf(value), article(declaration.Type))
+			if strings.HasPrefix(sources[declaration.Name], "--variable ") {
+				problem += " (use --variable-yaml for values that are not strings)"
+			}
+			problems = append(problems, problem)
+		}
+	}
+
+	var undeclared []string
+	for key, value := range variables {
+		undeclared = append(undeclared, undeclaredVariables(key, value, declared)...)
+	}
+	sort.Strings(undeclared)
+	for _, name := range undeclared {
+		problems = append(problems, fmt.Sprintf("variable %q%s is not declared in the schema", name, variableSource(sources, name)))
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.New("- " + strings.Join(problems, "\n- "))
+	}
+
+	return variables, nil
+}
+
+// SensitiveValues returns the values of the variables the schema declares
+// sensitive. Lists and maps contribute each value in them.
+func (schema VariablesSchema) SensitiveValues(variables map[string]interface{}) []builder.SensitiveValue {
+	var values []builder.SensitiveValue
+	for _, declaration := range schema {
+		if !declaration.Sensitive {
+			continue
+		}
+
+		value, ok := findVariable(variables, declaration.Name)
+		if !ok {
+			continue
+		}
+
+		for _, scalar := range scalarValues(value) {
+			values = append(values, builder.SensitiveValue{
+				Source: fmt.Sprintf("sensitive variable %q", declaration.Name),
+				Value:  scalar,
+			})
+		}
+	}
+
+	return values
+}
+
+func scalarValues(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var scalars []string
+		for _, item := range v {
+			scalars = append(scalars, scalarValues(item)...)
+		}
+		return scalars
+	case map[interface{}]interface{}:
+		var scalars []string
+		for _, item := range v {
+			scalars = append(scalars, scalarValues(item)...)
+		}
+		sort.Strings(scalars)
+		return scalars
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
+
+// String lists the declarations as a table.
+func (schema VariablesSchema) String() string {
+	var rows [][]string
+	rows = append(rows, []string{"NAME", "TYPE", "REQUIRED", "DEFAULT", "DESCRIPTION"})
+	for _, declaration := range schema {
+		variableType := declaration.Type
+		if variableType == "" {
+			variableType = "any"
+		}
+
+		defaultValue := ""
+		if declaration.Default != nil {
+			defaultValue = formatVariable(declaration.Default)
+		}
+
+		rows = append(rows, []string{declaration.Name, variableType, fmt.Sprint(declaration.Required), defaultValue, declaration.Description})
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	var lines []string
+	for _, row := range rows {
+		var cells []string
+		for i, cell := range row {
+			cells = append(cells, cell+strings.Repeat(" ", widths[i]-len(cell)))
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, "  "), " "))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// undeclaredVariables returns the dotted keys under key that the schema does
+// not declare. A declared map covers everything in it.
+func undeclaredVariables(key string, value interface{}, declared map[string]bool) []string {
+	if declared[key] {
+		return nil
+	}
+
+	m, isMap := value.(map[interface{}]interface{})
+	if !isMap || !declaresNested(key, declared) {
+		return []string{key}
+	}
+
+	var undeclared []string
+	for childKey, childValue := range m {
+		undeclared = append(undeclared, undeclaredVariables(fmt.Sprintf("%s.%v", key, childKey), childValue, declared)...)
+	}
+
+	return undeclared
+}
+
+func declaresNested(key string, declared map[string]bool) bool {
+	for name := range declared {
+		if strings.HasPrefix(name, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// findVariable looks a variable up by its key or by a dotted key into nested
+// maps, the same way the variable template helper does.
+func findVariable(variables map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := variables[name]; ok {
+		return value, true
+	}
+
+	keys := strings.Split(name, ".")
+	value, ok := variables[keys[0]]
+	for _, key := range keys[1:] {
+		if !ok {
+			return nil, false
+		}
+
+		m, isMap := value.(map[interface{}]interface{})
+		if !isMap {
+			return nil, false
+		}
+		value, ok = m[key]
+	}
+
+	return value, ok
+}
+
+func setVariableDefault(variables map[string]interface{}, name string, value interface{}) map[string]interface{} {
+	if variables == nil {
+		variables = map[string]interface{}{}
+	}
+
+	nested := map[interface{}]interface{}{}
+	for key, value := range variables {
+		nested[key] = value
+	}
+	setVariable(nested, name, value, "", map[string]string{})
+
+	for key, value := range nested {
+		variables[fmt.Sprint(key)] = value
+	}
+
+	return variables
+}
+
+func hasVariableType(value interface{}, variableType string) bool {
+	actual := variableTypeOf(value)
+	switch variableType {
+	case "":
+		return true
+	case "number":
+		return actual == "an integer" || actual == "a number"
+	default:
+		return actual == article(variableType)
+	}
+}
+
+func variableTypeOf(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case int, int64, uint64:
+		return "an integer"
+	case float64:
+		return "a number"
+	case []interface{}:
+		return "a list"
+	case map[interface{}]interface{}, map[string]interface{}:
+		return "a map"
+	case nil:
+		return "null"
+	default:
+		return fmt.Sprintf("a %T", value)
+	}
+}
+
+func article(variableType string) string {
+	if variableType == "integer" {
+		return "an integer"
+	}
+	return "a " + variableType
+}
+
+func variableSource(sources map[string]string, name string) string {
+	if source, ok := sources[name]; ok {
+		return " from " + source
+	}
+	return ""
+}
+
+func formatVariable(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+
+	output, err := json.Marshal(jsonValue(value))
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(output)
+}
+
+// jsonValue converts the maps YAML decodes into maps with string keys, which
+// is all encoding/json can marshal.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			items = append(items, jsonValue(item))
+		}
+		return items
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return m
+	default:
+		return value
+	}
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
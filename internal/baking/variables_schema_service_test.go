@@ -0,0 +1,232 @@
+package baking_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pivotal-cf/kiln/builder"
+	"github.com/pivotal-cf/kiln/internal/baking"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VariablesSchemaService", func() {
+	var (
+		service baking.VariablesSchemaService
+		tempDir string
+		path    string
+	)
+
+	BeforeEach(func() {
+		service = baking.NewVariablesSchemaService()
+
+		var err error
+		tempDir, err = ioutil.TempDir("", "variables-schema")
+		Expect(err).NotTo(HaveOccurred())
+
+		path = filepath.Join(tempDir, "variables-schema.yml")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(tempDir)).To(Succeed())
+	})
+
+	Describe("FromPath", func() {
+		It("reads the variable declarations", func() {
+			Expect(ioutil.WriteFile(path, []byte(`---
+- name: edition
+  type: string
+  required: true
+  description: Which edition to build
+- name: iaas.aws.azs
+  type: list
+  default: [a, b]
+- name: password
+  sensitive: true
+- name: anything
+`), 0644)).To(Succeed())
+
+			schema, err := service.FromPath(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(schema).To(Equal(baking.VariablesSchema{
+				{Name: "edition", Type: "string", Required: true, Description: "Which edition to build"},
+				{Name: "iaas.aws.azs", Type: "list", Default: []interface{}{"a", "b"}},
+				{Name: "password", Sensitive: true},
+				{Name: "anything"},
+			}))
+		})
+
+		Context("failure cases", func() {
+			DescribeTable("invalid declarations",
+				func(contents, message string) {
+					Expect(ioutil.WriteFile(path, []byte(contents), 0644)).To(Succeed())
+
+					_, err := service.FromPath(path)
+					Expect(err).To(MatchError(path + ": " + message))
+				},
+				Entry("a missing name", `[{type: string}]`, "a variable is missing a name"),
+				Entry("a duplicate name", `[{name: edition}, {name: edition}]`, `variable "edition" is declared more than once`),
+				Entry("an unknown type", `[{name: edition, type: text}]`, `variable "edition" has unknown type "text" (expected one of string, boolean, integer, number, list, map)`),
+				Entry("a mistyped default", `[{name: instances, type: integer, default: three}]`, `the default of variable "instances" is a string, expected an integer`),
+			)
+
+			It("returns an error when the file cannot be read", func() {
+				_, err := service.FromPath(path)
+				Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+			})
+		})
+	})
+
+	Describe("Check", func() {
+		var schema baking.VariablesSchema
+
+		BeforeEach(func() {
+			schema = baking.VariablesSchema{
+				{Name: "edition", Type: "string", Required: true},
+				{Name: "instances", Type: "integer", Default: 1},
+				{Name: "ratio", Type: "number"},
+				{Name: "iaas.aws.region", Type: "string", Default: "us-east-1"},
+				{Name: "network", Type: "map"},
+			}
+		})
+
+		It("fills in the defaults of the variables that are not set", func() {
+			variables, err := schema.Check(map[string]interface{}{
+				"edition": "enterprise",
+				"ratio":   2,
+				"iaas": map[interface{}]interface{}{
+					"aws": map[interface{}]interface{}{},
+				},
+				"network": map[interface{}]interface{}{
+					"name": "some-network",
+				},
+			}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(variables).To(Equal(map[string]interface{}{
+				"edition":   "enterprise",
+				"instances": 1,
+				"ratio":     2,
+				"iaas": map[interface{}]interface{}{
+					"aws": map[interface{}]interface{}{
+						"region": "us-east-1",
+					},
+				},
+				"network": map[interface{}]interface{}{
+					"name": "some-network",
+				},
+			}))
+		})
+
+		It("reports every missing, mistyped and undeclared variable", func() {
+			_, err := schema.Check(map[string]interface{}{
+				"instances": "3",
+				"iaas": map[interface{}]interface{}{
+					"name": "aws",
+					"aws": map[interface{}]interface{}{
+						"region": "us-west-2",
+					},
+				},
+				"editon": "enterprise",
+			}, map[string]string{
+				"instances":       "--variable instances",
+				"iaas":            "variables.yml",
+				"iaas.name":       "variables.yml",
+				"iaas.aws":        "variables.yml",
+				"iaas.aws.region": "variables.yml",
+				"editon":          "--variable editon",
+			})
+			Expect(err).To(MatchError(`- missing required variable "edition"
+- variable "instances" from --variable instances is a string, expected an integer (use --variable-yaml for values that are not strings)
+- variable "editon" from --variable editon is not declared in the schema
+- variable "iaas.name" from variables.yml is not declared in the schema`))
+		})
+	})
+
+	Context("when the schema file has a map default", func() {
+		It("checks the nested variables of the default", func() {
+			Expect(ioutil.WriteFile(path, []byte(`---
+- name: iaas
+  type: map
+  default: {aws: {region: us-east-1}}
+- name: iaas.aws.region
+  type: string
+  required: true
+- name: credentials
+  type: map
+  sensitive: true
+  default: {user: admin, password: {value: some-password}}
+`), 0644)).To(Succeed())
+
+			schema, err := service.FromPath(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			variables, err := schema.Check(map[string]interface{}{}, nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(variables).To(Equal(map[string]interface{}{
+				"iaas": map[interface{}]interface{}{
+					"aws": map[interface{}]interface{}{
+						"region": "us-east-1",
+					},
+				},
+				"credentials": map[interface{}]interface{}{
+					"user": "admin",
+					"password": map[interface{}]interface{}{
+						"value": "some-password",
+					},
+				},
+			}))
+
+			Expect(schema.SensitiveValues(variables)).To(Equal([]builder.SensitiveValue{
+				{Source: `sensitive variable "credentials"`, Value: "admin"},
+				{Source: `sensitive variable "credentials"`, Value: "some-password"},
+			}))
+		})
+	})
+
+	Describe("SensitiveValues", func() {
+		It("returns the values of the sensitive variables", func() {
+			schema := baking.VariablesSchema{
+				{Name: "edition"},
+				{Name: "password", Sensitive: true},
+				{Name: "iaas.aws.keys", Sensitive: true},
+				{Name: "unset", Sensitive: true},
+			}
+
+			values := schema.SensitiveValues(map[string]interface{}{
+				"edition":  "enterprise",
+				"password": "some-password",
+				"iaas": map[interface{}]interface{}{
+					"aws": map[interface{}]interface{}{
+						"keys": []interface{}{"some-key", 1234},
+					},
+				},
+			})
+			Expect(values).To(Equal([]builder.SensitiveValue{
+				{Source: `sensitive variable "password"`, Value: "some-password"},
+				{Source: `sensitive variable "iaas.aws.keys"`, Value: "some-key"},
+				{Source: `sensitive variable "iaas.aws.keys"`, Value: "1234"},
+			}))
+		})
+	})
+
+	Describe("String", func() {
+		It("lists the declarations as a table", func() {
+			schema := baking.VariablesSchema{
+				{Name: "edition", Type: "string", Required: true, Description: "Which edition to build"},
+				{Name: "azs", Type: "list", Default: []interface{}{"a", "b"}},
+				{Name: "iaas", Type: "map", Default: map[interface{}]interface{}{"aws": map[interface{}]interface{}{"region": "us-east-1"}}},
+				{Name: "anything"},
+			}
+
+			Expect(schema.String()).To(Equal(`NAME      TYPE    REQUIRED  DEFAULT                         DESCRIPTION
+edition   string  true                                      Which edition to build
+azs       list    false     ["a","b"]
+iaas      map     false     {"aws":{"region":"us-east-1"}}
+anything  any     false
+`))
+		})
+	})
+})
@@ -0,0 +1,169 @@
+package cargo
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BakeConfig holds the arguments to `kiln bake` so they can live in the
+// Kilnfile instead of a wrapper script. Paths are relative to the Kilnfile
+// that sets them. Booleans are pointers so that a profile can turn off what
+// the bake section turns on.
+type BakeConfig struct {
+	Metadata                  string            `yaml:"metadata"`
+	OutputFile                string            `yaml:"output_file"`
+	Version                   string            `yaml:"version"`
+	Icon                      string            `yaml:"icon"`
+	ReleasesDirectories       []string          `yaml:"releases_directories"`
+	BOSHVariablesDirectories  []string          `yaml:"bosh_variables_directories"`
+	FormsDirectories          []string          `yaml:"forms_directories"`
+	InstanceGroupsDirectories []string          `yaml:"instance_groups_directories"`
+	JobsDirectories           []string          `yaml:"jobs_directories"`
+	PropertiesDirectories     []string          `yaml:"properties_directories"`
+	RuntimeConfigsDirectories []string          `yaml:"runtime_configs_directories"`
+	SnippetsDirectories       []string          `yaml:"snippets_directories"`
+	MigrationsDirectories     []string          `yaml:"migrations_directories"`
+	Embed                     []string          `yaml:"embed"`
+	VariablesFiles            []string          `yaml:"variables_files"`
+	OpsFiles                  []string          `yaml:"ops_files"`
+	SecretPatterns            []string          `yaml:"secret_patterns"`
+	VariablesSchema           string            `yaml:"variables_schema"`
+	Variables                 map[string]string `yaml:"variables"`
+	StubReleases              *bool             `yaml:"stub_releases"`
+	Sha256                    *bool             `yaml:"sha256"`
+	SkipDefaultSecretPatterns *bool             `yaml:"skip_default_secret_patterns"`
+}
+
+// BakeConfig returns the bake section with the named profile from
+// bake_profiles applied. An empty name returns the bake section unchanged.
+func (kilnfile Kilnfile) BakeConfig(profile string) (BakeConfig, error) {
+	if profile == "" {
+		return kilnfile.Bake, nil
+	}
+
+	profileConfig, ok := kilnfile.BakeProfiles[profile]
+	if !ok {
+		var names []string
+		for name := range kilnfile.BakeProfiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return BakeConfig{}, fmt.Errorf("bake profile %q not found in Kilnfile (available profiles: %s)", profile, strings.Join(names, ", "))
+	}
+
+	return kilnfile.Bake.merge(profileConfig), nil
+}
+
+// merge applies overlay onto config. Strings and booleans overlay sets
+// replace those of config; lists are combined without duplicates and
+// variables are merged with overlay winning.
+func (config BakeConfig) merge(overlay BakeConfig) BakeConfig {
+	merged := config
+
+	for _, field := range []struct{ value, overlay *string }{
+		{&merged.Metadata, &overlay.Metadata},
+		{&merged.OutputFile, &overlay.OutputFile},
+		{&merged.Version, &overlay.Version},
+		{&merged.Icon, &overlay.Icon},
+		{&merged.VariablesSchema, &overlay.VariablesSchema},
+	} {
+		if *field.overlay != "" {
+			*field.value = *field.overlay
+		}
+	}
+
+	merged.ReleasesDirectories = combine(config.ReleasesDirectories, overlay.ReleasesDirectories)
+	merged.BOSHVariablesDirectories = combine(config.BOSHVariablesDirectories, overlay.BOSHVariablesDirectories)
+	merged.FormsDirectories = combine(config.FormsDirectories, overlay.FormsDirectories)
+	merged.InstanceGroupsDirectories = combine(config.InstanceGroupsDirectories, overlay.InstanceGroupsDirectories)
+	merged.JobsDirectories = combine(config.JobsDirectories, overlay.JobsDirectories)
+	merged.PropertiesDirectories = combine(config.PropertiesDirectories, overlay.PropertiesDirectories)
+	merged.RuntimeConfigsDirectories = combine(config.RuntimeConfigsDirectories, overlay.RuntimeConfigsDirectories)
+	merged.SnippetsDirectories = combine(config.SnippetsDirectories, overlay.SnippetsDirectories)
+	merged.MigrationsDirectories = combine(config.MigrationsDirectories, overlay.MigrationsDirectories)
+	merged.Embed = combine(config.Embed, overlay.Embed)
+	merged.VariablesFiles = combine(config.VariablesFiles, overlay.VariablesFiles)
+	merged.OpsFiles = combine(config.OpsFiles, overlay.OpsFiles)
+	merged.SecretPatterns = combine(config.SecretPatterns, overlay.SecretPatterns)
+
+	if len(config.Variables)+len(overlay.Variables) > 0 {
+		merged.Variables = map[string]string{}
+		for name, value := range config.Variables {
+			merged.Variables[name] = value
+		}
+		for name, value := range overlay.Variables {
+			merged.Variables[name] = value
+		}
+	}
+
+	for _, field := range []struct{ value, overlay **bool }{
+		{&merged.StubReleases, &overlay.StubReleases},
+		{&merged.Sha256, &overlay.Sha256},
+		{&merged.SkipDefaultSecretPatterns, &overlay.SkipDefaultSecretPatterns},
+	} {
+		if *field.overlay != nil {
+			*field.value = *field.overlay
+		}
+	}
+
+	return merged
+}
+
+// relativeTo rewrites the paths in config so they are relative to directory
+// instead of the Kilnfile that set them.
+func (config BakeConfig) relativeTo(directory string) BakeConfig {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(directory, path)
+	}
+	resolveAll := func(paths []string) []string {
+		if paths == nil {
+			return nil
+		}
+		resolved := make([]string, 0, len(paths))
+		for _, path := range paths {
+			resolved = append(resolved, resolve(path))
+		}
+		return resolved
+	}
+
+	config.Metadata = resolve(config.Metadata)
+	config.OutputFile = resolve(config.OutputFile)
+	config.Icon = resolve(config.Icon)
+	config.VariablesSchema = resolve(config.VariablesSchema)
+	config.ReleasesDirectories = resolveAll(config.ReleasesDirectories)
+	config.BOSHVariablesDirectories = resolveAll(config.BOSHVariablesDirectories)
+	config.FormsDirectories = resolveAll(config.FormsDirectories)
+	config.InstanceGroupsDirectories = resolveAll(config.InstanceGroupsDirectories)
+	config.JobsDirectories = resolveAll(config.JobsDirectories)
+	config.PropertiesDirectories = resolveAll(config.PropertiesDirectories)
+	config.RuntimeConfigsDirectories = resolveAll(config.RuntimeConfigsDirectories)
+	config.SnippetsDirectories = resolveAll(config.SnippetsDirectories)
+	config.MigrationsDirectories = resolveAll(config.MigrationsDirectories)
+	config.Embed = resolveAll(config.Embed)
+	config.VariablesFiles = resolveAll(config.VariablesFiles)
+	config.OpsFiles = resolveAll(config.OpsFiles)
+
+	return config
+}
+
+func combine(values, others []string) []string {
+	combined := append([]string(nil), values...)
+	for _, other := range others {
+		found := false
+		for _, value := range combined {
+			if value == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			combined = append(combined, other)
+		}
+	}
+	return combined
+}
@@ -0,0 +1,86 @@
+package cargo_test
+
+import (
+	"github.com/pivotal-cf/kiln/internal/cargo"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Kilnfile", func() {
+	Describe("BakeConfig", func() {
+		var kilnfile cargo.Kilnfile
+
+		BeforeEach(func() {
+			kilnfile = cargo.Kilnfile{
+				Bake: cargo.BakeConfig{
+					Metadata:         "base.yml",
+					Version:          "1.2.3",
+					FormsDirectories: []string{"forms"},
+					Variables:        map[string]string{"a": "1", "b": "2"},
+					Sha256:           boolPointer(true),
+				},
+				BakeProfiles: map[string]cargo.BakeConfig{
+					"dev": {
+						Version:          "0.0.0-dev",
+						FormsDirectories: []string{"forms", "dev-forms"},
+						Variables:        map[string]string{"b": "3"},
+						StubReleases:     boolPointer(true),
+						Sha256:           boolPointer(false),
+					},
+				},
+			}
+		})
+
+		It("returns the bake section without a profile", func() {
+			config, err := kilnfile.BakeConfig("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).To(Equal(kilnfile.Bake))
+		})
+
+		It("applies the profile onto the bake section, turning off what it turns off", func() {
+			config, err := kilnfile.BakeConfig("dev")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).To(Equal(cargo.BakeConfig{
+				Metadata:         "base.yml",
+				Version:          "0.0.0-dev",
+				FormsDirectories: []string{"forms", "dev-forms"},
+				Variables:        map[string]string{"a": "1", "b": "3"},
+				StubReleases:     boolPointer(true),
+				Sha256:           boolPointer(false),
+			}))
+			Expect(kilnfile.Bake.FormsDirectories).To(Equal([]string{"forms"}))
+		})
+
+		It("returns an error for an unknown profile", func() {
+			_, err := kilnfile.BakeConfig("ci")
+			Expect(err).To(MatchError(`bake profile "ci" not found in Kilnfile (available profiles: dev)`))
+		})
+	})
+
+	When("a Kilnfile extends a base with a bake section", func() {
+		It("resolves paths relative to the file that sets them", func() {
+			fs := memfs.New()
+			Expect(util.WriteFile(fs, "pas/Kilnfile", []byte("bake:\n  metadata: base.yml\n  forms_directories: [forms]\nbake_profiles:\n  dev:\n    variables_files: [dev.yml]\n"), 0644)).To(Succeed())
+			Expect(util.WriteFile(fs, "srt/Kilnfile", []byte("extends: ../pas/Kilnfile\nbake:\n  forms_directories: [forms]\nbake_profiles:\n  dev:\n    stub_releases: true\n"), 0644)).To(Succeed())
+
+			kilnfile, err := cargo.NewKilnfileLoader(fs, cargo.NoInterpolation{}).Load("srt/Kilnfile", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			config, err := kilnfile.BakeConfig("dev")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(config).To(Equal(cargo.BakeConfig{
+				Metadata:         "pas/base.yml",
+				FormsDirectories: []string{"pas/forms", "srt/forms"},
+				VariablesFiles:   []string{"pas/dev.yml"},
+				StubReleases:     boolPointer(true),
+			}))
+		})
+	})
+})
+
+func boolPointer(value bool) *bool {
+	return &value
+}
@@ -0,0 +1,260 @@
+package cargo
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	ReleaseSourceTypeS3     = "s3"
+	ReleaseSourceTypeBOSHIO = "bosh.io"
+
+	StemcellSourceTypePivnet    = "pivnet"
+	StemcellSourceTypeBOSHIO    = "bosh.io"
+	StemcellSourceTypeDirectory = "directory"
+)
+
+// ValidationError lists every problem found in a Kilnfile or Kilnfile.lock
+// so they can all be fixed at once.
+type ValidationError []string
+
+func (problems ValidationError) Error() string {
+	var lines []string
+	for _, problem := range problems {
+		lines = append(lines, "- "+problem)
+	}
+	return "\n" + strings.Join(lines, "\n")
+}
+
+// ParseKilnfile decodes a Kilnfile and rejects keys that do not match a
+// field, so typos like `acess_key_id` are not silently ignored.
+func ParseKilnfile(kilnfileYAML []byte) (Kilnfile, error) {
+	var kilnfile Kilnfile
+	err := yaml.UnmarshalStrict(kilnfileYAML, &kilnfile)
+	if err != nil {
+		return Kilnfile{}, err
+	}
+	return kilnfile, nil
+}
+
+// ParseKilnfileLock decodes a Kilnfile.lock. Top level keys kiln does not
+// manage are allowed (`kiln update` keeps them) but unknown keys inside the
+// managed ones are rejected.
+func ParseKilnfileLock(lockYAML []byte) (KilnfileLock, error) {
+	var fields yaml.MapSlice
+	err := yaml.Unmarshal(lockYAML, &fields)
+	if err != nil {
+		return KilnfileLock{}, err
+	}
+
+	managedKeys := yamlFieldNames(reflect.TypeOf(KilnfileLock{}))
+
+	var managedFields yaml.MapSlice
+	for _, field := range fields {
+		if key, ok := field.Key.(string); ok && managedKeys[key] {
+			managedFields = append(managedFields, field)
+		}
+	}
+
+	managedYAML, err := yaml.Marshal(managedFields)
+	if err != nil {
+		return KilnfileLock{}, err // should never happen
+	}
+
+	var lock KilnfileLock
+	err = yaml.UnmarshalStrict(managedYAML, &lock)
+	if err != nil {
+		return KilnfileLock{}, err
+	}
+	return lock, nil
+}
+
+// Validate checks that the release and stemcell sources are complete and
+// that stemcell criteria can be resolved.
+func (kilnfile Kilnfile) Validate() error {
+	var problems ValidationError
+
+	for i, criteria := range append([]Stemcell{kilnfile.Stemcell}, kilnfile.AdditionalStemcells...) {
+		field := "stemcell_criteria"
+		if i > 0 {
+			field = fmt.Sprintf("additional_stemcells_criteria[%d]", i-1)
+		} else if criteria == (Stemcell{}) {
+			continue
+		}
+
+		if criteria.OS == "" {
+			problems = append(problems, field+": os is required")
+		}
+		if _, err := semver.NewConstraint(criteria.Version); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: version %q is not a valid constraint: %s", field, criteria.Version, err))
+		}
+	}
+
+	problems = append(problems, kilnfile.validateReleaseSources()...)
+	problems = append(problems, kilnfile.validateStemcellSources()...)
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// ValidateReleaseSources only checks release_sources, which is all
+// `kiln fetch` needs from the Kilnfile.
+func (kilnfile Kilnfile) ValidateReleaseSources() error {
+	problems := kilnfile.validateReleaseSources()
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func (kilnfile Kilnfile) validateReleaseSources() ValidationError {
+	var problems ValidationError
+	for i, source := range kilnfile.ReleaseSources {
+		field := fmt.Sprintf("release_sources[%d]", i)
+
+		switch source.Type {
+		case ReleaseSourceTypeBOSHIO:
+			if source != (ReleaseSourceConfig{Type: ReleaseSourceTypeBOSHIO}) {
+				problems = append(problems, field+": bosh.io release sources do not take any other keys")
+			}
+		case ReleaseSourceTypeS3:
+			required := []struct{ key, value string }{
+				{"bucket", source.Bucket},
+				{"region", source.Region},
+				{"access_key_id", source.AccessKeyId},
+				{"secret_access_key", source.SecretAccessKey},
+				{"regex", source.Regex},
+			}
+			for _, r := range required {
+				if r.value == "" {
+					problems = append(problems, fmt.Sprintf("%s: %s is required for s3 release sources", field, r.key))
+				}
+			}
+			if source.Regex != "" {
+				problems = append(problems, validateReleaseSourceRegex(field, source.Regex)...)
+			}
+		case "":
+			problems = append(problems, field+": type is required")
+		default:
+			problems = append(problems, fmt.Sprintf("%s: unknown type %q, expected %s or %s", field, source.Type, ReleaseSourceTypeS3, ReleaseSourceTypeBOSHIO))
+		}
+	}
+	return problems
+}
+
+func validateReleaseSourceRegex(field, regex string) ValidationError {
+	exp, err := regexp.Compile(regex)
+	if err != nil {
+		return ValidationError{fmt.Sprintf("%s: regex does not compile: %s", field, err)}
+	}
+
+	var problems ValidationError
+	groups := map[string]bool{}
+	for _, name := range exp.SubexpNames() {
+		groups[name] = true
+	}
+	for _, name := range []string{"release_name", "release_version"} {
+		if !groups[name] {
+			problems = append(problems, fmt.Sprintf("%s: regex is missing the %s capture group", field, name))
+		}
+	}
+	return problems
+}
+
+func (kilnfile Kilnfile) validateStemcellSources() ValidationError {
+	var problems ValidationError
+	seen := map[string]bool{}
+	for i, source := range kilnfile.StemcellSources {
+		field := fmt.Sprintf("stemcell_sources[%d]", i)
+
+		if source.OS == "" {
+			problems = append(problems, field+": os is required")
+		} else if seen[source.OS] {
+			problems = append(problems, fmt.Sprintf("%s: os %q is listed more than once", field, source.OS))
+		}
+		seen[source.OS] = true
+
+		switch source.Type {
+		case "", StemcellSourceTypePivnet:
+			if source.Slug == "" {
+				problems = append(problems, field+": slug is required for pivnet stemcell sources")
+			}
+		case StemcellSourceTypeBOSHIO:
+			if source.Name == "" {
+				problems = append(problems, field+": name is required for bosh.io stemcell sources")
+			}
+		case StemcellSourceTypeDirectory:
+			if source.Path == "" {
+				problems = append(problems, field+": path is required for directory stemcell sources")
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s: unknown type %q, expected %s, %s or %s", field, source.Type, StemcellSourceTypePivnet, StemcellSourceTypeBOSHIO, StemcellSourceTypeDirectory))
+		}
+	}
+	return problems
+}
+
+// Validate checks that every locked release and stemcell has a name (or os)
+// and a version.
+func (lock KilnfileLock) Validate() error {
+	var problems ValidationError
+
+	seen := map[string]bool{}
+	for i, release := range lock.Releases {
+		field := fmt.Sprintf("releases[%d]", i)
+		if release.Name == "" {
+			problems = append(problems, field+": name is required")
+		} else if seen[release.Name] {
+			problems = append(problems, fmt.Sprintf("%s: release %q is listed more than once", field, release.Name))
+		}
+		seen[release.Name] = true
+
+		if release.Version == "" {
+			problems = append(problems, field+": version is required")
+		}
+	}
+
+	for i, stemcell := range lock.AdditionalStemcells {
+		field := fmt.Sprintf("additional_stemcells_criteria[%d]", i)
+		if stemcell.OS == "" {
+			problems = append(problems, field+": os is required")
+		}
+		if stemcell.Version == "" {
+			problems = append(problems, field+": version is required")
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// yamlFieldNames returns the keys the yaml tags of a struct type map to.
+func yamlFieldNames(structType reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < structType.NumField(); i++ {
+		if name := yamlFieldName(structType.Field(i)); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func yamlFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
@@ -0,0 +1,67 @@
+package cargo
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// KilnfileJSONSchema returns a JSON Schema for the Kilnfile generated from
+// the yaml tags on the Kilnfile type. Editors can use it for completion and
+// to flag unknown keys. Fields with an `enum` tag list their allowed values.
+func KilnfileJSONSchema() ([]byte, error) {
+	schema := jsonSchemaFor(reflect.TypeOf(Kilnfile{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "Kilnfile"
+
+	schemaJSON, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err // should never happen
+	}
+	return append(schemaJSON, '\n'), nil
+}
+
+func jsonSchemaFor(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := yamlFieldName(field)
+			if name == "" {
+				continue
+			}
+
+			property := jsonSchemaFor(field.Type)
+			if enum := field.Tag.Get("enum"); enum != "" {
+				property["enum"] = strings.Split(enum, ",")
+			}
+			properties[name] = property
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Ptr:
+		return jsonSchemaFor(t.Elem())
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": jsonSchemaFor(t.Elem()),
+		}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": jsonSchemaFor(t.Elem()),
+		}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	default:
+		return map[string]interface{}{}
+	}
+}
@@ -0,0 +1,269 @@
+package cargo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-billy.v4"
+)
+
+// KilnfileInterpolator runs the template helpers in a Kilnfile with the
+// variables. builder.KilnfileInterpolator is the implementation commands use.
+type KilnfileInterpolator interface {
+	InterpolateKilnfile(variables map[string]interface{}, kilnfileYAML []byte) ([]byte, error)
+}
+
+// NoInterpolation is the KilnfileInterpolator for Kilnfiles that are loaded
+// without variables. It returns the Kilnfile unchanged.
+type NoInterpolation struct{}
+
+func (NoInterpolation) InterpolateKilnfile(_ map[string]interface{}, kilnfileYAML []byte) ([]byte, error) {
+	return kilnfileYAML, nil
+}
+
+// KilnfileLoader reads a Kilnfile along with the Kilnfiles it extends and
+// the fragments it includes, so every command sees the same merged
+// configuration.
+type KilnfileLoader struct {
+	fs           billy.Filesystem
+	interpolator KilnfileInterpolator
+}
+
+func NewKilnfileLoader(fs billy.Filesystem, interpolator KilnfileInterpolator) KilnfileLoader {
+	return KilnfileLoader{
+		fs:           fs,
+		interpolator: interpolator,
+	}
+}
+
+// Load returns the Kilnfile at kilnfilePath merged onto the Kilnfile it
+// `extends` and the fragments it lists under `include`. Paths are relative to
+// the file that names them. Base files are applied first, then includes in
+// order, then the file itself. Each file is interpolated with variables
+// before it is parsed, unless variables is nil.
+func (l KilnfileLoader) Load(kilnfilePath string, variables map[string]interface{}) (Kilnfile, error) {
+	return l.load(kilnfilePath, variables, nil)
+}
+
+// LoadLock reads the Kilnfile.lock next to the Kilnfile at kilnfilePath.
+// Lock files are not inherited.
+func (l KilnfileLoader) LoadLock(kilnfilePath string) (KilnfileLock, error) {
+	lockPath := kilnfilePath + ".lock"
+	lockYAML, err := l.readFile(lockPath)
+	if err != nil {
+		return KilnfileLock{}, err
+	}
+
+	lock, err := ParseKilnfileLock(lockYAML)
+	if err != nil {
+		return KilnfileLock{}, fmt.Errorf("could not parse %s: %s", lockPath, err)
+	}
+	return lock, nil
+}
+
+func (l KilnfileLoader) load(kilnfilePath string, variables map[string]interface{}, loading []string) (Kilnfile, error) {
+	for _, path := range loading {
+		if path == kilnfilePath {
+			return Kilnfile{}, fmt.Errorf("Kilnfile %s extends or includes itself: %s", kilnfilePath, strings.Join(append(loading, kilnfilePath), " -> "))
+		}
+	}
+	loading = append(loading, kilnfilePath)
+
+	kilnfileYAML, err := l.readFile(kilnfilePath)
+	if err != nil {
+		return Kilnfile{}, err
+	}
+
+	if variables != nil {
+		kilnfileYAML, err = l.interpolator.InterpolateKilnfile(variables, kilnfileYAML)
+		if err != nil {
+			return Kilnfile{}, fmt.Errorf("could not interpolate %s: %s", kilnfilePath, err)
+		}
+	}
+
+	kilnfile, err := ParseKilnfile(kilnfileYAML)
+	if err != nil {
+		return Kilnfile{}, fmt.Errorf("could not parse %s: %s", kilnfilePath, err)
+	}
+
+	kilnfile.Bake = kilnfile.Bake.relativeTo(filepath.Dir(kilnfilePath))
+	for name, profile := range kilnfile.BakeProfiles {
+		kilnfile.BakeProfiles[name] = profile.relativeTo(filepath.Dir(kilnfilePath))
+	}
+
+	if kilnfile.Extends == "" && len(kilnfile.Include) == 0 {
+		return kilnfile, nil
+	}
+
+	var merged Kilnfile
+	if kilnfile.Extends != "" {
+		merged, err = l.load(relativeTo(kilnfilePath, kilnfile.Extends), variables, loading)
+		if err != nil {
+			return Kilnfile{}, err
+		}
+	}
+
+	for _, include := range kilnfile.Include {
+		fragment, err := l.load(relativeTo(kilnfilePath, include), variables, loading)
+		if err != nil {
+			return Kilnfile{}, err
+		}
+		merged = mergeKilnfiles(merged, fragment)
+	}
+
+	return mergeKilnfiles(merged, kilnfile), nil
+}
+
+func (l KilnfileLoader) readFile(path string) ([]byte, error) {
+	file, err := l.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
+
+// mergeKilnfiles applies overlay onto base:
+//   - release_sources are appended, skipping exact duplicates
+//   - stemcell_criteria and slug are replaced when overlay sets them
+//   - additional_stemcells_criteria (by os and alias) and stemcell_sources
+//     (by os) in overlay replace every base entry with the same os and alias
+//   - pre_ga_user_groups are combined without duplicates
+//   - bake and bake_profiles (by name) are merged like a profile onto bake
+func mergeKilnfiles(base, overlay Kilnfile) Kilnfile {
+	merged := Kilnfile{
+		Stemcell:            base.Stemcell,
+		AdditionalStemcells: mergeStemcells(base.AdditionalStemcells, overlay.AdditionalStemcells),
+		StemcellSources:     mergeStemcellSources(base.StemcellSources, overlay.StemcellSources),
+		Slug:                base.Slug,
+		Bake:                base.Bake.merge(overlay.Bake),
+	}
+
+	for _, profiles := range []map[string]BakeConfig{base.BakeProfiles, overlay.BakeProfiles} {
+		for name, profile := range profiles {
+			if merged.BakeProfiles == nil {
+				merged.BakeProfiles = map[string]BakeConfig{}
+			}
+			merged.BakeProfiles[name] = merged.BakeProfiles[name].merge(profile)
+		}
+	}
+
+	if overlay.Stemcell != (Stemcell{}) {
+		merged.Stemcell = overlay.Stemcell
+	}
+	if overlay.Slug != "" {
+		merged.Slug = overlay.Slug
+	}
+
+	merged.ReleaseSources = append(merged.ReleaseSources, base.ReleaseSources...)
+	for _, source := range overlay.ReleaseSources {
+		if !containsReleaseSource(merged.ReleaseSources, source) {
+			merged.ReleaseSources = append(merged.ReleaseSources, source)
+		}
+	}
+
+	merged.PreGaUserGroups = combine(base.PreGaUserGroups, overlay.PreGaUserGroups)
+
+	return merged
+}
+
+func mergeStemcells(base, overlay []Stemcell) []Stemcell {
+	key := func(stemcell Stemcell) string { return stemcell.OS + "\x00" + stemcell.Alias }
+
+	var baseKeys, overlayKeys []string
+	for _, stemcell := range base {
+		baseKeys = append(baseKeys, key(stemcell))
+	}
+	for _, stemcell := range overlay {
+		overlayKeys = append(overlayKeys, key(stemcell))
+	}
+
+	var merged []Stemcell
+	for _, entry := range mergeOrder(baseKeys, overlayKeys) {
+		if entry.overlay {
+			merged = append(merged, overlay[entry.index])
+		} else {
+			merged = append(merged, base[entry.index])
+		}
+	}
+	return merged
+}
+
+func mergeStemcellSources(base, overlay []StemcellSourceConfig) []StemcellSourceConfig {
+	var baseKeys, overlayKeys []string
+	for _, source := range base {
+		baseKeys = append(baseKeys, source.OS)
+	}
+	for _, source := range overlay {
+		overlayKeys = append(overlayKeys, source.OS)
+	}
+
+	var merged []StemcellSourceConfig
+	for _, entry := range mergeOrder(baseKeys, overlayKeys) {
+		if entry.overlay {
+			merged = append(merged, overlay[entry.index])
+		} else {
+			merged = append(merged, base[entry.index])
+		}
+	}
+	return merged
+}
+
+type listEntry struct {
+	overlay bool
+	index   int
+}
+
+// mergeOrder lists which base and overlay entries a merged list holds. The
+// overlay entries for a key take the place of all the base entries for that
+// key, so a Kilnfile listing two versions of an os replaces the versions of
+// the file it extends. Entries within one file are never merged together.
+func mergeOrder(baseKeys, overlayKeys []string) []listEntry {
+	overlayByKey := map[string][]int{}
+	for i, key := range overlayKeys {
+		overlayByKey[key] = append(overlayByKey[key], i)
+	}
+
+	var order []listEntry
+	placed := map[string]bool{}
+	for i, key := range baseKeys {
+		indexes, replaced := overlayByKey[key]
+		if !replaced {
+			order = append(order, listEntry{index: i})
+			continue
+		}
+		if !placed[key] {
+			for _, index := range indexes {
+				order = append(order, listEntry{overlay: true, index: index})
+			}
+			placed[key] = true
+		}
+	}
+
+	for i, key := range overlayKeys {
+		if !placed[key] {
+			order = append(order, listEntry{overlay: true, index: i})
+		}
+	}
+
+	return order
+}
+
+func containsReleaseSource(sources []ReleaseSourceConfig, source ReleaseSourceConfig) bool {
+	for _, s := range sources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
+func relativeTo(kilnfilePath, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(kilnfilePath), path)
+}
@@ -0,0 +1,205 @@
+package cargo_test
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/pivotal-cf/kiln/internal/cargo"
+	"gopkg.in/src-d/go-billy.v4"
+	"gopkg.in/src-d/go-billy.v4/memfs"
+	"gopkg.in/src-d/go-billy.v4/util"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("KilnfileLoader", func() {
+	var (
+		fs     billy.Filesystem
+		loader cargo.KilnfileLoader
+	)
+
+	writeFile := func(path, contents string) {
+		Expect(util.WriteFile(fs, path, []byte(contents), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		fs = memfs.New()
+		loader = cargo.NewKilnfileLoader(fs, variableInterpolator{})
+	})
+
+	Describe("Load", func() {
+		BeforeEach(func() {
+			writeFile("base/Kilnfile", `---
+slug: elastic-runtime
+pre_ga_user_groups: [pas-team]
+release_sources:
+- type: s3
+  bucket: $( variable "bucket" )
+  region: us-west-1
+  access_key_id: some-key
+  secret_access_key: some-secret
+  regex: some-regex
+stemcell_criteria:
+  os: ubuntu-xenial
+  version: "621.*"
+additional_stemcells_criteria:
+- os: windows2019
+  version: "2019.*"
+`)
+			writeFile("fragments/bosh-io.yml", `---
+release_sources:
+- type: bosh.io
+stemcell_sources:
+- os: windows2019
+  type: directory
+  path: stemcells
+`)
+		})
+
+		It("merges the base and included files under the Kilnfile", func() {
+			writeFile("srt/Kilnfile", `---
+extends: ../base/Kilnfile
+include:
+- ../fragments/bosh-io.yml
+slug: elastic-runtime-small
+pre_ga_user_groups: [pas-team, srt-team]
+release_sources:
+- type: bosh.io
+additional_stemcells_criteria:
+- os: windows2019
+  version: "2019.7"
+- os: ubuntu-bionic
+  version: "1.*"
+`)
+
+			kilnfile, err := loader.Load("srt/Kilnfile", map[string]interface{}{"bucket": "some-bucket"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kilnfile).To(Equal(cargo.Kilnfile{
+				Slug:            "elastic-runtime-small",
+				PreGaUserGroups: []string{"pas-team", "srt-team"},
+				ReleaseSources: []cargo.ReleaseSourceConfig{
+					{Type: "s3", Bucket: "some-bucket", Region: "us-west-1", AccessKeyId: "some-key", SecretAccessKey: "some-secret", Regex: "some-regex"},
+					{Type: "bosh.io"},
+				},
+				Stemcell: cargo.Stemcell{OS: "ubuntu-xenial", Version: "621.*"},
+				AdditionalStemcells: []cargo.Stemcell{
+					{OS: "windows2019", Version: "2019.7"},
+					{OS: "ubuntu-bionic", Version: "1.*"},
+				},
+				StemcellSources: []cargo.StemcellSourceConfig{
+					{OS: "windows2019", Type: "directory", Path: "stemcells"},
+				},
+			}))
+		})
+
+		It("replaces the stemcell criteria when the Kilnfile sets them", func() {
+			writeFile("Kilnfile", "extends: base/Kilnfile\nstemcell_criteria:\n  os: ubuntu-bionic\n  version: \"1.*\"\n")
+
+			kilnfile, err := loader.Load("Kilnfile", map[string]interface{}{"bucket": "some-bucket"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kilnfile.Stemcell).To(Equal(cargo.Stemcell{OS: "ubuntu-bionic", Version: "1.*"}))
+			Expect(kilnfile.Slug).To(Equal("elastic-runtime"))
+		})
+
+		It("keeps several additional stemcells for the same os", func() {
+			writeFile("Kilnfile", `---
+additional_stemcells_criteria:
+- os: ubuntu-xenial
+  version: "~456"
+- os: ubuntu-xenial
+  version: "~315"
+`)
+
+			kilnfile, err := loader.Load("Kilnfile", nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kilnfile.AdditionalStemcells).To(Equal([]cargo.Stemcell{
+				{OS: "ubuntu-xenial", Version: "~456"},
+				{OS: "ubuntu-xenial", Version: "~315"},
+			}))
+		})
+
+		It("replaces every base stemcell for an os with the stemcells the Kilnfile lists for it", func() {
+			writeFile("Kilnfile", `---
+extends: base/Kilnfile
+additional_stemcells_criteria:
+- os: ubuntu-xenial
+  version: "~456"
+- os: windows2019
+  version: "2019.7"
+- os: windows2019
+  version: "2019.12"
+`)
+
+			kilnfile, err := loader.Load("Kilnfile", map[string]interface{}{"bucket": "some-bucket"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(kilnfile.AdditionalStemcells).To(Equal([]cargo.Stemcell{
+				{OS: "windows2019", Version: "2019.7"},
+				{OS: "windows2019", Version: "2019.12"},
+				{OS: "ubuntu-xenial", Version: "~456"},
+			}))
+		})
+
+		When("variables is nil", func() {
+			It("does not interpolate", func() {
+				kilnfile, err := loader.Load("base/Kilnfile", nil)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(kilnfile.ReleaseSources[0].Bucket).To(Equal(`$( variable "bucket" )`))
+			})
+		})
+
+		When("the Kilnfiles extend each other", func() {
+			It("returns an error", func() {
+				writeFile("a/Kilnfile", "extends: ../b/Kilnfile\n")
+				writeFile("b/Kilnfile", "include: [../a/Kilnfile]\n")
+
+				_, err := loader.Load("a/Kilnfile", nil)
+				Expect(err).To(MatchError("Kilnfile a/Kilnfile extends or includes itself: a/Kilnfile -> b/Kilnfile -> a/Kilnfile"))
+			})
+		})
+
+		When("an included file has an unknown key", func() {
+			It("names the file", func() {
+				writeFile("fragments/typo.yml", "relase_sources: []\n")
+				writeFile("Kilnfile", "include: [fragments/typo.yml]\n")
+
+				_, err := loader.Load("Kilnfile", nil)
+				Expect(err).To(MatchError(ContainSubstring("could not parse fragments/typo.yml")))
+				Expect(err).To(MatchError(ContainSubstring("field relase_sources not found")))
+			})
+		})
+
+		When("the base Kilnfile does not exist", func() {
+			It("returns a not exist error", func() {
+				writeFile("Kilnfile", "extends: missing/Kilnfile\n")
+
+				_, err := loader.Load("Kilnfile", nil)
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("LoadLock", func() {
+		It("reads the lock next to the Kilnfile", func() {
+			writeFile("srt/Kilnfile.lock", "stemcell_criteria:\n  os: ubuntu-xenial\n  version: \"621.74\"\n")
+
+			lock, err := loader.LoadLock("srt/Kilnfile")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(lock.Stemcell).To(Equal(cargo.Stemcell{OS: "ubuntu-xenial", Version: "621.74"}))
+		})
+	})
+})
+
+var variableHelper = regexp.MustCompile(`\$\( variable "(\w+)" \)`)
+
+// variableInterpolator replaces the variable helper, which is all the Kilnfiles
+// in these tests use.
+type variableInterpolator struct{}
+
+func (variableInterpolator) InterpolateKilnfile(variables map[string]interface{}, kilnfileYAML []byte) ([]byte, error) {
+	return variableHelper.ReplaceAllFunc(kilnfileYAML, func(helper []byte) []byte {
+		name := variableHelper.FindSubmatch(helper)[1]
+		return []byte(fmt.Sprint(variables[string(name)]))
+	}), nil
+}
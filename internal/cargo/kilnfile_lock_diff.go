@@ -0,0 +1,288 @@
+package cargo
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+const (
+	ChangeAdded      = "added"
+	ChangeRemoved    = "removed"
+	ChangeUpgraded   = "upgraded"
+	ChangeDowngraded = "downgraded"
+	ChangeChanged    = "changed"
+
+	LevelMajor = "major"
+	LevelMinor = "minor"
+	LevelPatch = "patch"
+)
+
+// KilnfileLockDiff describes how the releases and stemcells of two
+// Kilnfile.lock files differ.
+type KilnfileLockDiff struct {
+	Stemcells []StemcellChange `json:"stemcells"`
+	Releases  []ReleaseChange  `json:"releases"`
+}
+
+// StemcellChange and ReleaseChange have a Level when Change is
+// ChangeUpgraded or ChangeDowngraded. ChangeChanged is used when only the
+// sha1 differs or the versions are not semantic versions.
+type StemcellChange struct {
+	OS         string `json:"os"`
+	Alias      string `json:"alias,omitempty"`
+	Change     string `json:"change"`
+	Level      string `json:"level,omitempty"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+}
+
+type ReleaseChange struct {
+	Name       string `json:"name"`
+	Change     string `json:"change"`
+	Level      string `json:"level,omitempty"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewVersion string `json:"new_version,omitempty"`
+	OldSHA1    string `json:"old_sha1,omitempty"`
+	NewSHA1    string `json:"new_sha1,omitempty"`
+}
+
+// DiffKilnfileLocks compares releases by name and stemcells by os and alias,
+// pairing several stemcells of one os in the order they are listed. Changes
+// are sorted by name so the result does not depend on the order of releases.
+func DiffKilnfileLocks(oldLock, newLock KilnfileLock) KilnfileLockDiff {
+	return KilnfileLockDiff{
+		Stemcells: diffStemcells(oldLock.AllStemcells(), newLock.AllStemcells()),
+		Releases:  diffReleases(oldLock.Releases, newLock.Releases),
+	}
+}
+
+// AllStemcells returns stemcell_criteria followed by additional_stemcells_criteria.
+func (lock KilnfileLock) AllStemcells() []Stemcell {
+	var stemcells []Stemcell
+	if lock.Stemcell.OS != "" || lock.Stemcell.Version != "" {
+		stemcells = append(stemcells, lock.Stemcell)
+	}
+	return append(stemcells, lock.AdditionalStemcells...)
+}
+
+func (diff KilnfileLockDiff) HasChanges() bool {
+	return len(diff.Stemcells) > 0 || len(diff.Releases) > 0
+}
+
+func (diff KilnfileLockDiff) String() string {
+	if !diff.HasChanges() {
+		return "no changes\n"
+	}
+
+	var out strings.Builder
+	if len(diff.Stemcells) > 0 {
+		out.WriteString("Stemcells:\n")
+		for _, change := range diff.Stemcells {
+			fmt.Fprintf(&out, "  %s %s %s%s\n", changeSymbol(change.Change), change.name(), versionChange(change.Change, change.OldVersion, change.NewVersion), levelSuffix(change.Change, change.Level))
+		}
+	}
+	if len(diff.Releases) > 0 {
+		out.WriteString("Releases:\n")
+		for _, change := range diff.Releases {
+			line := versionChange(change.Change, change.OldVersion, change.NewVersion) + levelSuffix(change.Change, change.Level)
+			if change.Change == ChangeChanged && change.OldVersion == change.NewVersion {
+				line = fmt.Sprintf("%s (sha1 %s -> %s)", change.NewVersion, change.OldSHA1, change.NewSHA1)
+			}
+			fmt.Fprintf(&out, "  %s %s %s\n", changeSymbol(change.Change), change.Name, line)
+		}
+	}
+	return out.String()
+}
+
+// Markdown renders the changes as tables that can be pasted into pull
+// requests and release notes.
+func (diff KilnfileLockDiff) Markdown() string {
+	if !diff.HasChanges() {
+		return "No changes.\n"
+	}
+
+	var out strings.Builder
+	if len(diff.Stemcells) > 0 {
+		out.WriteString("### Stemcells\n\n")
+		out.WriteString("| OS | Change | Old Version | New Version |\n")
+		out.WriteString("|----|--------|-------------|-------------|\n")
+		for _, change := range diff.Stemcells {
+			fmt.Fprintf(&out, "| %s | %s | %s | %s |\n", change.name(), describeChange(change.Change, change.Level), change.OldVersion, change.NewVersion)
+		}
+	}
+	if len(diff.Releases) > 0 {
+		if len(diff.Stemcells) > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString("### Releases\n\n")
+		out.WriteString("| Release | Change | Old Version | New Version |\n")
+		out.WriteString("|---------|--------|-------------|-------------|\n")
+		for _, change := range diff.Releases {
+			description := describeChange(change.Change, change.Level)
+			if change.Change == ChangeChanged && change.OldVersion == change.NewVersion {
+				description = "sha1 changed"
+			}
+			fmt.Fprintf(&out, "| %s | %s | %s | %s |\n", change.Name, description, change.OldVersion, change.NewVersion)
+		}
+	}
+	return out.String()
+}
+
+func (change StemcellChange) name() string {
+	if change.Alias == "" {
+		return change.OS
+	}
+	return change.OS + " (" + change.Alias + ")"
+}
+
+func describeChange(change, level string) string {
+	if level == "" {
+		return change
+	}
+	return level + " " + strings.TrimSuffix(change, "d")
+}
+
+func diffStemcells(oldStemcells, newStemcells []Stemcell) []StemcellChange {
+	oldByKey, oldKeys := map[string]Stemcell{}, map[string]bool{}
+	for i, key := range StemcellKeys(oldStemcells) {
+		oldByKey[key] = oldStemcells[i]
+		oldKeys[key] = true
+	}
+	newByKey, newKeys := map[string]Stemcell{}, map[string]bool{}
+	for i, key := range StemcellKeys(newStemcells) {
+		newByKey[key] = newStemcells[i]
+		newKeys[key] = true
+	}
+
+	changes := []StemcellChange{}
+	for _, key := range sortedUnion(oldKeys, newKeys) {
+		oldStemcell, inOld := oldByKey[key]
+		newStemcell, inNew := newByKey[key]
+		switch {
+		case !inOld:
+			changes = append(changes, StemcellChange{OS: newStemcell.OS, Alias: newStemcell.Alias, Change: ChangeAdded, NewVersion: newStemcell.Version})
+		case !inNew:
+			changes = append(changes, StemcellChange{OS: oldStemcell.OS, Alias: oldStemcell.Alias, Change: ChangeRemoved, OldVersion: oldStemcell.Version})
+		case oldStemcell.Version != newStemcell.Version:
+			change, level := classifyVersionChange(oldStemcell.Version, newStemcell.Version)
+			changes = append(changes, StemcellChange{OS: newStemcell.OS, Alias: newStemcell.Alias, Change: change, Level: level, OldVersion: oldStemcell.Version, NewVersion: newStemcell.Version})
+		}
+	}
+	return changes
+}
+
+func diffReleases(oldReleases, newReleases []Release) []ReleaseChange {
+	oldByName, oldNames := map[string]Release{}, map[string]bool{}
+	for _, release := range oldReleases {
+		oldByName[release.Name] = release
+		oldNames[release.Name] = true
+	}
+	newByName, newNames := map[string]Release{}, map[string]bool{}
+	for _, release := range newReleases {
+		newByName[release.Name] = release
+		newNames[release.Name] = true
+	}
+
+	changes := []ReleaseChange{}
+	for _, name := range sortedUnion(oldNames, newNames) {
+		oldRelease, inOld := oldByName[name]
+		newRelease, inNew := newByName[name]
+		change := ReleaseChange{
+			Name:       name,
+			OldVersion: oldRelease.Version,
+			OldSHA1:    oldRelease.SHA1,
+			NewVersion: newRelease.Version,
+			NewSHA1:    newRelease.SHA1,
+		}
+		switch {
+		case !inOld:
+			change.Change = ChangeAdded
+		case !inNew:
+			change.Change = ChangeRemoved
+		case oldRelease.Version != newRelease.Version:
+			change.Change, change.Level = classifyVersionChange(oldRelease.Version, newRelease.Version)
+		case oldRelease.SHA1 != newRelease.SHA1:
+			change.Change = ChangeChanged
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func sortedUnion(names map[string]bool, otherNames map[string]bool) []string {
+	var union []string
+	for name := range names {
+		union = append(union, name)
+	}
+	for name := range otherNames {
+		if !names[name] {
+			union = append(union, name)
+		}
+	}
+	sort.Strings(union)
+	return union
+}
+
+// classifyVersionChange compares versions using semver. Versions kiln can not
+// parse are reported as ChangeChanged without a level.
+func classifyVersionChange(oldVersion, newVersion string) (string, string) {
+	oldSemver, err := semver.NewVersion(oldVersion)
+	if err != nil {
+		return ChangeChanged, ""
+	}
+	newSemver, err := semver.NewVersion(newVersion)
+	if err != nil {
+		return ChangeChanged, ""
+	}
+
+	change := ChangeUpgraded
+	switch {
+	case newSemver.Equal(oldSemver):
+		return ChangeChanged, ""
+	case newSemver.LessThan(oldSemver):
+		change = ChangeDowngraded
+	}
+
+	switch {
+	case newSemver.Major() != oldSemver.Major():
+		return change, LevelMajor
+	case newSemver.Minor() != oldSemver.Minor():
+		return change, LevelMinor
+	default:
+		return change, LevelPatch
+	}
+}
+
+func levelSuffix(change, level string) string {
+	if level == "" {
+		return ""
+	}
+	return " (" + describeChange(change, level) + ")"
+}
+
+func changeSymbol(change string) string {
+	switch change {
+	case ChangeAdded:
+		return "+"
+	case ChangeRemoved:
+		return "-"
+	default:
+		return "~"
+	}
+}
+
+func versionChange(change, oldVersion, newVersion string) string {
+	switch change {
+	case ChangeAdded:
+		return newVersion
+	case ChangeRemoved:
+		return oldVersion
+	default:
+		return oldVersion + " -> " + newVersion
+	}
+}
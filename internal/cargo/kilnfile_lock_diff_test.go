@@ -0,0 +1,128 @@
+package cargo_test
+
+import (
+	"github.com/pivotal-cf/kiln/internal/cargo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DiffKilnfileLocks", func() {
+	var oldLock, newLock cargo.KilnfileLock
+
+	BeforeEach(func() {
+		oldLock = cargo.KilnfileLock{
+			Releases: []cargo.Release{
+				{Name: "bpm", Version: "1.1.0", SHA1: "a"},
+				{Name: "capi", Version: "1.80.0", SHA1: "b"},
+				{Name: "diego", Version: "2.30.0", SHA1: "c"},
+			},
+			Stemcell:            cargo.Stemcell{OS: "ubuntu-xenial", Version: "621.71"},
+			AdditionalStemcells: []cargo.Stemcell{{OS: "windows2019", Version: "2019.7"}},
+		}
+		newLock = cargo.KilnfileLock{
+			Releases: []cargo.Release{
+				{Name: "uaa", Version: "74.0.0", SHA1: "d"},
+				{Name: "diego", Version: "2.30.0", SHA1: "e"},
+				{Name: "bpm", Version: "1.1.1", SHA1: "f"},
+			},
+			Stemcell: cargo.Stemcell{OS: "ubuntu-xenial", Version: "621.74"},
+		}
+	})
+
+	It("reports release and stemcell changes sorted by name", func() {
+		diff := cargo.DiffKilnfileLocks(oldLock, newLock)
+		Expect(diff.HasChanges()).To(BeTrue())
+		Expect(diff.Stemcells).To(Equal([]cargo.StemcellChange{
+			{OS: "ubuntu-xenial", Change: cargo.ChangeUpgraded, Level: cargo.LevelMinor, OldVersion: "621.71", NewVersion: "621.74"},
+			{OS: "windows2019", Change: cargo.ChangeRemoved, OldVersion: "2019.7"},
+		}))
+		Expect(diff.Releases).To(Equal([]cargo.ReleaseChange{
+			{Name: "bpm", Change: cargo.ChangeUpgraded, Level: cargo.LevelPatch, OldVersion: "1.1.0", NewVersion: "1.1.1", OldSHA1: "a", NewSHA1: "f"},
+			{Name: "capi", Change: cargo.ChangeRemoved, OldVersion: "1.80.0", OldSHA1: "b"},
+			{Name: "diego", Change: cargo.ChangeChanged, OldVersion: "2.30.0", NewVersion: "2.30.0", OldSHA1: "c", NewSHA1: "e"},
+			{Name: "uaa", Change: cargo.ChangeAdded, NewVersion: "74.0.0", NewSHA1: "d"},
+		}))
+	})
+
+	It("renders the changes as text", func() {
+		Expect(cargo.DiffKilnfileLocks(oldLock, newLock).String()).To(Equal(
+			"Stemcells:\n" +
+				"  ~ ubuntu-xenial 621.71 -> 621.74 (minor upgrade)\n" +
+				"  - windows2019 2019.7\n" +
+				"Releases:\n" +
+				"  ~ bpm 1.1.0 -> 1.1.1 (patch upgrade)\n" +
+				"  - capi 1.80.0\n" +
+				"  ~ diego 2.30.0 (sha1 c -> e)\n" +
+				"  + uaa 74.0.0\n",
+		))
+	})
+
+	It("renders the changes as markdown", func() {
+		Expect(cargo.DiffKilnfileLocks(oldLock, newLock).Markdown()).To(Equal(
+			"### Stemcells\n\n" +
+				"| OS | Change | Old Version | New Version |\n" +
+				"|----|--------|-------------|-------------|\n" +
+				"| ubuntu-xenial | minor upgrade | 621.71 | 621.74 |\n" +
+				"| windows2019 | removed | 2019.7 |  |\n" +
+				"\n" +
+				"### Releases\n\n" +
+				"| Release | Change | Old Version | New Version |\n" +
+				"|---------|--------|-------------|-------------|\n" +
+				"| bpm | patch upgrade | 1.1.0 | 1.1.1 |\n" +
+				"| capi | removed | 1.80.0 |  |\n" +
+				"| diego | sha1 changed | 2.30.0 | 2.30.0 |\n" +
+				"| uaa | added |  | 74.0.0 |\n",
+		))
+	})
+
+	It("reports a change to each of several stemcells of one os", func() {
+		diff := cargo.DiffKilnfileLocks(
+			cargo.KilnfileLock{AdditionalStemcells: []cargo.Stemcell{
+				{OS: "ubuntu-xenial", Version: "456.1"},
+				{OS: "ubuntu-xenial", Version: "315.1"},
+				{OS: "ubuntu-bionic", Alias: "bionic-fips", Version: "1.10"},
+			}},
+			cargo.KilnfileLock{AdditionalStemcells: []cargo.Stemcell{
+				{OS: "ubuntu-xenial", Version: "456.2"},
+				{OS: "ubuntu-xenial", Version: "315.3"},
+				{OS: "ubuntu-bionic", Version: "1.12"},
+			}},
+		)
+		Expect(diff.Stemcells).To(Equal([]cargo.StemcellChange{
+			{OS: "ubuntu-bionic", Change: cargo.ChangeAdded, NewVersion: "1.12"},
+			{OS: "ubuntu-bionic", Alias: "bionic-fips", Change: cargo.ChangeRemoved, OldVersion: "1.10"},
+			{OS: "ubuntu-xenial", Change: cargo.ChangeUpgraded, Level: cargo.LevelMinor, OldVersion: "456.1", NewVersion: "456.2"},
+			{OS: "ubuntu-xenial", Change: cargo.ChangeUpgraded, Level: cargo.LevelMinor, OldVersion: "315.1", NewVersion: "315.3"},
+		}))
+		Expect(diff.String()).To(ContainSubstring("  - ubuntu-bionic (bionic-fips) 1.10\n"))
+	})
+
+	DescribeTable("semver classification",
+		func(oldVersion, newVersion, change, level string) {
+			diff := cargo.DiffKilnfileLocks(
+				cargo.KilnfileLock{Releases: []cargo.Release{{Name: "r", Version: oldVersion}}},
+				cargo.KilnfileLock{Releases: []cargo.Release{{Name: "r", Version: newVersion}}},
+			)
+			Expect(diff.Releases).To(HaveLen(1))
+			Expect(diff.Releases[0].Change).To(Equal(change))
+			Expect(diff.Releases[0].Level).To(Equal(level))
+		},
+		Entry("major upgrade", "1.2.3", "2.0.0", cargo.ChangeUpgraded, cargo.LevelMajor),
+		Entry("minor upgrade", "1.2.3", "1.3.0", cargo.ChangeUpgraded, cargo.LevelMinor),
+		Entry("patch upgrade", "1.2.3", "1.2.4", cargo.ChangeUpgraded, cargo.LevelPatch),
+		Entry("major downgrade", "2.0.0", "1.9.9", cargo.ChangeDowngraded, cargo.LevelMajor),
+		Entry("patch downgrade", "1.2.4", "1.2.3", cargo.ChangeDowngraded, cargo.LevelPatch),
+		Entry("short versions", "3586.1", "3586.7", cargo.ChangeUpgraded, cargo.LevelMinor),
+		Entry("non semver versions", "latest", "newest", cargo.ChangeChanged, ""),
+	)
+
+	When("the locks are the same", func() {
+		It("reports no changes", func() {
+			diff := cargo.DiffKilnfileLocks(oldLock, oldLock)
+			Expect(diff.HasChanges()).To(BeFalse())
+			Expect(diff.String()).To(Equal("no changes\n"))
+		})
+	})
+})
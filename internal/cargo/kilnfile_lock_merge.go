@@ -0,0 +1,227 @@
+package cargo
+
+import (
+	"fmt"
+
+	"github.com/Masterminds/semver"
+)
+
+// MergeConflict describes a release or stemcell that both sides of a merge
+// changed in ways that can not be reconciled. Base, Ours and Theirs describe
+// the entry on each side and are empty when it is absent.
+type MergeConflict struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+	Base   string `json:"base,omitempty"`
+	Ours   string `json:"ours,omitempty"`
+	Theirs string `json:"theirs,omitempty"`
+}
+
+func (conflict MergeConflict) String() string {
+	return fmt.Sprintf("%s %q: %s (base: %s, ours: %s, theirs: %s)",
+		conflict.Kind, conflict.Name, conflict.Reason,
+		orNone(conflict.Base), orNone(conflict.Ours), orNone(conflict.Theirs),
+	)
+}
+
+// lockEntry is a release or stemcell. Key matches the entry across the three
+// locks: the release name, or the stemcell os and alias with the position
+// among stemcells of the same os and alias.
+type lockEntry struct {
+	Key     string
+	Name    string
+	Alias   string
+	Version string
+	SHA1    string
+}
+
+func (entry *lockEntry) String() string {
+	if entry == nil {
+		return ""
+	}
+	if entry.SHA1 == "" {
+		return entry.Version
+	}
+	return fmt.Sprintf("%s (sha1 %s)", entry.Version, entry.SHA1)
+}
+
+// MergeKilnfileLocks does a three way merge of releases (by name) and stemcells
+// (by os and alias, pairing several stemcells of one os in order). When both sides changed an entry the higher version wins, unless
+// both sides have the same version with different sha1s, or one side removed
+// the entry. Entries that conflict keep the value from ours.
+func MergeKilnfileLocks(base, ours, theirs KilnfileLock) (KilnfileLock, []MergeConflict) {
+	var conflicts []MergeConflict
+	merged := ours
+
+	releases, releaseConflicts := mergeEntries("release", releaseEntries(base.Releases), releaseEntries(ours.Releases), releaseEntries(theirs.Releases))
+	merged.Releases = make([]Release, 0, len(releases))
+	for _, entry := range releases {
+		merged.Releases = append(merged.Releases, Release{Name: entry.Name, Version: entry.Version, SHA1: entry.SHA1})
+	}
+	conflicts = append(conflicts, releaseConflicts...)
+
+	stemcell, conflict := mergeStemcellCriteria(base.Stemcell, ours.Stemcell, theirs.Stemcell)
+	merged.Stemcell = stemcell
+	if conflict != nil {
+		conflicts = append(conflicts, *conflict)
+	}
+
+	additionalStemcells, stemcellConflicts := mergeEntries("stemcell", stemcellEntries(base.AdditionalStemcells), stemcellEntries(ours.AdditionalStemcells), stemcellEntries(theirs.AdditionalStemcells))
+	merged.AdditionalStemcells = nil
+	for _, entry := range additionalStemcells {
+		merged.AdditionalStemcells = append(merged.AdditionalStemcells, Stemcell{Alias: entry.Alias, OS: entry.Name, Version: entry.Version})
+	}
+	conflicts = append(conflicts, stemcellConflicts...)
+
+	return merged, conflicts
+}
+
+func mergeStemcellCriteria(base, ours, theirs Stemcell) (Stemcell, *MergeConflict) {
+	switch {
+	case ours == theirs, theirs == base:
+		return ours, nil
+	case ours == base:
+		return theirs, nil
+	}
+
+	reason := "both sides changed the stemcell os"
+	if ours.OS == theirs.OS {
+		var winner *lockEntry
+		winner, reason = higherEntry(&lockEntry{Name: ours.OS, Version: ours.Version}, &lockEntry{Name: theirs.OS, Version: theirs.Version})
+		if winner != nil {
+			return Stemcell{Alias: ours.Alias, OS: winner.Name, Version: winner.Version}, nil
+		}
+	}
+
+	return ours, &MergeConflict{
+		Kind:   "stemcell",
+		Name:   "stemcell_criteria",
+		Reason: reason,
+		Base:   describeStemcell(base),
+		Ours:   describeStemcell(ours),
+		Theirs: describeStemcell(theirs),
+	}
+}
+
+// mergeEntries keeps the order of ours and appends the entries only theirs added.
+func mergeEntries(kind string, base, ours, theirs []lockEntry) ([]lockEntry, []MergeConflict) {
+	baseByKey, oursByKey, theirsByKey := entriesByKey(base), entriesByKey(ours), entriesByKey(theirs)
+
+	var keys []string
+	for _, entry := range ours {
+		keys = append(keys, entry.Key)
+	}
+	for _, entry := range theirs {
+		if _, ok := oursByKey[entry.Key]; !ok {
+			keys = append(keys, entry.Key)
+		}
+	}
+
+	var (
+		merged    []lockEntry
+		conflicts []MergeConflict
+	)
+	for _, key := range keys {
+		entry, conflict := mergeEntry(baseByKey[key], oursByKey[key], theirsByKey[key])
+		if conflict != "" {
+			conflicts = append(conflicts, MergeConflict{
+				Kind:   kind,
+				Name:   key,
+				Reason: conflict,
+				Base:   baseByKey[key].String(),
+				Ours:   oursByKey[key].String(),
+				Theirs: theirsByKey[key].String(),
+			})
+			entry = oursByKey[key]
+		}
+		if entry != nil {
+			merged = append(merged, *entry)
+		}
+	}
+
+	return merged, conflicts
+}
+
+func mergeEntry(base, ours, theirs *lockEntry) (*lockEntry, string) {
+	switch {
+	case sameEntry(ours, theirs), sameEntry(theirs, base):
+		return ours, ""
+	case sameEntry(ours, base):
+		return theirs, ""
+	case ours == nil:
+		return nil, "removed in ours but changed in theirs"
+	case theirs == nil:
+		return nil, "changed in ours but removed in theirs"
+	}
+
+	return higherEntry(ours, theirs)
+}
+
+// higherEntry returns the entry with the higher version, or a reason why
+// the entries can not be ordered.
+func higherEntry(ours, theirs *lockEntry) (*lockEntry, string) {
+	if ours.Version == theirs.Version {
+		return nil, "both sides have the same version with different sha1s"
+	}
+
+	oursVersion, err := semver.NewVersion(ours.Version)
+	if err != nil {
+		return nil, fmt.Sprintf("can not compare versions: %s", err)
+	}
+	theirsVersion, err := semver.NewVersion(theirs.Version)
+	if err != nil {
+		return nil, fmt.Sprintf("can not compare versions: %s", err)
+	}
+
+	if theirsVersion.GreaterThan(oursVersion) {
+		return theirs, ""
+	}
+	return ours, ""
+}
+
+func sameEntry(a, b *lockEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func entriesByKey(entries []lockEntry) map[string]*lockEntry {
+	byKey := make(map[string]*lockEntry, len(entries))
+	for i := range entries {
+		byKey[entries[i].Key] = &entries[i]
+	}
+	return byKey
+}
+
+func releaseEntries(releases []Release) []lockEntry {
+	entries := make([]lockEntry, 0, len(releases))
+	for _, release := range releases {
+		entries = append(entries, lockEntry{Key: release.Name, Name: release.Name, Version: release.Version, SHA1: release.SHA1})
+	}
+	return entries
+}
+
+func stemcellEntries(stemcells []Stemcell) []lockEntry {
+	entries := make([]lockEntry, 0, len(stemcells))
+	for i, key := range StemcellKeys(stemcells) {
+		stemcell := stemcells[i]
+		entries = append(entries, lockEntry{Key: key, Name: stemcell.OS, Alias: stemcell.Alias, Version: stemcell.Version})
+	}
+	return entries
+}
+
+func describeStemcell(stemcell Stemcell) string {
+	if stemcell.OS == "" && stemcell.Version == "" {
+		return ""
+	}
+	return stemcell.OS + " " + stemcell.Version
+}
+
+func orNone(description string) string {
+	if description == "" {
+		return "none"
+	}
+	return description
+}
@@ -0,0 +1,159 @@
+package cargo_test
+
+import (
+	"github.com/pivotal-cf/kiln/internal/cargo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MergeKilnfileLocks", func() {
+	var base, ours, theirs cargo.KilnfileLock
+
+	BeforeEach(func() {
+		base = cargo.KilnfileLock{
+			Releases: []cargo.Release{
+				{Name: "bpm", Version: "1.1.0", SHA1: "a"},
+				{Name: "capi", Version: "1.80.0", SHA1: "b"},
+				{Name: "diego", Version: "2.30.0", SHA1: "c"},
+			},
+			Stemcell: cargo.Stemcell{OS: "ubuntu-xenial", Version: "621.71"},
+		}
+		ours = base
+		ours.Releases = append([]cargo.Release{}, base.Releases...)
+		theirs = base
+		theirs.Releases = append([]cargo.Release{}, base.Releases...)
+	})
+
+	It("takes the changes each side made to different releases", func() {
+		ours.Releases[0] = cargo.Release{Name: "bpm", Version: "1.1.1", SHA1: "d"}
+		theirs.Releases[2] = cargo.Release{Name: "diego", Version: "2.31.0", SHA1: "e"}
+		theirs.Releases = append(theirs.Releases, cargo.Release{Name: "uaa", Version: "74.0.0", SHA1: "f"})
+
+		merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.Releases).To(Equal([]cargo.Release{
+			{Name: "bpm", Version: "1.1.1", SHA1: "d"},
+			{Name: "capi", Version: "1.80.0", SHA1: "b"},
+			{Name: "diego", Version: "2.31.0", SHA1: "e"},
+			{Name: "uaa", Version: "74.0.0", SHA1: "f"},
+		}))
+	})
+
+	It("drops releases one side removed and the other left alone", func() {
+		ours.Releases = ours.Releases[:2]
+
+		merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.Releases).To(Equal([]cargo.Release{
+			{Name: "bpm", Version: "1.1.0", SHA1: "a"},
+			{Name: "capi", Version: "1.80.0", SHA1: "b"},
+		}))
+	})
+
+	It("takes the higher version when both sides bumped a release", func() {
+		ours.Releases[1] = cargo.Release{Name: "capi", Version: "1.81.0", SHA1: "d"}
+		theirs.Releases[1] = cargo.Release{Name: "capi", Version: "1.82.0", SHA1: "e"}
+
+		merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.Releases[1]).To(Equal(cargo.Release{Name: "capi", Version: "1.82.0", SHA1: "e"}))
+	})
+
+	It("takes the higher stemcell version when both sides bumped it", func() {
+		ours.Stemcell.Version = "621.74"
+		theirs.Stemcell.Version = "621.73"
+
+		merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.Stemcell).To(Equal(cargo.Stemcell{OS: "ubuntu-xenial", Version: "621.74"}))
+	})
+
+	It("merges additional stemcells by os", func() {
+		base.AdditionalStemcells = []cargo.Stemcell{{OS: "windows2019", Version: "2019.7"}}
+		ours.AdditionalStemcells = []cargo.Stemcell{{OS: "windows2019", Version: "2019.8"}}
+		theirs.AdditionalStemcells = []cargo.Stemcell{{OS: "windows2019", Version: "2019.7"}, {OS: "ubuntu-bionic", Version: "1.10"}}
+
+		merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.AdditionalStemcells).To(Equal([]cargo.Stemcell{
+			{OS: "windows2019", Version: "2019.8"},
+			{OS: "ubuntu-bionic", Version: "1.10"},
+		}))
+	})
+
+	It("merges several additional stemcells of one os by position and keeps their aliases", func() {
+		base.AdditionalStemcells = []cargo.Stemcell{{OS: "x", Version: "1"}, {OS: "x", Version: "2"}, {OS: "y", Alias: "y-fips", Version: "1.1"}}
+		ours.AdditionalStemcells = []cargo.Stemcell{{OS: "x", Version: "1"}, {OS: "x", Version: "2"}, {OS: "y", Alias: "y-fips", Version: "1.2"}}
+		theirs.AdditionalStemcells = []cargo.Stemcell{{OS: "x", Version: "1"}, {OS: "x", Version: "3"}, {OS: "y", Alias: "y-fips", Version: "1.1"}}
+
+		merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+		Expect(conflicts).To(BeEmpty())
+		Expect(merged.AdditionalStemcells).To(Equal([]cargo.Stemcell{
+			{OS: "x", Version: "1"},
+			{OS: "x", Version: "3"},
+			{OS: "y", Alias: "y-fips", Version: "1.2"},
+		}))
+	})
+
+	When("both sides have the same version with different sha1s", func() {
+		It("reports a conflict and keeps ours", func() {
+			ours.Releases[0] = cargo.Release{Name: "bpm", Version: "1.1.1", SHA1: "d"}
+			theirs.Releases[0] = cargo.Release{Name: "bpm", Version: "1.1.1", SHA1: "e"}
+
+			merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+			Expect(conflicts).To(Equal([]cargo.MergeConflict{{
+				Kind:   "release",
+				Name:   "bpm",
+				Reason: "both sides have the same version with different sha1s",
+				Base:   "1.1.0 (sha1 a)",
+				Ours:   "1.1.1 (sha1 d)",
+				Theirs: "1.1.1 (sha1 e)",
+			}}))
+			Expect(merged.Releases[0]).To(Equal(cargo.Release{Name: "bpm", Version: "1.1.1", SHA1: "d"}))
+			Expect(conflicts[0].String()).To(Equal(`release "bpm": both sides have the same version with different sha1s (base: 1.1.0 (sha1 a), ours: 1.1.1 (sha1 d), theirs: 1.1.1 (sha1 e))`))
+		})
+	})
+
+	When("one side removed a release the other changed", func() {
+		It("reports a conflict and keeps ours", func() {
+			ours.Releases = ours.Releases[1:]
+			theirs.Releases[0] = cargo.Release{Name: "bpm", Version: "1.1.1", SHA1: "d"}
+
+			merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+			Expect(conflicts).To(HaveLen(1))
+			Expect(conflicts[0].Reason).To(Equal("removed in ours but changed in theirs"))
+			Expect(conflicts[0].String()).To(ContainSubstring("ours: none"))
+			Expect(merged.Releases).To(HaveLen(2))
+		})
+	})
+
+	When("the versions can not be compared", func() {
+		It("reports a conflict", func() {
+			ours.Releases[0].Version = "latest"
+			theirs.Releases[0].Version = "1.2.0"
+
+			_, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+			Expect(conflicts).To(HaveLen(1))
+			Expect(conflicts[0].Reason).To(HavePrefix("can not compare versions"))
+		})
+	})
+
+	When("both sides changed the stemcell os", func() {
+		It("reports a conflict", func() {
+			ours.Stemcell = cargo.Stemcell{OS: "ubuntu-bionic", Version: "1.10"}
+			theirs.Stemcell = cargo.Stemcell{OS: "windows2019", Version: "2019.7"}
+
+			merged, conflicts := cargo.MergeKilnfileLocks(base, ours, theirs)
+			Expect(conflicts).To(Equal([]cargo.MergeConflict{{
+				Kind:   "stemcell",
+				Name:   "stemcell_criteria",
+				Reason: "both sides changed the stemcell os",
+				Base:   "ubuntu-xenial 621.71",
+				Ours:   "ubuntu-bionic 1.10",
+				Theirs: "windows2019 2019.7",
+			}}))
+			Expect(merged.Stemcell).To(Equal(ours.Stemcell))
+		})
+	})
+})
@@ -0,0 +1,164 @@
+package cargo_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pivotal-cf/kiln/internal/cargo"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseKilnfile", func() {
+	It("decodes the Kilnfile", func() {
+		kilnfile, err := cargo.ParseKilnfile([]byte("slug: some-slug\nstemcell_criteria:\n  os: ubuntu-xenial\n  version: \"621.*\"\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(kilnfile).To(Equal(cargo.Kilnfile{
+			Slug:     "some-slug",
+			Stemcell: cargo.Stemcell{OS: "ubuntu-xenial", Version: "621.*"},
+		}))
+	})
+
+	When("a key is misspelled", func() {
+		It("returns an error", func() {
+			_, err := cargo.ParseKilnfile([]byte("release_sources:\n- type: s3\n  acess_key_id: some-key\n"))
+			Expect(err).To(MatchError(ContainSubstring("field acess_key_id not found")))
+		})
+	})
+})
+
+var _ = Describe("ParseKilnfileLock", func() {
+	It("allows top level keys kiln does not manage", func() {
+		lock, err := cargo.ParseKilnfileLock([]byte("releases:\n- name: bpm\n  version: 1.1.0\n  sha1: a\nsome_team_notes: keep me\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(lock.Releases).To(Equal([]cargo.Release{{Name: "bpm", Version: "1.1.0", SHA1: "a"}}))
+	})
+
+	When("a key inside a release is misspelled", func() {
+		It("returns an error", func() {
+			_, err := cargo.ParseKilnfileLock([]byte("releases:\n- name: bpm\n  version: 1.1.0\n  shaa1: a\n"))
+			Expect(err).To(MatchError(ContainSubstring("field shaa1 not found")))
+		})
+	})
+})
+
+var _ = Describe("Kilnfile", func() {
+	Describe("Validate", func() {
+		It("accepts complete sources", func() {
+			kilnfile := cargo.Kilnfile{
+				Stemcell: cargo.Stemcell{OS: "ubuntu-xenial", Version: "621.*"},
+				ReleaseSources: []cargo.ReleaseSourceConfig{
+					{Type: "bosh.io"},
+					{Type: "s3", Bucket: "b", Region: "r", AccessKeyId: "a", SecretAccessKey: "s", Regex: `(?P<release_name>.*)-(?P<release_version>.*)\.tgz`},
+				},
+				StemcellSources: []cargo.StemcellSourceConfig{
+					{OS: "ubuntu-xenial", Slug: "stemcells-ubuntu-xenial"},
+					{OS: "windows2019", Type: "directory", Path: "stemcells"},
+				},
+			}
+			Expect(kilnfile.Validate()).To(Succeed())
+		})
+
+		It("reports every problem", func() {
+			kilnfile := cargo.Kilnfile{
+				Stemcell:            cargo.Stemcell{OS: "ubuntu-xenial", Version: "not-a-constraint"},
+				AdditionalStemcells: []cargo.Stemcell{{Version: "1.*"}},
+				ReleaseSources: []cargo.ReleaseSourceConfig{
+					{Type: "s3", Region: "r", AccessKeyId: "a", SecretAccessKey: "s", Regex: `(?P<release_name>.*)\.tgz`},
+					{Type: "bosh.io", Bucket: "b"},
+					{Type: "ftp"},
+					{},
+				},
+				StemcellSources: []cargo.StemcellSourceConfig{
+					{OS: "windows2019", Type: "bosh.io"},
+					{OS: "windows2019", Type: "directory"},
+				},
+			}
+
+			err := kilnfile.Validate()
+			Expect(err).To(BeAssignableToTypeOf(cargo.ValidationError{}))
+			Expect(err.(cargo.ValidationError)).To(ConsistOf(
+				ContainSubstring(`stemcell_criteria: version "not-a-constraint" is not a valid constraint`),
+				"additional_stemcells_criteria[0]: os is required",
+				"release_sources[0]: bucket is required for s3 release sources",
+				"release_sources[0]: regex is missing the release_version capture group",
+				"release_sources[1]: bosh.io release sources do not take any other keys",
+				`release_sources[2]: unknown type "ftp", expected s3 or bosh.io`,
+				"release_sources[3]: type is required",
+				"stemcell_sources[0]: name is required for bosh.io stemcell sources",
+				`stemcell_sources[1]: os "windows2019" is listed more than once`,
+				"stemcell_sources[1]: path is required for directory stemcell sources",
+			))
+			Expect(err).To(MatchError(ContainSubstring("\n- release_sources[3]: type is required")))
+		})
+	})
+
+	Describe("ValidateReleaseSources", func() {
+		It("ignores stemcell configuration", func() {
+			kilnfile := cargo.Kilnfile{
+				Stemcell:       cargo.Stemcell{Version: "not-a-constraint"},
+				ReleaseSources: []cargo.ReleaseSourceConfig{{Type: "bosh.io"}},
+			}
+			Expect(kilnfile.ValidateReleaseSources()).To(Succeed())
+		})
+	})
+})
+
+var _ = Describe("KilnfileLock", func() {
+	Describe("Validate", func() {
+		It("reports releases and stemcells without a name or version", func() {
+			lock := cargo.KilnfileLock{
+				Releases: []cargo.Release{
+					{Name: "bpm", Version: "1.1.0"},
+					{Name: "bpm", Version: "1.1.1"},
+					{Version: "1.0.0"},
+					{Name: "capi"},
+				},
+				AdditionalStemcells: []cargo.Stemcell{{OS: "windows2019"}},
+			}
+
+			Expect(lock.Validate()).To(MatchError(
+				"\n- releases[1]: release \"bpm\" is listed more than once" +
+					"\n- releases[2]: name is required" +
+					"\n- releases[3]: version is required" +
+					"\n- additional_stemcells_criteria[0]: version is required",
+			))
+		})
+	})
+})
+
+var _ = Describe("KilnfileJSONSchema", func() {
+	It("matches the schema published in the repository", func() {
+		schema, err := cargo.KilnfileJSONSchema()
+		Expect(err).NotTo(HaveOccurred())
+
+		published, err := ioutil.ReadFile(filepath.Join("..", "..", "schemas", "kilnfile.schema.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(schema)).To(Equal(string(published)), "run `kiln validate-kilnfile --schema > schemas/kilnfile.schema.json`")
+	})
+
+	It("rejects unknown keys and lists the allowed source types", func() {
+		schemaJSON, err := cargo.KilnfileJSONSchema()
+		Expect(err).NotTo(HaveOccurred())
+
+		var schema struct {
+			AdditionalProperties interface{} `json:"additionalProperties"`
+			Properties           struct {
+				ReleaseSources struct {
+					Items struct {
+						Properties struct {
+							Type struct {
+								Enum []string `json:"enum"`
+							} `json:"type"`
+						} `json:"properties"`
+					} `json:"items"`
+				} `json:"release_sources"`
+			} `json:"properties"`
+		}
+		Expect(json.Unmarshal(schemaJSON, &schema)).To(Succeed())
+		Expect(schema.AdditionalProperties).To(Equal(false))
+		Expect(schema.Properties.ReleaseSources.Items.Properties.Type.Enum).To(Equal([]string{"s3", "bosh.io"}))
+	})
+})
@@ -1,5 +1,10 @@
 package cargo
 
+import (
+	"fmt"
+	"strconv"
+)
+
 type Manifest struct {
 	Name           string          `yaml:"name"`
 	Releases       []Release       `yaml:"releases"`
@@ -26,20 +31,47 @@ type Release struct {
 	Version string `yaml:"version"`
 }
 
+// ReleaseTarballName is the file name kiln fetch gives a release tarball. The
+// name of a compiled release also records the stemcell it was compiled
+// against, so stemcellOS and stemcellVersion are empty for other releases.
+func ReleaseTarballName(name, version, stemcellOS, stemcellVersion string) string {
+	if stemcellOS != "" {
+		return fmt.Sprintf("%s-%s-%s-%s.tgz", name, version, stemcellOS, stemcellVersion)
+	}
+	return fmt.Sprintf("%s-%s.tgz", name, version)
+}
+
 type KilnfileLock struct {
-	Releases []Release `yaml:"releases"`
-	Stemcell Stemcell  `yaml:"stemcell_criteria"`
+	Releases            []Release  `yaml:"releases"`
+	Stemcell            Stemcell   `yaml:"stemcell_criteria"`
+	AdditionalStemcells []Stemcell `yaml:"additional_stemcells_criteria,omitempty"`
 }
 
 type Kilnfile struct {
-	Stemcell        Stemcell              `yaml:"stemcell_criteria"`
-	ReleaseSources  []ReleaseSourceConfig `yaml:"release_sources"`
-	Slug            string                `yaml:"slug"`
-	PreGaUserGroups []string              `yaml:"pre_ga_user_groups"`
+	Extends             string                 `yaml:"extends"`
+	Include             []string               `yaml:"include"`
+	Stemcell            Stemcell               `yaml:"stemcell_criteria"`
+	AdditionalStemcells []Stemcell             `yaml:"additional_stemcells_criteria"`
+	StemcellSources     []StemcellSourceConfig `yaml:"stemcell_sources"`
+	ReleaseSources      []ReleaseSourceConfig  `yaml:"release_sources"`
+	Slug                string                 `yaml:"slug"`
+	PreGaUserGroups     []string               `yaml:"pre_ga_user_groups"`
+	Bake                BakeConfig             `yaml:"bake"`
+	BakeProfiles        map[string]BakeConfig  `yaml:"bake_profiles"`
+}
+
+// StemcellSourceConfig tells `kiln update` where to look up the available
+// versions of the stemcell line named by OS.
+type StemcellSourceConfig struct {
+	OS   string `yaml:"os"`
+	Type string `yaml:"type" enum:"pivnet,bosh.io,directory"`
+	Slug string `yaml:"slug"`
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
 }
 
 type ReleaseSourceConfig struct {
-	Type            string `yaml:"type"`
+	Type            string `yaml:"type" enum:"s3,bosh.io"`
 	Compiled        bool   `yaml:"compiled"`
 	Bucket          string `yaml:"bucket"`
 	Region          string `yaml:"region"`
@@ -86,3 +118,26 @@ type InstanceGroupJob struct {
 	Consumes   interface{} `yaml:"consumes"`
 	Properties interface{} `yaml:"properties"`
 }
+
+// StemcellKeys identifies each stemcell by its os and alias. Stemcells with
+// the same os and alias, such as two versions of one os, are told apart by
+// their position among them, so that the nth criteria for an os lines up with
+// the nth locked stemcell for it.
+func StemcellKeys(stemcells []Stemcell) []string {
+	counts := map[string]int{}
+	keys := make([]string, 0, len(stemcells))
+	for _, stemcell := range stemcells {
+		key := stemcell.OS
+		if stemcell.Alias != "" {
+			key += " (" + stemcell.Alias + ")"
+		}
+
+		counts[key]++
+		if counts[key] > 1 {
+			key += " #" + strconv.Itoa(counts[key])
+		}
+
+		keys = append(keys, key)
+	}
+	return keys
+}
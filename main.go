@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/src-d/go-billy.v4/osfs"
 
@@ -58,31 +59,34 @@ func main() {
 	tileWriter := builder.NewTileWriter(filesystem, &zipper, errLogger)
 
 	releaseManifestReader := builder.NewReleaseManifestReader()
-	releasesService := baking.NewReleasesService(errLogger, releaseManifestReader)
+	releaseManifestCache := builder.NewReleaseManifestCache(releaseManifestReader, releaseManifestCachePath(), errLogger)
+	releasesService := baking.NewReleasesService(errLogger, releaseManifestCache)
 
 	stemcellManifestReader := builder.NewStemcellManifestReader(filesystem)
 	stemcellService := baking.NewStemcellService(errLogger, stemcellManifestReader)
 
 	templateVariablesService := baking.NewTemplateVariablesService()
 
-	boshVariableDirectoryReader := builder.NewMetadataPartsDirectoryReader()
+	boshVariableDirectoryReader := builder.NewMetadataPartsDirectoryReaderWithOptionalOrder("", "variables")
 	boshVariablesService := baking.NewBOSHVariablesService(errLogger, boshVariableDirectoryReader)
 
-	formDirectoryReader := builder.NewMetadataPartsDirectoryReader()
+	formDirectoryReader := builder.NewMetadataPartsDirectoryReaderWithOptionalOrder("", "form_types")
 	formsService := baking.NewFormsService(errLogger, formDirectoryReader)
 
-	instanceGroupDirectoryReader := builder.NewMetadataPartsDirectoryReader()
+	instanceGroupDirectoryReader := builder.NewMetadataPartsDirectoryReaderWithOptionalOrder("", "job_types")
 	instanceGroupsService := baking.NewInstanceGroupsService(errLogger, instanceGroupDirectoryReader)
 
-	jobsDirectoryReader := builder.NewMetadataPartsDirectoryReader()
+	jobsDirectoryReader := builder.NewMetadataPartsDirectoryReaderWithOptionalOrder("", "jobs")
 	jobsService := baking.NewJobsService(errLogger, jobsDirectoryReader)
 
-	propertiesDirectoryReader := builder.NewMetadataPartsDirectoryReader()
+	propertiesDirectoryReader := builder.NewMetadataPartsDirectoryReaderWithOptionalOrder("", "property_blueprints")
 	propertiesService := baking.NewPropertiesService(errLogger, propertiesDirectoryReader)
 
-	runtimeConfigsDirectoryReader := builder.NewMetadataPartsDirectoryReader()
+	runtimeConfigsDirectoryReader := builder.NewMetadataPartsDirectoryReaderWithOptionalOrder("", "runtime_configs")
 	runtimeConfigsService := baking.NewRuntimeConfigsService(errLogger, runtimeConfigsDirectoryReader)
 
+	snippetsService := baking.NewSnippetsService(errLogger)
+
 	iconService := baking.NewIconService(errLogger)
 
 	metadataService := baking.NewMetadataService()
@@ -103,6 +107,7 @@ func main() {
 		tileWriter,
 		outLogger,
 		templateVariablesService,
+		baking.NewVariablesSchemaService(),
 		boshVariablesService,
 		releasesService,
 		stemcellService,
@@ -111,13 +116,28 @@ func main() {
 		jobsService,
 		propertiesService,
 		runtimeConfigsService,
+		snippetsService,
 		iconService,
 		metadataService,
+		builder.NewOpsFilePatcher(),
+		builder.NewSecretScanner(),
 		checksummer,
+		commands.NewFetch(errLogger, fetcher.NewReleaseSourcesFactory(errLogger), fetcher.NewLocalReleaseDirectory(errLogger, releasesService)),
 	)
 
+	commandSet["lock"] = commands.NewLock(jhanda.CommandSet{
+		"diff":  commands.NewLockDiff(outLogger),
+		"merge": commands.NewLockMerge(outLogger),
+	})
+
+	commandSet["init"] = commands.NewInit(outLogger, osfs.New(""))
+	commandSet["validate-kilnfile"] = commands.NewValidateKilnfile(outLogger)
+
 	commandSet["update"] = commands.Update{
-		StemcellsVersionsService: new(fetcher.Pivnet),
+		OutLogger:                      outLogger,
+		StemcellsVersionsService:       new(fetcher.Pivnet),
+		BOSHIOStemcellsVersionsService: fetcher.NewBOSHIOStemcellVersions(""),
+		LocalStemcellsVersionsService:  fetcher.NewLocalStemcellDirectory(stemcellManifestReader),
 	}
 
 	err = commandSet.Execute(command, args)
@@ -125,3 +145,11 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+func releaseManifestCachePath() string {
+	cacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		cacheDirectory = os.TempDir()
+	}
+	return filepath.Join(cacheDirectory, "kiln", "release-manifests.json")
+}